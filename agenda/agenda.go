@@ -0,0 +1,154 @@
+// Package agenda lists the upcoming events of a Google Calendar:
+// calbot answers /agenda with it, and the calendar skill of slackbot
+// the agenda command.
+//
+// The OAuth token of the calendar is asked once by calbot, which
+// caches it in TokenFile:
+//
+//	conf, err := agenda.Config("client_secret.json")
+//	...
+//	srv, err := agenda.NewService(ctx, conf, filename)
+//	...
+//	router.Handle("agenda", agenda.Handler(srv, nil))
+package agenda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/command"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/calendar/v3"
+)
+
+// TokenFile returns where the token of the calendar is cached,
+// ~/.credentials/calendar-go-quickstart.json.
+func TokenFile() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, ".credentials", "calendar-go-quickstart.json"), nil
+}
+
+// Config reads the OAuth client of the app from secretFile. The RSVP
+// buttons of calbot need to write events.
+func Config(secretFile string) (*oauth2.Config, error) {
+	b, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		return nil, err
+	}
+	return google.ConfigFromJSON(b, calendar.CalendarScope)
+}
+
+// TokenFromFile reads a token cached in filename.
+func TokenFromFile(filename string) (*oauth2.Token, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tok := &oauth2.Token{}
+	err = json.NewDecoder(f).Decode(tok)
+	return tok, err
+}
+
+// SaveToken caches tok in filename, readable by the user only.
+func SaveToken(filename string, tok *oauth2.Token) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0600)
+}
+
+// NewService connects to the calendar with the token cached in
+// tokenFile.
+func NewService(ctx context.Context, conf *oauth2.Config, tokenFile string) (*calendar.Service, error) {
+	tok, err := TokenFromFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("agenda: no token, run calbot to grant access to the calendar: %v", err)
+	}
+	return calendar.New(conf.Client(ctx, tok))
+}
+
+// Upcoming returns the next n events of the primary calendar.
+func Upcoming(srv *calendar.Service, n int64) ([]*calendar.Event, error) {
+	t := time.Now().Format(time.RFC3339)
+	events, err := srv.Events.List("primary").ShowDeleted(false).
+		SingleEvents(true).TimeMin(t).MaxResults(n).OrderBy("startTime").Do()
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+// When returns the start of an event.
+func When(e *calendar.Event) string {
+	// All-day events only have a date.
+	if e.Start.DateTime != "" {
+		return e.Start.DateTime
+	}
+	return e.Start.Date
+}
+
+// Blocks shows events, each followed by the block of actions, if not
+// nil.
+func Blocks(items []*calendar.Event, actions func(e *calendar.Event) blockkit.Block) blockkit.Blocks {
+	blocks := blockkit.Blocks{&blockkit.Header{Text: blockkit.Plain("Upcoming events")}}
+	for _, e := range items {
+		text := fmt.Sprintf("*%s*\n%s", e.Summary, When(e))
+		if e.HtmlLink != "" {
+			text = fmt.Sprintf("*<%s|%s>*\n%s", e.HtmlLink, e.Summary, When(e))
+		}
+		blocks = append(blocks, &blockkit.Section{Text: blockkit.Markdown(text)})
+		if actions != nil {
+			blocks = append(blocks, actions(e))
+		}
+	}
+	return blocks
+}
+
+// Handler answers "agenda [n]" with the next n events, five by
+// default, laid out by Blocks.
+func Handler(srv *calendar.Service, actions func(e *calendar.Event) blockkit.Block) command.HandlerFunc {
+	return func(ctx context.Context, req *command.Request) (*command.Response, error) {
+		n := int64(5)
+		if len(req.Args) > 0 {
+			v, err := strconv.ParseInt(req.Args[0], 10, 64)
+			if err != nil || v < 1 || v > 20 {
+				usage := "Usage: agenda [1-20]"
+				if req.Source == command.Slash {
+					usage = "Usage: /agenda [1-20]"
+				}
+				return &command.Response{Text: usage}, nil
+			}
+			n = v
+		}
+		items, err := Upcoming(srv, n)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return &command.Response{Text: "No upcoming events found."}, nil
+		}
+		text := "Upcoming events:"
+		for _, e := range items {
+			text += fmt.Sprintf("\n• %s (%s)", e.Summary, When(e))
+		}
+		// A slow calendar answers in place of the acknowledgment.
+		return &command.Response{Text: text, Blocks: Blocks(items, actions), ReplaceOriginal: true}, nil
+	}
+}
@@ -0,0 +1,110 @@
+package agenda
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/command"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// fakeCalendar serves the events of the primary calendar, and records
+// the number of events asked.
+func fakeCalendar(t *testing.T, events string, asked *string) *calendar.Service {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/calendars/primary/events" {
+			http.NotFound(w, r)
+			return
+		}
+		*asked = r.URL.Query().Get("maxResults")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items": ` + events + `}`))
+	}))
+	t.Cleanup(s.Close)
+	srv, err := calendar.New(s.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.BasePath = s.URL + "/"
+	return srv
+}
+
+func TestHandler(t *testing.T) {
+	events := `[
+		{"id": "e1", "summary": "Standup", "htmlLink": "https://cal/e1", "start": {"dateTime": "2020-01-02T09:30:00Z"}},
+		{"id": "e2", "summary": "Holiday", "start": {"date": "2020-01-03"}}
+	]`
+	tests := []struct {
+		source string
+		args   []string
+		events string
+		asked  string
+		text   string
+	}{
+		{command.RTM, nil, events, "5", "Upcoming events:\n• Standup (2020-01-02T09:30:00Z)\n• Holiday (2020-01-03)"},
+		{command.Slash, []string{"2"}, events, "2", "Upcoming events:\n• Standup (2020-01-02T09:30:00Z)\n• Holiday (2020-01-03)"},
+		{command.RTM, nil, `[]`, "5", "No upcoming events found."},
+		{command.RTM, []string{"21"}, events, "", "Usage: agenda [1-20]"},
+		{command.Slash, []string{"soon"}, events, "", "Usage: /agenda [1-20]"},
+	}
+	for _, tt := range tests {
+		asked := ""
+		h := Handler(fakeCalendar(t, tt.events, &asked), nil)
+		resp, err := h(context.Background(), &command.Request{Name: "agenda", Args: tt.args, Source: tt.source})
+		if err != nil {
+			t.Errorf("agenda %q: %v", tt.args, err)
+			continue
+		}
+		if resp.Text != tt.text || asked != tt.asked {
+			t.Errorf("agenda %q = %q, asked %q events; want %q, asked %q", tt.args, resp.Text, asked, tt.text, tt.asked)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	items := []*calendar.Event{
+		{Id: "e1", Summary: "Standup", HtmlLink: "https://cal/e1", Start: &calendar.EventDateTime{DateTime: "2020-01-02T09:30:00Z"}},
+		{Id: "e2", Summary: "Holiday", Start: &calendar.EventDateTime{Date: "2020-01-03"}},
+	}
+	blocks := Blocks(items, nil)
+	if len(blocks) != 3 {
+		t.Fatalf("%d blocks, want a header and a section per event", len(blocks))
+	}
+	if text := blocks[1].(*blockkit.Section).Text.Text; text != "*<https://cal/e1|Standup>*\n2020-01-02T09:30:00Z" {
+		t.Errorf("section of a linked event = %q", text)
+	}
+	if text := blocks[2].(*blockkit.Section).Text.Text; text != "*Holiday*\n2020-01-03" {
+		t.Errorf("section of an all-day event = %q", text)
+	}
+
+	blocks = Blocks(items, func(e *calendar.Event) blockkit.Block {
+		return &blockkit.Divider{BlockID: e.Id}
+	})
+	if len(blocks) != 5 || blocks[2].(*blockkit.Divider).BlockID != "e1" || blocks[4].(*blockkit.Divider).BlockID != "e2" {
+		t.Errorf("actions not after their event: %+v", blocks)
+	}
+}
+
+func TestToken(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "credentials", "calendar.json")
+	conf := &oauth2.Config{}
+	if _, err := NewService(context.Background(), conf, filename); err == nil || !strings.Contains(err.Error(), "run calbot") {
+		t.Errorf("NewService without token = %v", err)
+	}
+	if err := SaveToken(filename, &oauth2.Token{AccessToken: "ya29.test", RefreshToken: "1/refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := TokenFromFile(filename)
+	if err != nil || tok.AccessToken != "ya29.test" || tok.RefreshToken != "1/refresh" {
+		t.Errorf("TokenFromFile = %+v, %v", tok, err)
+	}
+	if _, err := NewService(context.Background(), conf, filename); err != nil {
+		t.Errorf("NewService = %v", err)
+	}
+}
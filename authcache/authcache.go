@@ -0,0 +1,149 @@
+// Package authcache reads the tokens authsrv caches for the
+// workspaces the app is installed in, one JSON file per workspace.
+package authcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Webhook is the incoming webhook created when the app was installed.
+type Webhook struct {
+	URL       string `json:"url"`
+	Chan      string `json:"channel"`
+	ConfigURL string `json:"configuration_url"`
+}
+
+// DefaultDir returns where authsrv caches tokens,
+// ~/.credentials/authsrv-slack.
+func DefaultDir() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(usr.HomeDir, ".credentials", "authsrv-slack"), nil
+}
+
+// Webhooks maps workspace channels to the incoming webhooks cached in a
+// directory. The directory is read again when a file is added,
+// changed or removed, so new installs are picked up without restart.
+type Webhooks struct {
+	dir string
+
+	mu    sync.Mutex
+	hooks []teamWebhook
+	stamp string
+}
+
+// teamWebhook is the webhook of one workspace, the team is known by
+// its ID and by its name.
+type teamWebhook struct {
+	Webhook
+	teamID, teamName string
+}
+
+// NewWebhooks reads the webhooks cached in dir.
+func NewWebhooks(dir string) (*Webhooks, error) {
+	w := &Webhooks{dir: dir}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// URL returns the webhook of channel in team, "" if there is none.
+// The team is an ID or a name; an empty team matches any workspace,
+// as long as a single one has a webhook for channel. When the
+// directory can't be read, the last webhooks read are used and the
+// error returned along.
+func (w *Webhooks) URL(team, channel string) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.reload()
+	url := ""
+	for _, hook := range w.hooks {
+		if hook.Chan != channel || (team != "" && team != hook.teamID && team != hook.teamName) {
+			continue
+		}
+		if url != "" {
+			return "", fmt.Errorf("authcache: channel %s has a webhook in several workspaces, the team is required", channel)
+		}
+		url = hook.URL
+	}
+	return url, err
+}
+
+// reload reads the directory again if it changed. The caller must
+// hold w.mu, or own w.
+func (w *Webhooks) reload() error {
+	files, err := filepath.Glob(filepath.Join(w.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	var stamp strings.Builder
+	for _, filename := range files {
+		fi, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&stamp, "%s %d %d\n", filename, fi.Size(), fi.ModTime().UnixNano())
+	}
+	if w.hooks != nil && stamp.String() == w.stamp {
+		return nil
+	}
+
+	// A broken file only hides the webhook of its workspace.
+	hooks := []teamWebhook{}
+	for _, filename := range files {
+		hook, err := readWebhook(filename)
+		if err != nil {
+			slog.Error("fail to load token", "file", filename, "err", err)
+			continue
+		}
+		if hook != nil && hook.URL != "" {
+			hooks = append(hooks, *hook)
+		}
+	}
+	w.hooks = hooks
+	w.stamp = stamp.String()
+	return nil
+}
+
+func readWebhook(filename string) (*teamWebhook, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tok struct {
+		Team *struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"team"`
+		Webhook *Webhook `json:"incoming_webhook"`
+	}
+	if err := json.NewDecoder(f).Decode(&tok); err != nil {
+		return nil, err
+	}
+	if tok.Webhook == nil {
+		return nil, nil
+	}
+	// Files are named after the team ID by authsrv.
+	hook := &teamWebhook{
+		Webhook: *tok.Webhook,
+		teamID:  strings.TrimSuffix(filepath.Base(filename), ".json"),
+	}
+	if tok.Team != nil {
+		if tok.Team.ID != "" {
+			hook.teamID = tok.Team.ID
+		}
+		hook.teamName = tok.Team.Name
+	}
+	return hook, nil
+}
@@ -0,0 +1,93 @@
+package authcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, filename, doc string) {
+	if err := os.WriteFile(filename, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "T1.json"), `{"access_token":"xoxb-1","incoming_webhook":{"url":"https://hooks/1","channel":"#general"}}`)
+	write(t, filepath.Join(dir, "T2.json"), `{"access_token":"xoxb-2"}`)
+
+	w, err := NewWebhooks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url, err := w.URL("", "#general"); err != nil || url != "https://hooks/1" {
+		t.Errorf("URL(#general) = %q, %v", url, err)
+	}
+	if url, _ := w.URL("", "#ops"); url != "" {
+		t.Errorf("URL(#ops) = %q, want none", url)
+	}
+
+	// Installed in #ops after the start.
+	write(t, filepath.Join(dir, "T3.json"), `{"incoming_webhook":{"url":"https://hooks/3","channel":"#ops"}}`)
+	if url, err := w.URL("", "#ops"); err != nil || url != "https://hooks/3" {
+		t.Errorf("URL(#ops) = %q, %v after install", url, err)
+	}
+
+	// Reinstalled with another webhook.
+	filename := filepath.Join(dir, "T1.json")
+	write(t, filename, `{"incoming_webhook":{"url":"https://hooks/1b","channel":"#general"}}`)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filename, later, later)
+	if url, _ := w.URL("", "#general"); url != "https://hooks/1b" {
+		t.Errorf("URL(#general) = %q after reinstall", url)
+	}
+
+	// A broken file is skipped, the other webhooks are still read.
+	write(t, filepath.Join(dir, "T4.json"), `{`)
+	write(t, filepath.Join(dir, "T5.json"), `{"incoming_webhook":{"url":"https://hooks/5","channel":"#dev"}}`)
+	if url, err := w.URL("", "#dev"); err != nil || url != "https://hooks/5" {
+		t.Errorf("URL(#dev) = %q, %v with a broken file", url, err)
+	}
+	if url, err := w.URL("", "#ops"); err != nil || url != "https://hooks/3" {
+		t.Errorf("URL(#ops) = %q, %v with a broken file", url, err)
+	}
+
+	os.Remove(filepath.Join(dir, "T3.json"))
+	os.Remove(filepath.Join(dir, "T4.json"))
+	if url, err := w.URL("", "#ops"); err != nil || url != "" {
+		t.Errorf("URL(#ops) = %q, %v after uninstall", url, err)
+	}
+}
+
+func TestWebhooksTeams(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "T1.json"), `{"team":{"id":"T1","name":"Acme"},"incoming_webhook":{"url":"https://hooks/1","channel":"#general"}}`)
+	write(t, filepath.Join(dir, "T2.json"), `{"incoming_webhook":{"url":"https://hooks/2","channel":"#general"}}`)
+	write(t, filepath.Join(dir, "T3.json"), `{"incoming_webhook":{"url":"https://hooks/3","channel":"#ops"}}`)
+	w, err := NewWebhooks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		team, channel, url string
+		err                bool
+	}{
+		{"T1", "#general", "https://hooks/1", false},
+		{"Acme", "#general", "https://hooks/1", false},
+		{"T2", "#general", "https://hooks/2", false},
+		{"", "#general", "", true},
+		{"T9", "#general", "", false},
+		{"T1", "#ops", "", false},
+		{"", "#ops", "https://hooks/3", false},
+		{"T3", "#ops", "https://hooks/3", false},
+	}
+	for _, tt := range tests {
+		url, err := w.URL(tt.team, tt.channel)
+		if url != tt.url || (err != nil) != tt.err {
+			t.Errorf("URL(%q, %q) = %q, %v; want %q, error %v", tt.team, tt.channel, url, err, tt.url, tt.err)
+		}
+	}
+}
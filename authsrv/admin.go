@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// apiResponse is the envelope of every Slack Web API response.
+type apiResponse struct {
+	Ok     bool   `json:"ok"`
+	Error  string `json:"error"`
+	URL    string `json:"url"`
+	Team   string `json:"team"`
+	TeamID string `json:"team_id"`
+	User   string `json:"user"`
+}
+
+// callSlack calls a Slack Web API method with token.
+func callSlack(ctx context.Context, token, method string, params url.Values) (*apiResponse, error) {
+	req, err := http.NewRequest("POST", global.env.API(method), strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := global.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	api := &apiResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(api); err != nil {
+		return nil, fmt.Errorf("authsrv: fail to parse %s response: %v", method, err)
+	}
+	if !api.Ok {
+		return api, fmt.Errorf("authsrv: %s failed: %s", method, api.Error)
+	}
+	return api, nil
+}
+
+// isAdmin reports whether the user may manage the installation
+// of tok: users listed in ADMINS manage every workspace, the user
+// who installed the app manages their own.
+func isAdmin(sess *session, tok *slackToken) bool {
+	if global.admins[sess.UserID] {
+		return true
+	}
+	if tok == nil || tok.Key() != sess.TeamID {
+		return false
+	}
+	return tok.AuthedUser != nil && tok.AuthedUser.ID == sess.UserID
+}
+
+// manageableTokens returns the tokens the user is admin of.
+func manageableTokens(sess *session) []*slackToken {
+	var list []*slackToken
+	for _, tok := range global.slack.tokens.List() {
+		if isAdmin(sess, tok) {
+			list = append(list, tok)
+		}
+	}
+	return list
+}
+
+// csrfToken protects the forms of the admin page. It is bound to the
+// session so it cannot be reused by another user.
+func csrfToken(sess *session) string {
+	return global.cookies.sign("csrf." + sess.UserID + "." + strconv.FormatInt(sess.Expires, 10))
+}
+
+// requireAdmin only lets through users admin of at least one
+// workspace. POST requests must carry the CSRF token.
+func requireAdmin(h func(w http.ResponseWriter, r *http.Request, sess *session)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sess, err := global.cookies.Session(r)
+		if err != nil {
+			http.Redirect(w, r, "/auth/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		if !global.admins[sess.UserID] && len(manageableTokens(sess)) == 0 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method == "POST" && r.PostFormValue("csrf") != csrfToken(sess) {
+			http.Error(w, "invalid form, please reload the page", http.StatusBadRequest)
+			return
+		}
+		h(w, r, sess)
+	}
+}
+
+type adminPage struct {
+	Session *session
+	CSRF    string
+	Tokens  []*slackToken
+	Message string
+	Error   string
+}
+
+func renderAdmin(w http.ResponseWriter, sess *session, msg string, err error) {
+	page := adminPage{
+		Session: sess,
+		CSRF:    csrfToken(sess),
+		Tokens:  manageableTokens(sess),
+		Message: msg,
+	}
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	if err := tmpls.ExecuteTemplate(w, "admin.html", page); err != nil {
+		slog.Error("fail to render page", "err", err)
+	}
+}
+
+// handleAdmin lists the workspaces the app is installed in.
+func handleAdmin(w http.ResponseWriter, r *http.Request, sess *session) {
+	defer logRequest(r)
+	renderAdmin(w, sess, "", nil)
+}
+
+// adminToken returns the token targeted by an admin form.
+func adminToken(w http.ResponseWriter, r *http.Request, sess *session) *slackToken {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	tok := global.slack.tokens.Get(r.PostFormValue("team"))
+	if tok == nil || !isAdmin(sess, tok) {
+		http.Error(w, "unknown workspace", http.StatusNotFound)
+		return nil
+	}
+	return tok
+}
+
+// handleAdminTest checks the tokens of a workspace with auth.test.
+func handleAdminTest(w http.ResponseWriter, r *http.Request, sess *session) {
+	defer logRequest(r)
+	tok := adminToken(w, r, sess)
+	if tok == nil {
+		return
+	}
+	msg := "No bot token."
+	if tok.AccessToken != "" {
+		resp, err := callSlack(r.Context(), tok.AccessToken, "auth.test", nil)
+		if err != nil {
+			renderAdmin(w, sess, "", err)
+			return
+		}
+		msg = fmt.Sprintf("Bot token of %s is valid (user %s).", resp.Team, resp.User)
+	}
+	if user := tok.userAccessToken(); user != "" {
+		resp, err := callSlack(r.Context(), user, "auth.test", nil)
+		if err != nil {
+			renderAdmin(w, sess, msg, err)
+			return
+		}
+		msg += fmt.Sprintf(" User token is valid (user %s).", resp.User)
+	}
+	renderAdmin(w, sess, msg, nil)
+}
+
+// handleAdminRevoke revokes the tokens of a workspace with
+// auth.revoke and forgets them.
+func handleAdminRevoke(w http.ResponseWriter, r *http.Request, sess *session) {
+	defer logRequest(r)
+	tok := adminToken(w, r, sess)
+	if tok == nil {
+		return
+	}
+	for _, token := range []string{tok.userAccessToken(), tok.AccessToken} {
+		if token == "" {
+			continue
+		}
+		if _, err := callSlack(r.Context(), token, "auth.revoke", nil); err != nil {
+			renderAdmin(w, sess, "", err)
+			return
+		}
+	}
+	if err := global.slack.tokens.Delete(tok.Key()); err != nil {
+		renderAdmin(w, sess, "", err)
+		return
+	}
+	renderAdmin(w, sess, "Tokens of "+tok.TeamLabel()+" revoked.", nil)
+}
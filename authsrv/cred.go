@@ -4,61 +4,186 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/aitva/slackbot/authcache"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/slack"
 )
 
 type botToken struct {
 	UserID      string `json:"bot_user_id"`
 	AccessToken string `json:"bot_access_token"`
 }
-type webhookToken struct {
-	URL       string `json:"url"`
-	Chan      string `json:"channel"`
-	ConfigURL string `json:"configuration_url"`
+type teamInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+type userToken struct {
+	ID          string `json:"id"`
+	Scope       string `json:"scope,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
+	TokenType   string `json:"token_type,omitempty"`
 }
 type slackToken struct {
 	*oauth2.Token
-	UserID   string        `json:"user_id"`
-	TeamName string        `json:"team_name"`
-	Bot      *botToken     `json:"bot,omitempty"`
-	Webhook  *webhookToken `json:"incoming_webhook,omitempty"`
+	Scope               string             `json:"scope,omitempty"`
+	AppID               string             `json:"app_id,omitempty"`
+	BotUserID           string             `json:"bot_user_id,omitempty"`
+	Team                *teamInfo          `json:"team,omitempty"`
+	Enterprise          *teamInfo          `json:"enterprise,omitempty"`
+	IsEnterpriseInstall bool               `json:"is_enterprise_install,omitempty"`
+	InstalledAt         time.Time          `json:"installed_at,omitempty"`
+	AuthedUser          *userToken         `json:"authed_user,omitempty"`
+	Webhook             *authcache.Webhook `json:"incoming_webhook,omitempty"`
+
+	// Legacy fields, only filled by the OAuth v1 flow.
+	UserID   string    `json:"user_id,omitempty"`
+	TeamName string    `json:"team_name,omitempty"`
+	Bot      *botToken `json:"bot,omitempty"`
 }
 
-func newSlackToken(tok *oauth2.Token) *slackToken {
-	stok := &slackToken{Token: tok}
+// hasScope reports whether scope is one of scopes.
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
 
-	iface := tok.Extra("user_id")
-	stok.UserID = iface.(string)
+// newSlackToken builds a slackToken from the extra fields of an
+// oauth.access (v1) or oauth.v2.access (v2) response. It returns an
+// error when a field is malformed or when a field needed by one of
+// the scopes requested in conf is missing.
+func newSlackToken(tok *oauth2.Token, conf *slackConfig) (*slackToken, error) {
+	r := newExtraReader(tok.Extra)
+	if ok, isBool := tok.Extra("ok").(bool); isBool && !ok {
+		return nil, fmt.Errorf("authsrv: Slack refused the token: %s", r.String("error", false))
+	}
 
-	iface = tok.Extra("team_name")
-	stok.TeamName = iface.(string)
+	var stok *slackToken
+	if conf.V2 {
+		stok = decodeSlackTokenV2(tok, r, conf)
+	} else {
+		stok = decodeSlackTokenV1(tok, r, conf)
+	}
+	if hasScope(conf.Scopes, "incoming-webhook") {
+		if fields, ok := r.Object("incoming_webhook", true); ok {
+			webhook := &authcache.Webhook{}
+			webhook.URL = fields.String("url", true)
+			webhook.Chan = fields.String("channel", true)
+			webhook.ConfigURL = fields.String("configuration_url", false)
+			stok.Webhook = webhook
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	stok.upgrade()
+	return stok, nil
+}
 
-	iface = tok.Extra("bot")
-	fields, ok := iface.(map[string]interface{})
-	if ok {
+func decodeSlackTokenV1(tok *oauth2.Token, r extraReader, conf *slackConfig) *slackToken {
+	stok := &slackToken{Token: tok}
+	stok.Scope = r.String("scope", false)
+	stok.UserID = r.String("user_id", true)
+	stok.TeamName = r.String("team_name", true)
+	if id := r.String("team_id", false); id != "" {
+		stok.Team = &teamInfo{ID: id, Name: stok.TeamName}
+	}
+	if fields, ok := r.Object("bot", hasScope(conf.Scopes, "bot")); ok {
 		bot := &botToken{}
-		bot.UserID = fields["bot_user_id"].(string)
-		bot.AccessToken = fields["bot_access_token"].(string)
+		bot.UserID = fields.String("bot_user_id", true)
+		bot.AccessToken = fields.String("bot_access_token", true)
 		stok.Bot = bot
 	}
-	iface = tok.Extra("incoming_webhook")
-	fields, ok = iface.(map[string]interface{})
-	if ok {
-		webhook := &webhookToken{}
-		webhook.URL = fields["url"].(string)
-		webhook.Chan = fields["channel"].(string)
-		webhook.ConfigURL = fields["configuration_url"].(string)
-		stok.Webhook = webhook
+	return stok
+}
+
+func decodeSlackTokenV2(tok *oauth2.Token, r extraReader, conf *slackConfig) *slackToken {
+	stok := &slackToken{Token: tok}
+	stok.Scope = r.String("scope", false)
+	stok.AppID = r.String("app_id", false)
+	stok.IsEnterpriseInstall = r.Bool("is_enterprise_install")
+	// Bot scopes make the top level token a bot token.
+	stok.BotUserID = r.String("bot_user_id", len(conf.Scopes) > 0)
+
+	// Org wide installs have no team but an enterprise.
+	if fields, ok := r.Object("team", !stok.IsEnterpriseInstall); ok {
+		stok.Team = &teamInfo{
+			ID:   fields.String("id", true),
+			Name: fields.String("name", false),
+		}
+	}
+	if fields, ok := r.Object("enterprise", stok.IsEnterpriseInstall); ok {
+		stok.Enterprise = &teamInfo{
+			ID:   fields.String("id", true),
+			Name: fields.String("name", false),
+		}
+	}
+	if fields, ok := r.Object("authed_user", true); ok {
+		user := &userToken{}
+		user.ID = fields.String("id", true)
+		userScoped := len(conf.UserScopes) > 0
+		user.Scope = fields.String("scope", userScoped)
+		user.AccessToken = fields.String("access_token", userScoped)
+		user.TokenType = fields.String("token_type", false)
+		stok.AuthedUser = user
 	}
+
+	// Slack reports a token_type of "bot", which oauth2 would send
+	// as is in the Authorization header.
+	tok.TokenType = "Bearer"
 	return stok
 }
 
+// upgrade fills the OAuth v2 fields of a token obtained through
+// the legacy v1 flow, so callers only deal with one shape.
+func (tok *slackToken) upgrade() {
+	if tok.Team == nil && tok.TeamName != "" {
+		tok.Team = &teamInfo{Name: tok.TeamName}
+	}
+	if tok.BotUserID == "" && tok.Bot != nil {
+		tok.BotUserID = tok.Bot.UserID
+	}
+	// The top level token of the v1 flow belongs to the user who
+	// installed the app, the bot token comes apart. Tokens upgraded
+	// before they were moved are fixed the same way.
+	legacy := tok.UserID != "" || tok.TeamName != ""
+	if legacy && tok.Token != nil && tok.AccessToken != "" && (tok.Bot == nil || tok.AccessToken != tok.Bot.AccessToken) {
+		if tok.AuthedUser == nil {
+			tok.AuthedUser = &userToken{ID: tok.UserID}
+		}
+		if tok.AuthedUser.AccessToken == "" {
+			tok.AuthedUser.AccessToken = tok.AccessToken
+			tok.AuthedUser.Scope = tok.Scope
+		}
+		tok.AccessToken = ""
+		if tok.Bot != nil {
+			tok.AccessToken = tok.Bot.AccessToken
+		}
+	}
+	if tok.AuthedUser == nil && tok.UserID != "" {
+		tok.AuthedUser = &userToken{ID: tok.UserID}
+	}
+}
+
+// userAccessToken returns the user token, "" if there is none.
+func (tok *slackToken) userAccessToken() string {
+	if tok.AuthedUser == nil {
+		return ""
+	}
+	return tok.AuthedUser.AccessToken
+}
+
+// slackTokenFromFile loads a token cached by Save. Files written
+// before the migration to OAuth v2 are upgraded on the fly.
 func slackTokenFromFile(filename string) (*slackToken, error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -67,17 +192,58 @@ func slackTokenFromFile(filename string) (*slackToken, error) {
 	t := &slackToken{}
 	err = json.NewDecoder(f).Decode(t)
 	f.Close()
-	return t, err
+	if err != nil {
+		return nil, err
+	}
+	t.upgrade()
+	return t, nil
 }
 
-func (tok *slackToken) Save(filename string) {
-	fmt.Printf("Saving credential file to: %s\n", filename)
-	f, err := os.Create(filename)
+// Save caches the token in filename.
+func (tok *slackToken) Save(filename string) error {
+	slog.Info("saving credential file", "file", filename)
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return err
 	}
-	json.NewEncoder(f).Encode(tok)
-	f.Close()
+	err = json.NewEncoder(f).Encode(tok)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// TeamLabel returns a human readable name of the workspace.
+func (tok *slackToken) TeamLabel() string {
+	switch {
+	case tok.Team != nil && tok.Team.Name != "":
+		return tok.Team.Name
+	case tok.Enterprise != nil && tok.Enterprise.Name != "":
+		return tok.Enterprise.Name
+	}
+	return tok.Key()
+}
+
+// Age returns for how long the token has been installed.
+func (tok *slackToken) Age() time.Duration {
+	if tok.InstalledAt.IsZero() {
+		return 0
+	}
+	return time.Since(tok.InstalledAt).Truncate(time.Minute)
+}
+
+// Key identifies the workspace, or the organization for org wide
+// installs, the token belongs to.
+func (tok *slackToken) Key() string {
+	switch {
+	case tok.Team != nil && tok.Team.ID != "":
+		return tok.Team.ID
+	case tok.Enterprise != nil && tok.Enterprise.ID != "":
+		return tok.Enterprise.ID
+	case tok.Team != nil:
+		return tok.Team.Name
+	}
+	return ""
 }
 
 // tokenCacheFile generates credential file path/filename.
@@ -108,18 +274,68 @@ func tokenFromFile(filename string) (*oauth2.Token, error) {
 // saveToken uses a file path to create a file and store the
 // token in it.
 func saveToken(filename string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", filename)
+	slog.Info("saving credential file", "file", filename)
 	f, err := os.Create(filename)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		fatal("fail to cache oauth token", err)
 	}
 	json.NewEncoder(f).Encode(token)
 	f.Close()
 }
 
+// slackV2Endpoint returns the OAuth endpoint for Slack apps using
+// granular bot and user scopes.
+func slackV2Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  global.env.AuthorizeURL,
+		TokenURL: global.env.TokenURL(),
+	}
+}
+
+// slackEndpoint returns the endpoint of the original OAuth flow.
+func slackEndpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  global.env.AuthorizeV1URL,
+		TokenURL: global.env.TokenV1URL(),
+	}
+}
+
+// slackConfig is an OAuth config for Slack. With OAuth v2, bot
+// scopes and user scopes are requested separately.
+type slackConfig struct {
+	*oauth2.Config
+	UserScopes []string
+	V2         bool
+}
+
+// AuthCodeURL returns the URL of Slack's consent page.
+func (c *slackConfig) AuthCodeURL(state string, opts ...oauth2.AuthCodeOption) string {
+	if c.V2 {
+		// OAuth v2 expects comma separated scopes.
+		opts = append(opts, oauth2.SetAuthURLParam("scope", strings.Join(c.Scopes, ",")))
+		if len(c.UserScopes) > 0 {
+			opts = append(opts, oauth2.SetAuthURLParam("user_scope", strings.Join(c.UserScopes, ",")))
+		}
+	}
+	return c.Config.AuthCodeURL(state, opts...)
+}
+
+// slackV2ConfigFromJSON is like slackConfigFromJSON but targets the
+// OAuth v2 endpoint, asking for bot scopes and user scopes.
+func slackV2ConfigFromJSON(jsonKey []byte, scope, userScope []string) (*slackConfig, error) {
+	conf, err := slackConfigFromJSON(jsonKey, scope...)
+	if err != nil {
+		return nil, err
+	}
+	conf.Endpoint = slackV2Endpoint()
+	conf.UserScopes = userScope
+	conf.V2 = true
+	return conf, nil
+}
+
 // SlackConfigFromJSON load Slack config from a JSON document as followed:
 // {"client_id":"myID","client_secret":"mySecret","redirect_uris":["myURI"]}
-func slackConfigFromJSON(jsonKey []byte, scope ...string) (*oauth2.Config, error) {
+func slackConfigFromJSON(jsonKey []byte, scope ...string) (*slackConfig, error) {
 	type cred struct {
 		ClientID     string   `json:"client_id"`
 		ClientSecret string   `json:"client_secret"`
@@ -134,11 +350,12 @@ func slackConfigFromJSON(jsonKey []byte, scope ...string) (*oauth2.Config, error
 	if len(c.RedirectURIs) < 1 {
 		return nil, errors.New("authsrv: missing redirect URL in the client_credentials.json")
 	}
-	return &oauth2.Config{
+	conf := &oauth2.Config{
 		ClientID:     c.ClientID,
 		ClientSecret: c.ClientSecret,
 		RedirectURL:  c.RedirectURIs[0],
 		Scopes:       scope,
-		Endpoint:     slack.Endpoint,
-	}, nil
+		Endpoint:     slackEndpoint(),
+	}
+	return &slackConfig{Config: conf}, nil
 }
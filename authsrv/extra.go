@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+)
+
+// extraReader reads the loosely typed fields Slack adds to a token
+// response. Instead of panicking on a missing field or an unexpected
+// type, it records the first error met; callers check it once done.
+type extraReader struct {
+	path string
+	get  func(key string) interface{}
+	err  *error
+}
+
+func newExtraReader(get func(key string) interface{}) extraReader {
+	return extraReader{get: get, err: new(error)}
+}
+
+// Err returns the first error met while reading fields.
+func (r extraReader) Err() error {
+	return *r.err
+}
+
+func (r extraReader) name(key string) string {
+	if r.path == "" {
+		return key
+	}
+	return r.path + "." + key
+}
+
+func (r extraReader) fail(format string, a ...interface{}) {
+	if *r.err == nil {
+		*r.err = fmt.Errorf("authsrv: "+format, a...)
+	}
+}
+
+// Has reports whether key is present and not null.
+func (r extraReader) Has(key string) bool {
+	return r.get(key) != nil
+}
+
+// String reads a string field. A missing field is an error only
+// when required is set.
+func (r extraReader) String(key string, required bool) string {
+	v := r.get(key)
+	if v == nil {
+		if required {
+			r.fail("missing field %q in token response", r.name(key))
+		}
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		r.fail("field %q is a %T, want a string", r.name(key), v)
+	}
+	return s
+}
+
+// Bool reads an optional boolean field.
+func (r extraReader) Bool(key string) bool {
+	v := r.get(key)
+	if v == nil {
+		return false
+	}
+	b, ok := v.(bool)
+	if !ok {
+		r.fail("field %q is a %T, want a boolean", r.name(key), v)
+	}
+	return b
+}
+
+// Object returns a reader for a nested object; ok is false when the
+// object is missing or null.
+func (r extraReader) Object(key string, required bool) (sub extraReader, ok bool) {
+	sub = extraReader{path: r.name(key), err: r.err}
+	v := r.get(key)
+	if v == nil {
+		if required {
+			r.fail("missing object %q in token response", r.name(key))
+		}
+		return sub, false
+	}
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		r.fail("field %q is a %T, want an object", r.name(key), v)
+		return sub, false
+	}
+	sub.get = func(key string) interface{} {
+		return fields[key]
+	}
+	return sub, true
+}
@@ -2,114 +2,251 @@ package main
 
 import (
 	"context"
-	"log"
-
-	"net/url"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"html/template"
 	"io/ioutil"
 
 	"net/http"
 
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/slackenv"
 	"golang.org/x/oauth2"
 )
 
 var tmpls = template.Must(template.ParseGlob("*.html"))
 
 var global struct {
+	env   *slackenv.Config
 	slack struct {
-		conf      *oauth2.Config
-		token     *slackToken
-		cacheFile string
+		conf   *slackConfig
+		tokens *tokenStore
+	}
+	oidc struct {
+		conf         *oauth2.Config
+		keys         *keySet
+		sessionTTL   time.Duration
+		trustedHosts []string
 	}
+	cookies *cookieSigner
+	admins  map[string]bool
+	apiKeys []*apiKey
+	// client calls Slack, recording the calls in the metrics.
+	client *http.Client
 }
 
-func makeSlakeHandler(apiURL string, params url.Values) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		tok := global.slack.token
-		if tok == nil {
-			w.Header().Set("Content-Type", "text/plain")
-			w.Write([]byte("access token for Slack are missing"))
-		}
+// slackContext makes the OAuth calls made with ctx use global.client.
+func slackContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, global.client)
+}
 
-		conf := global.slack.conf
-		client := conf.Client(context.Background(), tok.Token)
+// installFailed reports a failed installation to the user.
+func installFailed(w http.ResponseWriter, status int, err error) {
+	slog.Error("fail to install app", "err", err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err = tmpls.ExecuteTemplate(w, "install_failed.html", err)
+	if err != nil {
+		slog.Error("fail to render page", "err", err)
+	}
+}
 
-		if params == nil {
-			params = make(url.Values)
-		}
-		params["token"] = []string{tok.AccessToken}
-		resp, err := client.PostForm(apiURL, params)
-		if err != nil {
-			log.Fatal(err)
-		}
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatal(err)
-		}
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write(data)
-		log.Println(r.Method, r.URL.Path)
+// installPath starts an installation, installCookie keeps its state
+// until the callback.
+const (
+	installPath   = "/auth/slack/install"
+	installCookie = "authsrv_install"
+)
+
+// handleIndex links to the installation and to the session of the
+// user.
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	var data struct {
+		AuthURL string
+		Session *session
+		CSRF    string
+	}
+	data.AuthURL = installPath
+	data.Session, _ = global.cookies.Session(r)
+	if data.Session != nil {
+		data.CSRF = csrfToken(data.Session)
+	}
+	err := tmpls.ExecuteTemplate(w, "index.html", data)
+	if err != nil {
+		slog.Error("fail to render page", "err", err)
+	}
+}
+
+// handleInstall sends the user to the consent page of Slack. The
+// state is kept in a signed cookie, so the callback only accepts the
+// installations the browser started here.
+func handleInstall(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = global.cookies.Set(w, installCookie, state, time.Now().Add(10*time.Minute))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, global.slack.conf.AuthCodeURL(state, oauth2.AccessTypeOffline), http.StatusFound)
+}
+
+// handleInstallCallback saves the tokens of an installation.
+func handleInstallCallback(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	var state string
+	if err := global.cookies.Get(r, installCookie, &state); err != nil {
+		installFailed(w, http.StatusBadRequest, errors.New("authsrv: installation expired, please try again"))
+		return
+	}
+	global.cookies.Clear(w, installCookie)
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		installFailed(w, http.StatusForbidden, fmt.Errorf("authsrv: installation canceled: %s", reason))
+		return
+	}
+	if query.Get("state") != state {
+		installFailed(w, http.StatusBadRequest, errors.New("authsrv: invalid installation state"))
+		return
+	}
+	conf := global.slack.conf
+	tok, err := conf.Exchange(slackContext(r.Context()), query.Get("code"))
+	if err != nil {
+		installFailed(w, http.StatusBadGateway, err)
+		return
+	}
+	stok, err := newSlackToken(tok, conf)
+	if err != nil {
+		installFailed(w, http.StatusBadGateway, err)
+		return
+	}
+	slog.Info("app installed", "team", stok.Key(), "app", stok.AppID, "scope", stok.Scope)
+	stok.InstalledAt = time.Now()
+	err = global.slack.tokens.Put(stok)
+	if err != nil {
+		installFailed(w, http.StatusInternalServerError, err)
+		return
 	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("access token for Slack are saved"))
+}
+
+// logRequest logs a request served.
+func logRequest(r *http.Request) {
+	slog.Info("request", "method", r.Method, "path", r.URL.Path)
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
 
 func main() {
+	err := logging.Setup("authsrv")
+	if err != nil {
+		fatal("fail to set up logs", err)
+	}
+	env, err := slackenv.Load()
+	if err != nil {
+		fatal("fail to load Slack config", err)
+	}
+	global.env = env
+	global.client = metrics.NewClient(0)
+
 	b, err := ioutil.ReadFile("slack_secret.json")
 	if err != nil {
-		log.Fatalf("Unable to read Slack secret file: %v", err)
+		fatal("fail to read Slack secret file", err)
 	}
 
-	conf := global.slack.conf
-	conf, err = slackConfigFromJSON(b, "chat:write:bot", "incoming-webhook", "bot")
+	conf, err := slackV2ConfigFromJSON(b,
+		[]string{"chat:write", "incoming-webhook", "users:read"},
+		[]string{"chat:write"},
+	)
+	if err != nil {
+		fatal("fail to parse client secret", err)
+	}
+	global.slack.conf = conf
+	cacheDir, err := tokenCacheFile("authsrv-slack")
 	if err != nil {
-		log.Fatal("fail to parse client secret:", err)
+		fatal("fail to create cache file", err)
 	}
-	global.slack.cacheFile, err = tokenCacheFile("authsrv-slack.json")
+	global.slack.tokens, err = newTokenStore(cacheDir)
 	if err != nil {
-		log.Fatal("fail to create cache file:", err)
+		fatal("fail to load tokens from cache", err)
+	}
+	// Import the token cached before authsrv supported several
+	// workspaces.
+	legacyFile, err := tokenCacheFile("authsrv-slack.json")
+	if err == nil {
+		err = global.slack.tokens.importLegacy(context.Background(), legacyFile)
 	}
-	global.slack.token, err = slackTokenFromFile(global.slack.cacheFile)
 	if err != nil {
-		log.Println("fail to load token from cache")
-		global.slack.token = nil
+		slog.Error("fail to import legacy token", "err", err)
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Redirect user to consent page to ask for permission
-		// for the scopes specified above.
-		authURL := conf.AuthCodeURL("state", oauth2.AccessTypeOffline)
-		err := tmpls.ExecuteTemplate(w, "index.html", authURL)
-		if err != nil {
-			log.Println(err)
-		}
-		log.Println(r.Method, r.URL.Path)
-	})
-
-	http.HandleFunc("/auth/slack/callback", func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.Background()
-		code := r.URL.Query().Get("code")
-		tok, err := conf.Exchange(ctx, code)
-		if err != nil {
-			log.Fatal(err)
+	global.oidc.conf, err = slackOIDCConfigFromJSON(b)
+	if err != nil {
+		fatal("fail to parse client secret", err)
+	}
+	global.oidc.keys, err = keySetFromFile("slack_jwks.json")
+	if err != nil {
+		global.oidc.keys = newKeySet(global.env.JWKSURL, time.Hour)
+	} else {
+		slog.Info("using local JWKS", "file", "slack_jwks.json")
+	}
+	global.oidc.sessionTTL = 12 * time.Hour
+	if hosts := os.Getenv("TRUSTED_HOSTS"); hosts != "" {
+		global.oidc.trustedHosts = strings.Split(hosts, ",")
+	}
+	global.cookies, err = newCookieSigner(os.Getenv("SESSION_KEY"), os.Getenv("INSECURE_COOKIES") == "")
+	if err != nil {
+		fatal("fail to create session key", err)
+	}
+	global.apiKeys, err = apiKeysFromFile("api_keys.json")
+	if err != nil && !os.IsNotExist(err) {
+		fatal("fail to load API keys", err)
+	}
+	global.admins = make(map[string]bool)
+	for _, id := range strings.Split(os.Getenv("ADMINS"), ",") {
+		if id != "" {
+			global.admins[id] = true
 		}
-		log.Printf("%#v", tok)
-		stok := newSlackToken(tok)
-		log.Printf("%#v", stok)
-		stok.Save(global.slack.cacheFile)
-
-		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte("access token for Slack are saved"))
-		log.Println(r.Method, r.URL.Path)
-	})
-
-	params := url.Values{
-		"channel": {"#general"},
-		"text":    {"Hello! (using OAut2)"},
-	}
-	http.HandleFunc("/slack/hello", makeSlakeHandler("https://slack.com/api/chat.postMessage", params))
-	http.HandleFunc("/slack/auth.test", makeSlakeHandler("https://slack.com/api/auth.test", nil))
-	http.HandleFunc("/slack/bots.info", makeSlakeHandler("https://slack.com/api/bots.info", nil))
-
-	log.Println("listening on :3000")
-	log.Fatal(http.ListenAndServe(":3000", nil))
+	}
+
+	http.HandleFunc("/", handleIndex)
+	http.HandleFunc(installPath, handleInstall)
+	http.HandleFunc("/auth/slack/callback", handleInstallCallback)
+	http.HandleFunc("/auth/login", handleLogin)
+	http.HandleFunc(oidcCallback, handleOIDCCallback)
+	http.HandleFunc("/auth/logout", handleLogout)
+	http.HandleFunc("/auth/verify", handleVerify)
+	http.HandleFunc("/admin", requireAdmin(handleAdmin))
+	http.HandleFunc("/admin/test", requireAdmin(handleAdminTest))
+	http.HandleFunc("/admin/revoke", requireAdmin(handleAdminRevoke))
+
+	http.HandleFunc(proxyPrefix, handleProxy)
+
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9106"
+	}
+	go func() {
+		fatal("fail to serve admin endpoints", metrics.ListenAndServe(adminAddr, nil))
+	}()
+
+	slog.Info("listening", "addr", ":3000")
+	fatal("fail to serve", http.ListenAndServe(":3000", nil))
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/slackenv"
+	"github.com/aitva/slackbot/slacktest"
+	"golang.org/x/oauth2"
+)
+
+// setupFake points authsrv to s and serves its endpoints.
+func setupFake(t *testing.T, s *slacktest.Server) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(installPath, handleInstall)
+	mux.HandleFunc("/auth/slack/callback", handleInstallCallback)
+	mux.HandleFunc("/auth/login", handleLogin)
+	mux.HandleFunc(oidcCallback, handleOIDCCallback)
+	mux.HandleFunc("/auth/logout", handleLogout)
+	mux.HandleFunc("/auth/verify", handleVerify)
+	mux.HandleFunc(proxyPrefix, handleProxy)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	global.env = slackenv.ForBaseURL(s.URL)
+	global.client = http.DefaultClient
+	secret, err := json.Marshal(map[string]interface{}{
+		"client_id":     slacktest.ClientID,
+		"client_secret": slacktest.ClientSecret,
+		"redirect_uris": []string{srv.URL + oidcCallback},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.oidc.conf, err = slackOIDCConfigFromJSON(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.slack.conf, err = slackV2ConfigFromJSON(secret, []string{"chat:write"}, []string{"chat:write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.slack.conf.RedirectURL = srv.URL + "/auth/slack/callback"
+	global.slack.tokens, err = newTokenStore(filepath.Join(t.TempDir(), "tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.oidc.keys = newKeySet(global.env.JWKSURL, time.Hour)
+	global.oidc.sessionTTL = time.Hour
+	global.oidc.trustedHosts = nil
+	global.cookies, err = newCookieSigner("test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func TestEndToEndSignIn(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	srv := setupFake(t, s)
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	browser := &http.Client{Jar: jar}
+
+	// Login goes through the consent page of Slack and back to next.
+	resp, err := browser.Get(srv.URL + "/auth/login?next=" + url.QueryEscape("/auth/verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var sess session
+	if err := json.NewDecoder(resp.Body).Decode(&sess); err != nil {
+		t.Fatal(err)
+	}
+	if sess.UserID != slacktest.InstallerID || sess.TeamID != slacktest.TeamID {
+		t.Errorf("session = %+v", sess)
+	}
+	if sess.Email != "installer@example.com" {
+		t.Errorf("user info not fetched: %+v", sess)
+	}
+	if n := s.Requests("openid.connect.token"); n != 1 {
+		t.Errorf("openid.connect.token called %d times", n)
+	}
+
+	// Signing out needs the CSRF token of the session.
+	resp, err = browser.PostForm(srv.URL+"/auth/logout", url.Values{"csrf": {"forged"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("forged logout: status = %d", resp.StatusCode)
+	}
+	resp, err = browser.PostForm(srv.URL+"/auth/logout", url.Values{"csrf": {csrfToken(&sess)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	resp, err = browser.Get(srv.URL + "/auth/verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("after logout: status = %d", resp.StatusCode)
+	}
+}
+
+func TestEndToEndProxy(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	srv := setupFake(t, s)
+
+	var err error
+	global.slack.tokens, err = newTokenStore(filepath.Join(t.TempDir(), "tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = global.slack.tokens.Put(&slackToken{
+		Token: &oauth2.Token{AccessToken: slacktest.BotToken},
+		Team:  &teamInfo{ID: slacktest.TeamID, Name: slacktest.TeamName},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.apiKeys = []*apiKey{{
+		Name:    "ci",
+		Key:     "secret",
+		Team:    slacktest.TeamID,
+		Methods: []string{"chat.postMessage"},
+		limiter: newLimiter(100, 100),
+	}}
+
+	post := func(method string) *http.Response {
+		t.Helper()
+		body := `{"channel":"` + slacktest.General + `","text":"deployed"}`
+		r, err := http.NewRequest("POST", srv.URL+proxyPrefix+method, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := post("chat.postMessage"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	msg, err := s.WaitMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "deployed" || msg.Channel != slacktest.General || msg.Via != slacktest.ViaAPI {
+		t.Errorf("posted %+v", msg)
+	}
+
+	// The key is not allowed to call other methods.
+	if resp := post("chat.update"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("chat.update: status = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	if n := s.Requests("chat.update"); n != 0 {
+		t.Errorf("chat.update forwarded %d times", n)
+	}
+}
+
+func TestEndToEndProxyLegacyToken(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	srv := setupFake(t, s)
+
+	// A token saved by the OAuth v1 flow, before authsrv handled
+	// several workspaces.
+	dir := t.TempDir()
+	var err error
+	global.slack.tokens, err = newTokenStore(filepath.Join(dir, "tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := filepath.Join(dir, "authsrv-slack.json")
+	doc := `{"access_token":"` + slacktest.UserToken + `","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z",` +
+		`"user_id":"` + slacktest.InstallerID + `","team_name":"` + slacktest.TeamName + `",` +
+		`"bot":{"bot_user_id":"` + slacktest.BotUserID + `","bot_access_token":"` + slacktest.BotToken + `"}}`
+	if err := os.WriteFile(legacy, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := global.slack.tokens.importLegacy(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	global.apiKeys = []*apiKey{
+		{Name: "bot", Key: "bot-key", Team: slacktest.TeamID, Methods: []string{"chat.postMessage"}, limiter: newLimiter(100, 100)},
+		{Name: "user", Key: "user-key", Team: slacktest.TeamID, Methods: []string{"chat.postMessage"}, UserToken: true, limiter: newLimiter(100, 100)},
+	}
+
+	// Each key posts with its own token.
+	for _, tt := range []struct{ key, user string }{{"bot-key", slacktest.BotUserID}, {"user-key", slacktest.InstallerID}} {
+		body := `{"channel":"` + slacktest.General + `","text":"deployed"}`
+		r, err := http.NewRequest("POST", srv.URL+proxyPrefix+"chat.postMessage", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+tt.key)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status = %d", tt.key, resp.StatusCode)
+			continue
+		}
+		msg, err := s.WaitMessage(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.User != tt.user {
+			t.Errorf("%s posted as %s, want %s", tt.key, msg.User, tt.user)
+		}
+	}
+}
+
+func TestEndToEndInstall(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	srv := setupFake(t, s)
+
+	// noRedirect stops at the first redirection.
+	noRedirect := func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+	newBrowser := func(follow bool) *http.Client {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &http.Client{Jar: jar}
+		if !follow {
+			c.CheckRedirect = noRedirect
+		}
+		return c
+	}
+	get := func(c *http.Client, url string) *http.Response {
+		t.Helper()
+		resp, err := c.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	// An attacker starts installing in their workspace, and keeps the
+	// callback URL instead of following it.
+	attacker := newBrowser(false)
+	resp := get(attacker, srv.URL+installPath)
+	resp = get(attacker, resp.Header.Get("Location"))
+	forged := resp.Header.Get("Location")
+	if !strings.HasPrefix(forged, srv.URL+"/auth/slack/callback?") {
+		t.Fatalf("Slack redirected to %q", forged)
+	}
+
+	// An admin lured to it installs nothing, whether they started an
+	// installation or not.
+	admin := newBrowser(false)
+	if resp := get(admin, forged); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("forged callback: status = %d", resp.StatusCode)
+	}
+	get(admin, srv.URL+installPath)
+	if resp := get(admin, forged); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("forged callback during an installation: status = %d", resp.StatusCode)
+	}
+	if n := len(global.slack.tokens.List()); n != 0 {
+		t.Fatalf("%d workspaces installed by forged callbacks", n)
+	}
+
+	// The admin's own installation goes through.
+	if resp := get(newBrowser(true), srv.URL+installPath); resp.StatusCode != http.StatusOK {
+		t.Fatalf("install: status = %d", resp.StatusCode)
+	}
+	tok := global.slack.tokens.Get(slacktest.TeamID)
+	if tok == nil || tok.AccessToken != slacktest.BotToken || tok.userAccessToken() != slacktest.UserToken {
+		t.Errorf("installed %+v", tok)
+	}
+}
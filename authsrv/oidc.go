@@ -0,0 +1,432 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcCookie   = "authsrv_oidc"
+	oidcCallback = "/auth/slack/oidc/callback"
+)
+
+// slackOIDCEndpoint returns the endpoint for "Sign in with Slack".
+func slackOIDCEndpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  global.env.OIDCAuthorizeURL,
+		TokenURL: global.env.OIDCTokenURL(),
+	}
+}
+
+// slackOIDCConfigFromJSON loads the "Sign in with Slack" config from
+// the same document as slackConfigFromJSON. One of the redirect URIs
+// must point to the OpenID Connect callback.
+func slackOIDCConfigFromJSON(jsonKey []byte) (*oauth2.Config, error) {
+	var c struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret"`
+		RedirectURIs []string `json:"redirect_uris"`
+	}
+	if err := json.Unmarshal(jsonKey, &c); err != nil {
+		return nil, err
+	}
+	for _, uri := range c.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Path != oidcCallback {
+			continue
+		}
+		return &oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  uri,
+			Scopes:       []string{"openid", "profile", "email"},
+			Endpoint:     slackOIDCEndpoint(),
+		}, nil
+	}
+	return nil, fmt.Errorf("authsrv: missing redirect URL to %s in the client_credentials.json", oidcCallback)
+}
+
+// idClaims are the claims of an ID token issued by Slack.
+type idClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Audience string `json:"aud"`
+	Expires  int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"nonce"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Picture  string `json:"picture"`
+	UserID   string `json:"https://slack.com/user_id"`
+	TeamID   string `json:"https://slack.com/team_id"`
+}
+
+// keySet holds the keys Slack signs ID tokens with. Keys fetched
+// from url are cached for ttl; a key set without url never changes,
+// which makes it usable as a local stand-in for Slack's JWKS.
+type keySet struct {
+	url string
+	ttl time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newKeySet(url string, ttl time.Duration) *keySet {
+	return &keySet{url: url, ttl: ttl}
+}
+
+// keySetFromFile loads a static JWKS document from filename.
+func keySetFromFile(filename string) (*keySet, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return nil, err
+	}
+	return &keySet{keys: keys}, nil
+}
+
+func parseJWKS(b []byte) (map[string]*rsa.PublicKey, error) {
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("authsrv: invalid modulus for key %q: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("authsrv: invalid exponent for key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// Key returns the key identified by kid, refreshing the cache when
+// it is stale or does not know kid.
+func (ks *keySet) Key(kid string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	key, ok := ks.keys[kid]
+	fresh := time.Since(ks.fetched) < ks.ttl
+	if ks.url == "" || (ok && fresh) {
+		if !ok {
+			return nil, fmt.Errorf("authsrv: unknown signing key %q", kid)
+		}
+		return key, nil
+	}
+	// Do not hammer Slack with unknown key IDs.
+	if !ok && time.Since(ks.fetched) < time.Minute {
+		return nil, fmt.Errorf("authsrv: unknown signing key %q", kid)
+	}
+
+	keys, err := ks.fetch()
+	if err != nil {
+		// Slack being unreachable doesn't invalidate the keys known.
+		if ok {
+			slog.Warn("fail to refresh signing keys, using cached ones", "err", err)
+			// Try again in a minute.
+			ks.fetched = time.Now().Add(time.Minute - ks.ttl)
+			return key, nil
+		}
+		return nil, err
+	}
+	ks.keys = keys
+	ks.fetched = time.Now()
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("authsrv: unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetch downloads the keys from ks.url.
+func (ks *keySet) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := global.client.Get(ks.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("authsrv: unexpected status code fetching JWKS: %d", resp.StatusCode)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(b)
+}
+
+// verifyIDToken checks the signature and claims of an ID token
+// issued by Slack to clientID for the login started with nonce.
+func verifyIDToken(keys *keySet, raw, clientID, nonce string) (*idClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("authsrv: malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("authsrv: unexpected ID token algorithm %q", header.Alg)
+	}
+	key, err := keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("authsrv: malformed ID token signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return nil, errors.New("authsrv: invalid ID token signature")
+	}
+
+	claims := &idClaims{}
+	if err := decodeSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	switch {
+	case claims.Issuer != global.env.Issuer:
+		return nil, fmt.Errorf("authsrv: unexpected ID token issuer %q", claims.Issuer)
+	case claims.Audience != clientID:
+		return nil, fmt.Errorf("authsrv: ID token issued to %q", claims.Audience)
+	case claims.Expires+60 < now:
+		return nil, errors.New("authsrv: ID token expired")
+	case claims.Nonce != nonce:
+		return nil, errors.New("authsrv: ID token nonce mismatch")
+	case claims.UserID == "" || claims.TeamID == "":
+		return nil, errors.New("authsrv: ID token without user or team")
+	}
+	return claims, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return errors.New("authsrv: malformed ID token")
+	}
+	return json.Unmarshal(b, v)
+}
+
+// fetchUserInfo asks Slack for the profile of the signed in user.
+func fetchUserInfo(ctx context.Context, conf *oauth2.Config, tok *oauth2.Token) (*idClaims, error) {
+	resp, err := conf.Client(ctx, tok).Get(global.env.API("openid.connect.userInfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var info struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+		idClaims
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if !info.Ok {
+		return nil, fmt.Errorf("authsrv: openid.connect.userInfo failed: %s", info.Error)
+	}
+	return &info.idClaims, nil
+}
+
+// oidcState is kept in a cookie between login and callback.
+type oidcState struct {
+	State string `json:"state"`
+	Nonce string `json:"nonce"`
+	Next  string `json:"next"`
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// safeNext returns where to send the user after login. Only local
+// paths and URLs on trusted hosts are allowed. Browsers read a
+// backslash as a slash, and ignore tabs and newlines, so "/\evil.com"
+// would leave the site.
+func safeNext(next string) string {
+	if next == "" || strings.IndexFunc(next, func(r rune) bool { return r == '\\' || r < 0x20 || r == 0x7f }) >= 0 {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil {
+		return "/"
+	}
+	if u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/") && !strings.HasPrefix(next, "//") {
+		return next
+	}
+	for _, host := range global.oidc.trustedHosts {
+		if u.Host == host && (u.Scheme == "https" || u.Scheme == "http") {
+			return next
+		}
+	}
+	return "/"
+}
+
+// handleLogin starts a "Sign in with Slack" flow.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st := oidcState{State: state, Nonce: nonce, Next: safeNext(r.URL.Query().Get("next"))}
+	err = global.cookies.Set(w, oidcCookie, st, time.Now().Add(10*time.Minute))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	authURL := global.oidc.conf.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleOIDCCallback finishes a "Sign in with Slack" flow and opens
+// a session for the user.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	var st oidcState
+	if err := global.cookies.Get(r, oidcCookie, &st); err != nil {
+		http.Error(w, "login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	global.cookies.Clear(w, oidcCookie)
+
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "login canceled: "+reason, http.StatusForbidden)
+		return
+	}
+	if query.Get("state") != st.State {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	ctx := slackContext(r.Context())
+	conf := global.oidc.conf
+	tok, err := conf.Exchange(ctx, query.Get("code"))
+	if err != nil {
+		slog.Error("fail to exchange OpenID code", "err", err)
+		http.Error(w, "fail to sign in with Slack", http.StatusBadGateway)
+		return
+	}
+	raw, _ := tok.Extra("id_token").(string)
+	claims, err := verifyIDToken(global.oidc.keys, raw, conf.ClientID, st.Nonce)
+	if err != nil {
+		slog.Error("fail to verify ID token", "err", err)
+		http.Error(w, "fail to sign in with Slack", http.StatusUnauthorized)
+		return
+	}
+	info, err := fetchUserInfo(ctx, conf, tok)
+	if err != nil {
+		slog.Error("fail to fetch user info", "err", err)
+	} else if info.Subject == claims.Subject {
+		claims.Name, claims.Email, claims.Picture = info.Name, info.Email, info.Picture
+	}
+
+	sess := session{
+		UserID:  claims.UserID,
+		TeamID:  claims.TeamID,
+		Name:    claims.Name,
+		Email:   claims.Email,
+		Picture: claims.Picture,
+		Expires: time.Now().Add(global.oidc.sessionTTL).Unix(),
+	}
+	err = global.cookies.Set(w, sessionCookie, sess, time.Unix(sess.Expires, 0))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, st.Next, http.StatusFound)
+}
+
+// handleLogout closes the session of the user. It only answers POST
+// with the CSRF token, so another site can't sign the user out.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sess, err := global.cookies.Session(r); err == nil && r.PostFormValue("csrf") != csrfToken(sess) {
+		http.Error(w, "invalid form, please reload the page", http.StatusBadRequest)
+		return
+	}
+	global.cookies.Clear(w, sessionCookie)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// handleVerify lets other apps use authsrv as an identity proxy,
+// for instance through nginx's auth_request. It answers 200 with
+// the identity of the user in headers and body, 401 otherwise.
+func handleVerify(w http.ResponseWriter, r *http.Request) {
+	sess, err := global.cookies.Session(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	h := w.Header()
+	h.Set("X-Slack-User-Id", sess.UserID)
+	h.Set("X-Slack-Team-Id", sess.TeamID)
+	h.Set("X-Slack-Email", sess.Email)
+	h.Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sess)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/slackenv"
+)
+
+const testIssuer = "https://slack.com"
+
+// testKey signs the ID tokens of the tests.
+var testKey = func() *rsa.PrivateKey {
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return k
+}()
+
+// jwks returns a JWKS document holding the public part of key as kid.
+func jwks(t *testing.T, kid string, key *rsa.PrivateKey) []byte {
+	doc := map[string]interface{}{
+		"keys": []jwk{{
+			Kid: kid,
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// idToken returns an ID token with claims, signed by key as kid.
+func idToken(t *testing.T, kid string, key *rsa.PrivateKey, claims *idClaims) string {
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": "RS256", "kid": kid}) + "." + enc(claims)
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() *idClaims {
+	return &idClaims{
+		Issuer:   testIssuer,
+		Audience: "client-id",
+		Expires:  time.Now().Add(time.Hour).Unix(),
+		Nonce:    "nonce",
+		UserID:   "U0AAAAAAA",
+		TeamID:   "T0AAAAAAA",
+	}
+}
+
+func TestKeySetFromFile(t *testing.T) {
+	global.env = &slackenv.Config{Issuer: testIssuer}
+	filename := filepath.Join(t.TempDir(), "slack_jwks.json")
+	if err := os.WriteFile(filename, jwks(t, "kid1", testKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keySetFromFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := validClaims()
+	expired.Expires = time.Now().Add(-time.Hour).Unix()
+	foreign := validClaims()
+	foreign.Issuer = "https://evil.com"
+
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{"valid", idToken(t, "kid1", testKey, validClaims()), ""},
+		{"unknown key", idToken(t, "kid2", testKey, validClaims()), `unknown signing key "kid2"`},
+		{"wrong signature", idToken(t, "kid1", other, validClaims()), "invalid ID token signature"},
+		{"expired", idToken(t, "kid1", testKey, expired), "ID token expired"},
+		{"other issuer", idToken(t, "kid1", testKey, foreign), "unexpected ID token issuer"},
+		{"malformed", "a.b", "malformed ID token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyIDToken(keys, tt.raw, "client-id", "nonce")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID != "U0AAAAAAA" || claims.TeamID != "T0AAAAAAA" {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+	if _, err := verifyIDToken(keys, idToken(t, "kid1", testKey, validClaims()), "client-id", "other"); err == nil {
+		t.Error("nonce mismatch accepted")
+	}
+}
+
+func TestKeySetFromFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := keySetFromFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file accepted")
+	}
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"keys":[{"kid":"k","kty":"RSA","n":"!!","e":"AQAB"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keySetFromFile(bad); err == nil || !strings.Contains(err.Error(), "invalid modulus") {
+		t.Errorf("err = %v, want invalid modulus", err)
+	}
+}
+
+func TestKeySetKeepsKeysOnRefreshFailure(t *testing.T) {
+	up := true
+	doc := jwks(t, "kid1", testKey)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(doc)
+	}))
+	defer srv.Close()
+	global.client = srv.Client()
+
+	ks := newKeySet(srv.URL, time.Hour)
+	if _, err := ks.Key("kid1"); err != nil {
+		t.Fatal(err)
+	}
+	up = false
+	ks.fetched = time.Now().Add(-2 * time.Hour)
+	if _, err := ks.Key("kid1"); err != nil {
+		t.Fatalf("cached key dropped on refresh failure: %v", err)
+	}
+	if _, err := ks.Key("kid2"); err == nil {
+		t.Error("unknown key accepted")
+	}
+}
+
+func TestSafeNext(t *testing.T) {
+	global.oidc.trustedHosts = []string{"bots.example.com"}
+	tests := []struct {
+		next, want string
+	}{
+		{"", "/"},
+		{"/admin", "/admin"},
+		{"/admin?team=T0AAAAAAA", "/admin?team=T0AAAAAAA"},
+		{"https://bots.example.com/admin", "https://bots.example.com/admin"},
+		{"https://evil.com/admin", "/"},
+		{"//evil.com", "/"},
+		{"/\\evil.com", "/"},
+		{"\\\\evil.com", "/"},
+		{"/\t/evil.com", "/"},
+		{"/\n/evil.com", "/"},
+		{"javascript:alert(1)", "/"},
+		{"admin", "/"},
+	}
+	for _, tt := range tests {
+		if got := safeNext(tt.next); got != tt.want {
+			t.Errorf("safeNext(%q) = %q, want %q", tt.next, got, tt.want)
+		}
+	}
+}
+
+func TestLogoutOnlyPost(t *testing.T) {
+	var err error
+	global.cookies, err = newCookieSigner("test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handleLogout(w, httptest.NewRequest("GET", "/auth/logout", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+	w = httptest.NewRecorder()
+	handleLogout(w, httptest.NewRequest("POST", "/auth/logout", nil))
+	if w.Code != http.StatusFound {
+		t.Errorf("POST: status = %d, want %d", w.Code, http.StatusFound)
+	}
+}
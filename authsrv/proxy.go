@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/metrics"
+)
+
+const proxyPrefix = "/slack/api/"
+
+// Limits of the bodies forwarded to Slack. Uploads go up to the
+// largest file Slack accepts.
+const (
+	maxJSONBody   = 1 << 20
+	maxUploadBody = 1 << 30
+)
+
+var methodRe = regexp.MustCompile(`^[a-zA-Z]+(\.[a-zA-Z]+)+$`)
+
+// apiKey lets an internal service call Slack through authsrv with
+// the token of one workspace, restricted to a list of methods.
+type apiKey struct {
+	Name    string   `json:"name"`
+	Key     string   `json:"key"`
+	Team    string   `json:"team"`
+	Methods []string `json:"methods"`
+	// UserToken selects the user token instead of the bot token.
+	UserToken bool `json:"user_token"`
+	// Rate is the number of calls allowed per second, Burst how many
+	// may be made at once.
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+
+	limiter *limiter
+}
+
+// Allows reports whether the key may call method. A method ending
+// with ".*" allows a whole family, like "chat.*".
+func (k *apiKey) Allows(method string) bool {
+	for _, m := range k.Methods {
+		if m == method || m == "*" {
+			return true
+		}
+		if strings.HasSuffix(m, ".*") && strings.HasPrefix(method, m[:len(m)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeysFromFile loads API keys from a JSON array in filename.
+func apiKeysFromFile(filename string) ([]*apiKey, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []*apiKey
+	if err := json.NewDecoder(f).Decode(&keys); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		if k.Rate <= 0 {
+			k.Rate = 1
+		}
+		if k.Burst <= 0 {
+			k.Burst = 1
+		}
+		k.limiter = newLimiter(k.Rate, k.Burst)
+	}
+	return keys, nil
+}
+
+// findAPIKey returns the key sent by the caller, either as a bearer
+// token or in the X-API-Key header.
+func findAPIKey(r *http.Request) *apiKey {
+	sent := r.Header.Get("X-API-Key")
+	if auth := r.Header.Get("Authorization"); sent == "" && strings.HasPrefix(auth, "Bearer ") {
+		sent = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if sent == "" {
+		return nil
+	}
+	for _, k := range global.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(sent)) == 1 {
+			return k
+		}
+	}
+	return nil
+}
+
+// limiter is a token bucket allowing rate calls per second with
+// bursts of burst calls.
+type limiter struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// Allow takes a token from the bucket. When it is empty, it returns
+// false and how long to wait for the next token.
+func (l *limiter) Allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	wait := (1 - l.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// handleProxy forwards /slack/api/{method} to the Slack Web API with
+// the token of the workspace bound to the caller's API key. Form
+// parameters, multipart uploads and JSON bodies are forwarded as is,
+// without the caller's token; Slack's response
+// is returned with its original status.
+func handleProxy(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+	method := strings.TrimPrefix(r.URL.Path, proxyPrefix)
+	if !methodRe.MatchString(method) {
+		http.Error(w, "invalid method", http.StatusNotFound)
+		return
+	}
+	key := findAPIKey(r)
+	if key == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !key.Allows(method) {
+		slog.Warn("API key not allowed", "key", key.Name, "team", key.Team, "method", method)
+		http.Error(w, "method not allowed for this API key", http.StatusForbidden)
+		return
+	}
+	if ok, wait := key.limiter.Allow(); !ok {
+		metrics.RateLimits.Inc("api_key")
+		secs := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+		return
+	}
+	tok := global.slack.tokens.Get(key.Team)
+	if tok == nil {
+		http.Error(w, "app not installed in workspace "+key.Team, http.StatusServiceUnavailable)
+		return
+	}
+	token := tok.AccessToken
+	if key.UserToken {
+		token = tok.userAccessToken()
+		if token == "" {
+			http.Error(w, "no user token for workspace "+key.Team, http.StatusServiceUnavailable)
+			return
+		}
+	} else if token == "" {
+		http.Error(w, "no bot token for workspace "+key.Team, http.StatusServiceUnavailable)
+		return
+	}
+
+	var body io.Reader
+	// upload streams a multipart form to Slack, copied reports the end
+	// of the copy.
+	var upload *io.PipeReader
+	var copied chan error
+	contentType := "application/x-www-form-urlencoded"
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBody))
+		if err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+		body = bytes.NewReader(b)
+		contentType = "application/json; charset=utf-8"
+	case "multipart/form-data":
+		// Used by files.upload, the parts are streamed to Slack.
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadBody)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pr, pw := io.Pipe()
+		mw := multipart.NewWriter(pw)
+		copied = make(chan error, 1)
+		go func() {
+			err := copyParts(mw, mr)
+			pw.CloseWithError(err)
+			copied <- err
+		}()
+		upload, body = pr, pr
+		contentType = mw.FormDataContentType()
+	default:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), bodyErrorStatus(err))
+			return
+		}
+		// The token comes from authsrv, never from the caller.
+		r.Form.Del("token")
+		body = strings.NewReader(r.Form.Encode())
+	}
+
+	// Slack may answer before reading the whole upload, the copy must
+	// not outlive the request.
+	uploaded := func() error {
+		if upload == nil {
+			return nil
+		}
+		upload.Close()
+		if err := <-copied; err != io.ErrClosedPipe {
+			return err
+		}
+		return nil
+	}
+	req, err := http.NewRequest("POST", global.env.API(method), body)
+	if err != nil {
+		uploaded()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	req = req.WithContext(r.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := global.client.Do(req)
+	if uerr := uploaded(); err != nil && uerr != nil {
+		http.Error(w, uerr.Error(), bodyErrorStatus(uerr))
+		return
+	}
+	if err != nil {
+		slog.Error("fail to call Slack", "key", key.Name, "team", key.Team, "method", method, "err", err)
+		http.Error(w, "fail to reach Slack", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range []string{"Content-Type", "Retry-After"} {
+		if v := resp.Header.Get(h); v != "" {
+			w.Header().Set(h, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// bodyErrorStatus returns the status answering a request whose body
+// could not be read: 413 when it is too large, 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// copyParts copies the parts of a multipart form from mr to mw, but
+// the token, and closes mw.
+func copyParts(mw *multipart.Writer, mr *multipart.Reader) error {
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return mw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		// The token comes from authsrv, never from the caller.
+		if p.FormName() == "token" {
+			continue
+		}
+		dst, err := mw.CreatePart(p.Header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, p); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aitva/slackbot/slackenv"
+	"golang.org/x/oauth2"
+)
+
+// upload is a files.upload call received by the fake Slack.
+type upload struct {
+	auth   string
+	fields map[string]string
+}
+
+// setupProxy points authsrv to a fake Slack recording the uploads,
+// with a token for T0AAAAAAA and an API key allowed to upload.
+func setupProxy(t *testing.T) chan upload {
+	uploads := make(chan upload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := upload{auth: r.Header.Get("Authorization"), fields: make(map[string]string)}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("Slack got an invalid upload: %v", err)
+		} else {
+			for k, v := range r.MultipartForm.Value {
+				u.fields[k] = v[0]
+			}
+			for k, fh := range r.MultipartForm.File {
+				f, err := fh[0].Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := ioutil.ReadAll(f)
+				f.Close()
+				u.fields[k] = fh[0].Filename + ":" + string(b)
+			}
+		}
+		uploads <- u
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	t.Cleanup(srv.Close)
+	global.env = slackenv.ForBaseURL(srv.URL)
+	global.client = srv.Client()
+
+	var err error
+	global.slack.tokens, err = newTokenStore(filepath.Join(t.TempDir(), "tokens"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = global.slack.tokens.Put(&slackToken{
+		Token: &oauth2.Token{AccessToken: "xoxb-team"},
+		Team:  &teamInfo{ID: "T0AAAAAAA", Name: "Acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.apiKeys = []*apiKey{{
+		Name:    "ci",
+		Key:     "secret",
+		Team:    "T0AAAAAAA",
+		Methods: []string{"files.*", "chat.postMessage"},
+		limiter: newLimiter(100, 100),
+	}}
+	return uploads
+}
+
+func TestProxyMultipart(t *testing.T) {
+	uploads := setupProxy(t)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	mw.WriteField("channels", "C0AAAAAAA")
+	mw.WriteField("token", "xoxb-caller")
+	fw, err := mw.CreateFormFile("file", "report.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, "all green")
+	mw.Close()
+
+	r := httptest.NewRequest("POST", proxyPrefix+"files.upload", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	handleProxy(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	u := <-uploads
+	if u.auth != "Bearer xoxb-team" {
+		t.Errorf("Authorization = %q", u.auth)
+	}
+	if _, ok := u.fields["token"]; ok {
+		t.Error("caller's token forwarded")
+	}
+	if u.fields["channels"] != "C0AAAAAAA" {
+		t.Errorf("channels = %q", u.fields["channels"])
+	}
+	if u.fields["file"] != "report.txt:all green" {
+		t.Errorf("file = %q", u.fields["file"])
+	}
+}
+
+func TestProxyBodyErrors(t *testing.T) {
+	setupProxy(t)
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"JSON too large", "application/json", `{"text":"` + strings.Repeat("a", maxJSONBody) + `"}`, http.StatusRequestEntityTooLarge},
+		{"malformed multipart", "multipart/form-data", "--x\r\n", http.StatusBadRequest},
+		{"malformed form", "application/x-www-form-urlencoded", "text=%zz", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", proxyPrefix+"chat.postMessage", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("X-API-Key", "secret")
+			w := httptest.NewRecorder()
+			handleProxy(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "authsrv_session"
+
+var errBadCookie = errors.New("authsrv: invalid or expired cookie")
+
+// session identifies a user signed in with Slack.
+type session struct {
+	UserID  string `json:"user_id"`
+	TeamID  string `json:"team_id"`
+	Name    string `json:"name,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Picture string `json:"picture,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+// cookieSigner signs and verifies cookie values with HMAC-SHA256,
+// so their content can be trusted without server side storage.
+type cookieSigner struct {
+	key    []byte
+	secure bool
+}
+
+// newCookieSigner creates a signer from key. An empty key is
+// replaced by a random one, which invalidates cookies on restart.
+func newCookieSigner(key string, secure bool) (*cookieSigner, error) {
+	if key != "" {
+		return &cookieSigner{key: []byte(key), secure: secure}, nil
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &cookieSigner{key: b, secure: secure}, nil
+}
+
+func (s *cookieSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Set stores v, encoded in JSON, in the cookie name until expires.
+func (s *cookieSigner) Set(w http.ResponseWriter, name string, v interface{}, expires time.Time) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    payload + "." + s.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Get decodes the cookie name into v, checking its signature.
+func (s *cookieSigner) Get(r *http.Request, name string, v interface{}) error {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	i := strings.LastIndex(c.Value, ".")
+	if i < 0 {
+		return errBadCookie
+	}
+	payload, sig := c.Value[:i], c.Value[i+1:]
+	if !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return errBadCookie
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return errBadCookie
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Clear removes the cookie name from the browser.
+func (s *cookieSigner) Clear(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secure,
+	})
+}
+
+// Session returns the session of the signed in user.
+func (s *cookieSigner) Session(r *http.Request) (*session, error) {
+	sess := &session{}
+	if err := s.Get(r, sessionCookie, sess); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > sess.Expires {
+		return nil, errBadCookie
+	}
+	return sess, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tokenStore keeps the tokens of every workspace the app is
+// installed in, with one cache file per workspace.
+type tokenStore struct {
+	dir string
+
+	mu     sync.RWMutex
+	tokens map[string]*slackToken
+}
+
+// newTokenStore loads the tokens cached in dir.
+func newTokenStore(dir string) (*tokenStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := &tokenStore{dir: dir, tokens: make(map[string]*slackToken)}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, filename := range files {
+		tok, err := slackTokenFromFile(filename)
+		if err != nil {
+			slog.Error("fail to load token", "file", filename, "err", err)
+			continue
+		}
+		if tok.InstalledAt.IsZero() {
+			if fi, err := os.Stat(filename); err == nil {
+				tok.InstalledAt = fi.ModTime()
+			}
+		}
+		s.tokens[tok.Key()] = tok
+	}
+	return s, nil
+}
+
+func (s *tokenStore) filename(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == '.' {
+			return '_'
+		}
+		return r
+	}, key)
+	return filepath.Join(s.dir, key+".json")
+}
+
+// Get returns the token of a workspace, nil if the app is not
+// installed in it.
+func (s *tokenStore) Get(key string) *slackToken {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[key]
+}
+
+// List returns every token, sorted by workspace name.
+func (s *tokenStore) List() []*slackToken {
+	s.mu.RLock()
+	list := make([]*slackToken, 0, len(s.tokens))
+	for _, tok := range s.tokens {
+		list = append(list, tok)
+	}
+	s.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].TeamLabel() < list[j].TeamLabel()
+	})
+	return list
+}
+
+// Put saves tok, replacing the previous token of the workspace.
+func (s *tokenStore) Put(tok *slackToken) error {
+	key := tok.Key()
+	if key == "" {
+		return errors.New("authsrv: token without team nor enterprise")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := tok.Save(s.filename(key)); err != nil {
+		return err
+	}
+	s.tokens[key] = tok
+	return nil
+}
+
+// Delete forgets the token of a workspace.
+func (s *tokenStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, key)
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// importLegacy moves the token cached in filename, before authsrv
+// supported several workspaces, into s. Tokens saved without team ID
+// get it from auth.test, so they aren't filed under the team name.
+// Once imported, the file is renamed with an ".imported" suffix.
+func (s *tokenStore) importLegacy(ctx context.Context, filename string) error {
+	tok, err := slackTokenFromFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if tok.Team == nil || tok.Team.ID == "" {
+		token := tok.AccessToken
+		if token == "" {
+			token = tok.userAccessToken()
+		}
+		resp, err := callSlack(ctx, token, "auth.test", nil)
+		if err != nil {
+			return err
+		}
+		if resp.TeamID == "" {
+			return errors.New("authsrv: auth.test returned no team ID")
+		}
+		name := resp.Team
+		if tok.Team != nil && tok.Team.Name != "" {
+			name = tok.Team.Name
+		}
+		tok.Team = &teamInfo{ID: resp.TeamID, Name: name}
+	}
+	if s.Get(tok.Key()) == nil {
+		if err := s.Put(tok); err != nil {
+			return err
+		}
+		slog.Info("imported legacy token", "team", tok.Key(), "file", filename)
+	}
+	return os.Rename(filename, filename+".imported")
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aitva/slackbot/slackenv"
+)
+
+func TestImportLegacy(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/api/auth.test" || r.Header.Get("Authorization") != "Bearer xoxp-legacy" {
+			t.Errorf("unexpected call %s with %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": true, "team": "Acme", "team_id": "T0AAAAAAA", "user": "bob",
+		})
+	}))
+	defer srv.Close()
+	global.env = slackenv.ForBaseURL(srv.URL)
+	global.client = srv.Client()
+
+	dir := t.TempDir()
+	store, err := newTokenStore(filepath.Join(dir, "authsrv-slack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := filepath.Join(dir, "authsrv-slack.json")
+	// Cached by the OAuth v1 flow, without team_id.
+	doc := `{"access_token":"xoxp-legacy","token_type":"Bearer","user_id":"U0AAAAAAA","team_name":"Acme"}`
+	if err := os.WriteFile(legacy, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.importLegacy(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("auth.test called %d times, want 1", calls)
+	}
+	tok := store.Get("T0AAAAAAA")
+	if tok == nil {
+		t.Fatal("token not filed under the team ID")
+	}
+	if tok.TeamLabel() != "Acme" || tok.AccessToken != "" || tok.userAccessToken() != "xoxp-legacy" {
+		t.Errorf("token = %+v", tok)
+	}
+	if store.Get("Acme") != nil {
+		t.Error("token filed under the team name")
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy file left in place: %v", err)
+	}
+	if _, err := os.Stat(legacy + ".imported"); err != nil {
+		t.Error(err)
+	}
+
+	// Next start, nothing left to import.
+	if err := store.importLegacy(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("auth.test called %d times, want 1", calls)
+	}
+}
+
+func TestImportLegacyAuthTestFails(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error": "invalid_auth"})
+	}))
+	defer srv.Close()
+	global.env = slackenv.ForBaseURL(srv.URL)
+	global.client = srv.Client()
+
+	dir := t.TempDir()
+	store, err := newTokenStore(filepath.Join(dir, "authsrv-slack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := filepath.Join(dir, "authsrv-slack.json")
+	if err := os.WriteFile(legacy, []byte(`{"access_token":"xoxp-legacy","team_name":"Acme"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.importLegacy(context.Background(), legacy); err == nil {
+		t.Fatal("import succeeded without team ID")
+	}
+	if len(store.List()) != 0 {
+		t.Error("token stored without team ID")
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Errorf("legacy file moved after a failed import: %v", err)
+	}
+}
+
+func TestSlackTokenFromFileV1(t *testing.T) {
+	tests := []struct {
+		name, doc       string
+		bot, user, team string
+		botUser         string
+	}{
+		{
+			// As saved by the OAuth v1 flow.
+			name: "v1",
+			doc: `{"access_token":"xoxp-user","token_type":"Bearer","expiry":"0001-01-01T00:00:00Z",` +
+				`"user_id":"U0AAAAAAA","team_name":"Acme",` +
+				`"bot":{"bot_user_id":"U0BBBBBBB","bot_access_token":"xoxb-bot"},` +
+				`"incoming_webhook":{"url":"https://hooks.slack.com/services/T0AAAAAAA/B0AAAAAAA/XXXXXXXX","channel":"#general","configuration_url":""}}`,
+			bot: "xoxb-bot", user: "xoxp-user", team: "Acme", botUser: "U0BBBBBBB",
+		},
+		{
+			name: "v1 without bot",
+			doc:  `{"access_token":"xoxp-user","token_type":"Bearer","user_id":"U0AAAAAAA","team_name":"Acme"}`,
+			user: "xoxp-user", team: "Acme",
+		},
+		{
+			// Upgraded before the user token moved to authed_user.
+			name: "v1 upgraded",
+			doc: `{"access_token":"xoxp-user","token_type":"Bearer","bot_user_id":"U0BBBBBBB",` +
+				`"team":{"id":"T0AAAAAAA","name":"Acme"},"authed_user":{"id":"U0AAAAAAA"},` +
+				`"user_id":"U0AAAAAAA","team_name":"Acme",` +
+				`"bot":{"bot_user_id":"U0BBBBBBB","bot_access_token":"xoxb-bot"}}`,
+			bot: "xoxb-bot", user: "xoxp-user", team: "Acme", botUser: "U0BBBBBBB",
+		},
+		{
+			name: "v2",
+			doc: `{"access_token":"xoxb-bot","token_type":"Bearer","bot_user_id":"U0BBBBBBB",` +
+				`"team":{"id":"T0AAAAAAA","name":"Acme"},` +
+				`"authed_user":{"id":"U0AAAAAAA","access_token":"xoxp-user"}}`,
+			bot: "xoxb-bot", user: "xoxp-user", team: "Acme", botUser: "U0BBBBBBB",
+		},
+	}
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "token.json")
+		if err := os.WriteFile(filename, []byte(tt.doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+		tok, err := slackTokenFromFile(filename)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// Saving and loading again changes nothing.
+		for i := 0; i < 2; i++ {
+			if tok.AccessToken != tt.bot || tok.userAccessToken() != tt.user {
+				t.Errorf("%s: bot token %q, user token %q, want %q and %q", tt.name, tok.AccessToken, tok.userAccessToken(), tt.bot, tt.user)
+			}
+			if tok.AuthedUser == nil || tok.AuthedUser.ID != "U0AAAAAAA" {
+				t.Errorf("%s: authed user %+v", tt.name, tok.AuthedUser)
+			}
+			if tok.TeamLabel() != tt.team || tok.BotUserID != tt.botUser {
+				t.Errorf("%s: team %q, bot user %q", tt.name, tok.TeamLabel(), tok.BotUserID)
+			}
+			if err := tok.Save(filename); err != nil {
+				t.Fatal(err)
+			}
+			if tok, err = slackTokenFromFile(filename); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
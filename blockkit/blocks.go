@@ -0,0 +1,362 @@
+package blockkit
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Block is a layout block of a message, a modal or a Home tab.
+type Block interface {
+	BlockType() string
+	Validate() error
+}
+
+// Section shows a text, optional fields and an accessory element.
+type Section struct {
+	BlockID   string  `json:"block_id,omitempty"`
+	Text      *Text   `json:"text,omitempty"`
+	Fields    []*Text `json:"fields,omitempty"`
+	Accessory Element `json:"accessory,omitempty"`
+}
+
+// BlockType implements Block.
+func (*Section) BlockType() string { return "section" }
+
+// MarshalJSON adds the type of the block.
+func (s *Section) MarshalJSON() ([]byte, error) {
+	type alias Section
+	return marshalTyped(s.BlockType(), (*alias)(s))
+}
+
+// UnmarshalJSON decodes the accessory according to its type.
+func (s *Section) UnmarshalJSON(b []byte) error {
+	type alias Section
+	var v struct {
+		*alias
+		Accessory json.RawMessage `json:"accessory"`
+	}
+	v.alias = (*alias)(s)
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	s.Accessory, err = unmarshalElement(v.Accessory)
+	return err
+}
+
+// Validate checks the section against Slack limits.
+func (s *Section) Validate() error {
+	if s.Text == nil && len(s.Fields) == 0 {
+		return fmt.Errorf("text or fields are required")
+	}
+	if err := checkText("text", s.Text, false, "", MaxTextLength); err != nil {
+		return err
+	}
+	if len(s.Fields) > MaxFields {
+		return fmt.Errorf("fields: %d fields, max is %d", len(s.Fields), MaxFields)
+	}
+	for i, f := range s.Fields {
+		if err := checkText(fmt.Sprintf("fields[%d]", i), f, true, "", MaxFieldLength); err != nil {
+			return err
+		}
+	}
+	if s.Accessory != nil {
+		if err := s.Accessory.Validate(); err != nil {
+			return fmt.Errorf("accessory.%v", err)
+		}
+	}
+	return checkLen("block_id", s.BlockID, MaxIDLength)
+}
+
+// Context shows small texts and images.
+type Context struct {
+	BlockID  string   `json:"block_id,omitempty"`
+	Elements Elements `json:"elements"`
+}
+
+// BlockType implements Block.
+func (*Context) BlockType() string { return "context" }
+
+// MarshalJSON adds the type of the block.
+func (c *Context) MarshalJSON() ([]byte, error) {
+	type alias Context
+	return marshalTyped(c.BlockType(), (*alias)(c))
+}
+
+// Validate checks the context against Slack limits.
+func (c *Context) Validate() error {
+	if len(c.Elements) == 0 || len(c.Elements) > MaxContextItems {
+		return fmt.Errorf("elements: %d elements, want 1 to %d", len(c.Elements), MaxContextItems)
+	}
+	for i, e := range c.Elements {
+		if e == nil {
+			return fmt.Errorf("elements[%d]: missing", i)
+		}
+		switch e.(type) {
+		case *Text, *ImageElement:
+		default:
+			return fmt.Errorf("elements[%d]: %s not allowed in context", i, e.ElementType())
+		}
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("elements[%d]: %v", i, err)
+		}
+	}
+	return checkLen("block_id", c.BlockID, MaxIDLength)
+}
+
+// Divider is a horizontal line.
+type Divider struct {
+	BlockID string `json:"block_id,omitempty"`
+}
+
+// BlockType implements Block.
+func (*Divider) BlockType() string { return "divider" }
+
+// MarshalJSON adds the type of the block.
+func (d *Divider) MarshalJSON() ([]byte, error) {
+	type alias Divider
+	return marshalTyped(d.BlockType(), (*alias)(d))
+}
+
+// Validate checks the divider against Slack limits.
+func (d *Divider) Validate() error {
+	return checkLen("block_id", d.BlockID, MaxIDLength)
+}
+
+// Header is a large plain text title.
+type Header struct {
+	BlockID string `json:"block_id,omitempty"`
+	Text    *Text  `json:"text"`
+}
+
+// BlockType implements Block.
+func (*Header) BlockType() string { return "header" }
+
+// MarshalJSON adds the type of the block.
+func (h *Header) MarshalJSON() ([]byte, error) {
+	type alias Header
+	return marshalTyped(h.BlockType(), (*alias)(h))
+}
+
+// Validate checks the header against Slack limits.
+func (h *Header) Validate() error {
+	return firstError(
+		checkText("text", h.Text, true, PlainText, MaxHeaderLength),
+		checkLen("block_id", h.BlockID, MaxIDLength),
+	)
+}
+
+// Image is a standalone image.
+type Image struct {
+	BlockID  string `json:"block_id,omitempty"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+	Title    *Text  `json:"title,omitempty"`
+}
+
+// BlockType implements Block.
+func (*Image) BlockType() string { return "image" }
+
+// MarshalJSON adds the type of the block.
+func (i *Image) MarshalJSON() ([]byte, error) {
+	type alias Image
+	return marshalTyped(i.BlockType(), (*alias)(i))
+}
+
+// Validate checks the image against Slack limits.
+func (i *Image) Validate() error {
+	if i.ImageURL == "" || i.AltText == "" {
+		return fmt.Errorf("image_url and alt_text are required")
+	}
+	return firstError(
+		checkLen("image_url", i.ImageURL, MaxURLLength),
+		checkLen("alt_text", i.AltText, MaxAltTextLength),
+		checkText("title", i.Title, false, PlainText, MaxFieldLength),
+		checkLen("block_id", i.BlockID, MaxIDLength),
+	)
+}
+
+// Actions holds interactive elements.
+type Actions struct {
+	BlockID  string   `json:"block_id,omitempty"`
+	Elements Elements `json:"elements"`
+}
+
+// BlockType implements Block.
+func (*Actions) BlockType() string { return "actions" }
+
+// MarshalJSON adds the type of the block.
+func (a *Actions) MarshalJSON() ([]byte, error) {
+	type alias Actions
+	return marshalTyped(a.BlockType(), (*alias)(a))
+}
+
+// Validate checks the actions against Slack limits.
+func (a *Actions) Validate() error {
+	if len(a.Elements) == 0 || len(a.Elements) > MaxActions {
+		return fmt.Errorf("elements: %d elements, want 1 to %d", len(a.Elements), MaxActions)
+	}
+	for i, e := range a.Elements {
+		if e == nil {
+			return fmt.Errorf("elements[%d]: missing", i)
+		}
+		switch e.(type) {
+		case *Button, *StaticSelect, *DatePicker:
+		default:
+			return fmt.Errorf("elements[%d]: %s not allowed in actions", i, e.ElementType())
+		}
+		if err := e.Validate(); err != nil {
+			return fmt.Errorf("elements[%d].%v", i, err)
+		}
+	}
+	return checkLen("block_id", a.BlockID, MaxIDLength)
+}
+
+// Input collects data from users in modals and Home tabs.
+type Input struct {
+	BlockID  string  `json:"block_id,omitempty"`
+	Label    *Text   `json:"label"`
+	Element  Element `json:"element"`
+	Hint     *Text   `json:"hint,omitempty"`
+	Optional bool    `json:"optional,omitempty"`
+}
+
+// BlockType implements Block.
+func (*Input) BlockType() string { return "input" }
+
+// MarshalJSON adds the type of the block.
+func (in *Input) MarshalJSON() ([]byte, error) {
+	type alias Input
+	return marshalTyped(in.BlockType(), (*alias)(in))
+}
+
+// UnmarshalJSON decodes the element according to its type.
+func (in *Input) UnmarshalJSON(b []byte) error {
+	type alias Input
+	var v struct {
+		*alias
+		Element json.RawMessage `json:"element"`
+	}
+	v.alias = (*alias)(in)
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var err error
+	in.Element, err = unmarshalElement(v.Element)
+	return err
+}
+
+// Validate checks the input against Slack limits.
+func (in *Input) Validate() error {
+	if in.Element == nil {
+		return fmt.Errorf("element: missing")
+	}
+	switch in.Element.(type) {
+	case *PlainTextInput, *StaticSelect, *DatePicker:
+	default:
+		return fmt.Errorf("element: %s not allowed in input", in.Element.ElementType())
+	}
+	if err := in.Element.Validate(); err != nil {
+		return fmt.Errorf("element.%v", err)
+	}
+	return firstError(
+		checkText("label", in.Label, true, PlainText, 2000),
+		checkText("hint", in.Hint, false, PlainText, 2000),
+		checkLen("block_id", in.BlockID, MaxIDLength),
+	)
+}
+
+// Blocks is the list of blocks of a message.
+type Blocks []Block
+
+// UnmarshalJSON decodes each block according to its type.
+func (bs *Blocks) UnmarshalJSON(b []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(b, &raws); err != nil {
+		return err
+	}
+	list := make(Blocks, 0, len(raws))
+	for _, raw := range raws {
+		typ, err := peekType(raw)
+		if err != nil {
+			return err
+		}
+		var block Block
+		switch typ {
+		case "section":
+			block = &Section{}
+		case "context":
+			block = &Context{}
+		case "divider":
+			block = &Divider{}
+		case "header":
+			block = &Header{}
+		case "image":
+			block = &Image{}
+		case "actions":
+			block = &Actions{}
+		case "input":
+			block = &Input{}
+		default:
+			return fmt.Errorf("blockkit: unknown block type %q", typ)
+		}
+		if err := json.Unmarshal(raw, block); err != nil {
+			return err
+		}
+		list = append(list, block)
+	}
+	*bs = list
+	return nil
+}
+
+// Validate checks the blocks of a message against Slack limits.
+func (bs Blocks) Validate() error {
+	return bs.validate(MaxBlocks)
+}
+
+// ValidateView checks the blocks of a modal or a Home tab, which
+// may hold more blocks than a message.
+func (bs Blocks) ValidateView() error {
+	return bs.validate(MaxModalBlocks)
+}
+
+func (bs Blocks) validate(max int) error {
+	if len(bs) > max {
+		return fmt.Errorf("blockkit: %d blocks, max is %d", len(bs), max)
+	}
+	ids := make(map[string]bool)
+	for i, b := range bs {
+		if b == nil {
+			return fmt.Errorf("blockkit: blocks[%d]: missing", i)
+		}
+		if err := b.Validate(); err != nil {
+			return fmt.Errorf("blockkit: blocks[%d] (%s): %v", i, b.BlockType(), err)
+		}
+		id := blockID(b)
+		if id != "" && ids[id] {
+			return fmt.Errorf("blockkit: blocks[%d]: duplicate block_id %q", i, id)
+		}
+		ids[id] = true
+	}
+	return nil
+}
+
+func blockID(b Block) string {
+	switch b := b.(type) {
+	case *Section:
+		return b.BlockID
+	case *Context:
+		return b.BlockID
+	case *Divider:
+		return b.BlockID
+	case *Header:
+		return b.BlockID
+	case *Image:
+		return b.BlockID
+	case *Actions:
+		return b.BlockID
+	case *Input:
+		return b.BlockID
+	}
+	return ""
+}
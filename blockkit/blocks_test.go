@@ -0,0 +1,189 @@
+package blockkit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalNull(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		err  string
+	}{
+		{"null element in actions", `[{"type":"actions","elements":[null]}]`, "null element at index 0"},
+		{"null element in context", `[{"type":"context","elements":[{"type":"mrkdwn","text":"hi"},null]}]`, "null element at index 1"},
+		{"null block", `[null]`, "missing type"},
+		{"null input element", `[{"type":"input","label":{"type":"plain_text","text":"Name"},"element":null}]`, "element: missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bs Blocks
+			err := json.Unmarshal([]byte(tt.doc), &bs)
+			if err == nil {
+				err = bs.Validate()
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateNil(t *testing.T) {
+	tests := []struct {
+		name   string
+		blocks Blocks
+	}{
+		{"nil block", Blocks{nil}},
+		{"nil actions element", Blocks{&Actions{Elements: Elements{nil}}}},
+		{"nil context element", Blocks{&Context{Elements: Elements{nil}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.blocks.Validate()
+			if err == nil || !strings.Contains(err.Error(), "missing") {
+				t.Fatalf("err = %v, want missing", err)
+			}
+		})
+	}
+}
+
+func TestSectionWithoutAccessory(t *testing.T) {
+	var bs Blocks
+	doc := `[{"type":"section","text":{"type":"mrkdwn","text":"hi"},"accessory":null}]`
+	if err := json.Unmarshal([]byte(doc), &bs); err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sections returns n valid sections.
+func sections(n int) Blocks {
+	bs := make(Blocks, n)
+	for i := range bs {
+		bs[i] = &Section{Text: Markdown("hi")}
+	}
+	return bs
+}
+
+func fields(n int) []*Text {
+	fs := make([]*Text, n)
+	for i := range fs {
+		fs[i] = Markdown("field")
+	}
+	return fs
+}
+
+func TestLimits(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("é", n) }
+	button := func(id string) Block {
+		return &Actions{Elements: Elements{&Button{Text: Plain("OK"), ActionID: id}}}
+	}
+	tests := []struct {
+		name   string
+		blocks Blocks
+		err    string
+	}{
+		{"50 blocks", sections(MaxBlocks), ""},
+		{"51 blocks", sections(MaxBlocks + 1), "51 blocks, max is 50"},
+		{"3000 characters", Blocks{&Section{Text: Markdown(long(MaxTextLength))}}, ""},
+		{"3001 characters", Blocks{&Section{Text: Markdown(long(MaxTextLength + 1))}}, "text: 3001 characters, max is 3000"},
+		{"255 characters block_id", Blocks{&Divider{BlockID: long(MaxIDLength)}}, ""},
+		{"256 characters block_id", Blocks{&Divider{BlockID: long(MaxIDLength + 1)}}, "block_id: 256 characters, max is 255"},
+		{"255 characters action_id", Blocks{button(long(MaxIDLength))}, ""},
+		{"256 characters action_id", Blocks{button(long(MaxIDLength + 1))}, "elements[0].action_id: 256 characters, max is 255"},
+		{"10 fields", Blocks{&Section{Fields: fields(MaxFields)}}, ""},
+		{"11 fields", Blocks{&Section{Fields: fields(MaxFields + 1)}}, "fields: 11 fields, max is 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.blocks.Validate()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatal(err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("err = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidateView(t *testing.T) {
+	if err := sections(MaxModalBlocks).ValidateView(); err != nil {
+		t.Error(err)
+	}
+	if err := sections(MaxModalBlocks + 1).ValidateView(); err == nil {
+		t.Error("101 blocks in a view passed")
+	}
+}
+
+func TestValidateIndex(t *testing.T) {
+	bs := sections(3)
+	bs[2] = &Header{Text: Markdown("title")}
+	err := bs.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "blockkit: blocks[2] (header): text: must be plain_text") {
+		t.Errorf("err = %v, want blocks[2] (header)", err)
+	}
+
+	bs = sections(3)
+	bs[0].(*Section).BlockID = "b"
+	bs[1].(*Section).BlockID = "b"
+	err = bs.Validate()
+	if err == nil || !strings.Contains(err.Error(), `blocks[1]: duplicate block_id "b"`) {
+		t.Errorf("err = %v, want blocks[1] duplicate", err)
+	}
+}
+
+func TestMarshalType(t *testing.T) {
+	tests := []struct {
+		v    interface{ MarshalJSON() ([]byte, error) }
+		want string
+	}{
+		{&Section{}, "section"},
+		{&Context{}, "context"},
+		{&Divider{}, "divider"},
+		{&Header{}, "header"},
+		{&Image{}, "image"},
+		{&Actions{}, "actions"},
+		{&Input{}, "input"},
+		{&Button{}, "button"},
+		{&StaticSelect{}, "static_select"},
+		{&DatePicker{}, "datepicker"},
+		{&PlainTextInput{}, "plain_text_input"},
+		{&ImageElement{}, "image"},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var v struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(b, &v); err != nil || v.Type != tt.want {
+			t.Errorf("%T marshals %s, want type %q", tt.v, b, tt.want)
+		}
+	}
+
+	// A layout written as struct literals round trips.
+	bs := Blocks{
+		&Header{Text: Plain("Build #42")},
+		&Section{Text: Markdown("*Status:* passed"), Accessory: &Button{Text: Plain("Logs"), URL: "https://ci.example.com"}},
+		&Divider{},
+	}
+	b, err := json.Marshal(bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Blocks
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if b2, _ := json.Marshal(got); string(b2) != string(b) {
+		t.Errorf("round trip:\n%s\nwant\n%s", b2, b)
+	}
+}
@@ -0,0 +1,224 @@
+package blockkit
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Element is an interactive element or, in context blocks, an image
+// or a text.
+type Element interface {
+	ElementType() string
+	Validate() error
+}
+
+// Button styles.
+const (
+	Primary = "primary"
+	Danger  = "danger"
+)
+
+// Button is a button element.
+type Button struct {
+	Text     *Text  `json:"text"`
+	ActionID string `json:"action_id,omitempty"`
+	URL      string `json:"url,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Style    string `json:"style,omitempty"`
+}
+
+// ElementType implements Element.
+func (*Button) ElementType() string { return "button" }
+
+// MarshalJSON adds the type of the element.
+func (b *Button) MarshalJSON() ([]byte, error) {
+	type alias Button
+	return marshalTyped(b.ElementType(), (*alias)(b))
+}
+
+// Validate checks the button against Slack limits.
+func (b *Button) Validate() error {
+	if err := checkText("text", b.Text, true, PlainText, MaxLabelLength); err != nil {
+		return err
+	}
+	if b.Style != "" && b.Style != Primary && b.Style != Danger {
+		return fmt.Errorf("style: unexpected %q", b.Style)
+	}
+	return firstError(
+		checkLen("action_id", b.ActionID, MaxIDLength),
+		checkLen("url", b.URL, MaxURLLength),
+		checkLen("value", b.Value, MaxValueLength),
+	)
+}
+
+// StaticSelect is a select menu with a static list of options.
+type StaticSelect struct {
+	Placeholder   *Text     `json:"placeholder,omitempty"`
+	ActionID      string    `json:"action_id,omitempty"`
+	Options       []*Option `json:"options"`
+	InitialOption *Option   `json:"initial_option,omitempty"`
+}
+
+// ElementType implements Element.
+func (*StaticSelect) ElementType() string { return "static_select" }
+
+// MarshalJSON adds the type of the element.
+func (s *StaticSelect) MarshalJSON() ([]byte, error) {
+	type alias StaticSelect
+	return marshalTyped(s.ElementType(), (*alias)(s))
+}
+
+// Validate checks the select menu against Slack limits.
+func (s *StaticSelect) Validate() error {
+	if len(s.Options) == 0 || len(s.Options) > MaxOptions {
+		return fmt.Errorf("options: %d options, want 1 to %d", len(s.Options), MaxOptions)
+	}
+	for i, o := range s.Options {
+		if err := o.validate(); err != nil {
+			return fmt.Errorf("options[%d].%v", i, err)
+		}
+	}
+	return firstError(
+		checkText("placeholder", s.Placeholder, false, PlainText, 150),
+		checkLen("action_id", s.ActionID, MaxIDLength),
+	)
+}
+
+// DatePicker lets users pick a date, formatted as YYYY-MM-DD.
+type DatePicker struct {
+	ActionID    string `json:"action_id,omitempty"`
+	Placeholder *Text  `json:"placeholder,omitempty"`
+	InitialDate string `json:"initial_date,omitempty"`
+}
+
+// ElementType implements Element.
+func (*DatePicker) ElementType() string { return "datepicker" }
+
+// MarshalJSON adds the type of the element.
+func (d *DatePicker) MarshalJSON() ([]byte, error) {
+	type alias DatePicker
+	return marshalTyped(d.ElementType(), (*alias)(d))
+}
+
+// Validate checks the date picker against Slack limits.
+func (d *DatePicker) Validate() error {
+	return firstError(
+		checkText("placeholder", d.Placeholder, false, PlainText, 150),
+		checkLen("action_id", d.ActionID, MaxIDLength),
+	)
+}
+
+// PlainTextInput is a text field, only allowed in input blocks.
+type PlainTextInput struct {
+	ActionID     string `json:"action_id,omitempty"`
+	Placeholder  *Text  `json:"placeholder,omitempty"`
+	InitialValue string `json:"initial_value,omitempty"`
+	Multiline    bool   `json:"multiline,omitempty"`
+	MaxLength    int    `json:"max_length,omitempty"`
+}
+
+// ElementType implements Element.
+func (*PlainTextInput) ElementType() string { return "plain_text_input" }
+
+// MarshalJSON adds the type of the element.
+func (p *PlainTextInput) MarshalJSON() ([]byte, error) {
+	type alias PlainTextInput
+	return marshalTyped(p.ElementType(), (*alias)(p))
+}
+
+// Validate checks the input against Slack limits.
+func (p *PlainTextInput) Validate() error {
+	return firstError(
+		checkText("placeholder", p.Placeholder, false, PlainText, 150),
+		checkLen("action_id", p.ActionID, MaxIDLength),
+	)
+}
+
+// ImageElement is an image shown in a section or a context block.
+type ImageElement struct {
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// ElementType implements Element.
+func (*ImageElement) ElementType() string { return "image" }
+
+// MarshalJSON adds the type of the element.
+func (i *ImageElement) MarshalJSON() ([]byte, error) {
+	type alias ImageElement
+	return marshalTyped(i.ElementType(), (*alias)(i))
+}
+
+// Validate checks the image against Slack limits.
+func (i *ImageElement) Validate() error {
+	if i.ImageURL == "" || i.AltText == "" {
+		return fmt.Errorf("image_url and alt_text are required")
+	}
+	return firstError(
+		checkLen("image_url", i.ImageURL, MaxURLLength),
+		checkLen("alt_text", i.AltText, MaxAltTextLength),
+	)
+}
+
+// unmarshalElement decodes an element according to its type.
+func unmarshalElement(b []byte) (Element, error) {
+	if len(b) == 0 || string(b) == "null" {
+		return nil, nil
+	}
+	typ, err := peekType(b)
+	if err != nil {
+		return nil, err
+	}
+	var e Element
+	switch typ {
+	case "button":
+		e = &Button{}
+	case "static_select":
+		e = &StaticSelect{}
+	case "datepicker":
+		e = &DatePicker{}
+	case "plain_text_input":
+		e = &PlainTextInput{}
+	case "image":
+		e = &ImageElement{}
+	case PlainText, MarkdownText:
+		e = &Text{}
+	default:
+		return nil, fmt.Errorf("blockkit: unknown element type %q", typ)
+	}
+	err = json.Unmarshal(b, e)
+	return e, err
+}
+
+// Elements is a list of elements.
+type Elements []Element
+
+// UnmarshalJSON decodes each element according to its type.
+func (es *Elements) UnmarshalJSON(b []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(b, &raws); err != nil {
+		return err
+	}
+	list := make(Elements, 0, len(raws))
+	for i, raw := range raws {
+		e, err := unmarshalElement(raw)
+		if err != nil {
+			return err
+		}
+		if e == nil {
+			return fmt.Errorf("blockkit: null element at index %d", i)
+		}
+		list = append(list, e)
+	}
+	*es = list
+	return nil
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
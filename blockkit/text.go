@@ -0,0 +1,147 @@
+// Package blockkit builds Slack Block Kit layouts and checks them
+// against the limits enforced by Slack.
+//
+// Every block and element marshals with its "type" field, so layouts
+// can be written as plain struct literals:
+//
+//	blocks := blockkit.Blocks{
+//		&blockkit.Header{Text: blockkit.Plain("Build #42")},
+//		&blockkit.Section{Text: blockkit.Markdown("*Status:* passed")},
+//		&blockkit.Divider{},
+//	}
+package blockkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Limits enforced by Slack.
+const (
+	MaxBlocks        = 50
+	MaxModalBlocks   = 100
+	MaxTextLength    = 3000
+	MaxIDLength      = 255
+	MaxHeaderLength  = 150
+	MaxFieldLength   = 2000
+	MaxFields        = 10
+	MaxLabelLength   = 75
+	MaxValueLength   = 2000
+	MaxOptionValue   = 150
+	MaxURLLength     = 3000
+	MaxOptions       = 100
+	MaxActions       = 25
+	MaxContextItems  = 10
+	MaxAltTextLength = 2000
+	MaxTitleLength   = 24
+	MaxMetadata      = 3000
+)
+
+// Text types.
+const (
+	PlainText    = "plain_text"
+	MarkdownText = "mrkdwn"
+)
+
+// Text is a text object, either plain text or Slack markup.
+type Text struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	Emoji    bool   `json:"emoji,omitempty"`
+	Verbatim bool   `json:"verbatim,omitempty"`
+}
+
+// Plain returns a plain text object, with emoji shortcodes enabled.
+func Plain(text string) *Text {
+	return &Text{Type: PlainText, Text: text, Emoji: true}
+}
+
+// Markdown returns a text object formatted with Slack markup.
+func Markdown(text string) *Text {
+	return &Text{Type: MarkdownText, Text: text}
+}
+
+// ElementType implements Element, text can be used in context blocks.
+func (t *Text) ElementType() string { return t.Type }
+
+// Validate checks the type of the text.
+func (t *Text) Validate() error {
+	if t.Type != PlainText && t.Type != MarkdownText {
+		return fmt.Errorf("unexpected text type %q", t.Type)
+	}
+	return nil
+}
+
+// Option is an entry of a select menu.
+type Option struct {
+	Text        *Text  `json:"text"`
+	Value       string `json:"value"`
+	Description *Text  `json:"description,omitempty"`
+}
+
+// Opt returns an option showing text and submitting value.
+func Opt(text, value string) *Option {
+	return &Option{Text: Plain(text), Value: value}
+}
+
+func (o *Option) validate() error {
+	if err := checkText("text", o.Text, true, PlainText, MaxLabelLength); err != nil {
+		return err
+	}
+	return checkLen("value", o.Value, MaxOptionValue)
+}
+
+// checkText checks a text object is set when required, has one of
+// the allowed types and fits in max characters.
+func checkText(name string, t *Text, required bool, allowed string, max int) error {
+	if t == nil {
+		if required {
+			return fmt.Errorf("%s: missing", name)
+		}
+		return nil
+	}
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if allowed != "" && t.Type != allowed {
+		return fmt.Errorf("%s: must be %s", name, allowed)
+	}
+	return checkLen(name, t.Text, max)
+}
+
+// checkLen checks s fits in max characters.
+func checkLen(name, s string, max int) error {
+	if n := utf8.RuneCountInString(s); n > max {
+		return fmt.Errorf("%s: %d characters, max is %d", name, n, max)
+	}
+	return nil
+}
+
+// marshalTyped marshals v, adding a "type" field set to typ.
+func marshalTyped(typ string, v interface{}) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	head := `{"type":` + strconv.Quote(typ)
+	if len(b) > 2 {
+		head += ","
+	}
+	return append([]byte(head), b[1:]...), nil
+}
+
+// peekType returns the "type" field of a JSON object.
+func peekType(b []byte) (string, error) {
+	var v struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return "", err
+	}
+	if v.Type == "" {
+		return "", fmt.Errorf("blockkit: missing type in %s", b)
+	}
+	return v.Type, nil
+}
@@ -0,0 +1,65 @@
+package blockkit
+
+import "fmt"
+
+// View types.
+const (
+	ModalView = "modal"
+	HomeView  = "home"
+)
+
+// View is a modal or a Home tab.
+type View struct {
+	Type            string `json:"type"`
+	CallbackID      string `json:"callback_id,omitempty"`
+	Title           *Text  `json:"title,omitempty"`
+	Submit          *Text  `json:"submit,omitempty"`
+	Close           *Text  `json:"close,omitempty"`
+	Blocks          Blocks `json:"blocks"`
+	PrivateMetadata string `json:"private_metadata,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	ClearOnClose    bool   `json:"clear_on_close,omitempty"`
+	NotifyOnClose   bool   `json:"notify_on_close,omitempty"`
+}
+
+// Modal returns a modal view titled title.
+func Modal(callbackID, title string, blocks ...Block) *View {
+	return &View{Type: ModalView, CallbackID: callbackID, Title: Plain(title), Blocks: blocks}
+}
+
+// Home returns a Home tab view.
+func Home(blocks ...Block) *View {
+	return &View{Type: HomeView, Blocks: blocks}
+}
+
+// Validate checks the view against Slack limits.
+func (v *View) Validate() error {
+	switch v.Type {
+	case ModalView:
+		if err := checkText("title", v.Title, true, PlainText, MaxTitleLength); err != nil {
+			return err
+		}
+	case HomeView:
+	default:
+		return fmt.Errorf("type: unexpected %q", v.Type)
+	}
+	hasInput := false
+	for _, b := range v.Blocks {
+		if _, ok := b.(*Input); ok {
+			hasInput = true
+		}
+	}
+	if hasInput && v.Type == ModalView && v.Submit == nil {
+		return fmt.Errorf("submit: required by input blocks")
+	}
+	if err := v.Blocks.ValidateView(); err != nil {
+		return err
+	}
+	return firstError(
+		checkText("submit", v.Submit, false, PlainText, MaxTitleLength),
+		checkText("close", v.Close, false, PlainText, MaxTitleLength),
+		checkLen("callback_id", v.CallbackID, MaxIDLength),
+		checkLen("external_id", v.ExternalID, MaxIDLength),
+		checkLen("private_metadata", v.PrivateMetadata, MaxMetadata),
+	)
+}
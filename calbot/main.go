@@ -1,34 +1,38 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
-	"os/user"
-	"path/filepath"
-	"time"
 
-	"golang.org/x/net/context"
+	"github.com/aitva/slackbot/agenda"
+	"github.com/aitva/slackbot/logging"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
 )
 
-// getClient uses a Context and Config to retrieve a Token
-// then generate a Client. It returns the generated Client.
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// getClient returns a client of the calendar, with the token cached
+// in agenda.TokenFile or one asked to the user.
 func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
-	cacheFile, err := tokenCacheFile()
+	cacheFile, err := agenda.TokenFile()
 	if err != nil {
-		log.Fatalf("Unable to get path to cached credential file. %v", err)
+		fatal("fail to get path to cached credential file", err)
 	}
-	tok, err := tokenFromFile(cacheFile)
+	tok, err := agenda.TokenFromFile(cacheFile)
 	if err != nil {
 		tok = getTokenFromWeb(config)
-		saveToken(cacheFile, tok)
+		slog.Info("saving credential file", "file", cacheFile)
+		if err := agenda.SaveToken(cacheFile, tok); err != nil {
+			fatal("fail to cache oauth token", err)
+		}
 	}
 	return config.Client(ctx, tok)
 }
@@ -42,94 +46,49 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 
 	var code string
 	if _, err := fmt.Scan(&code); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
+		fatal("fail to read authorization code", err)
 	}
 
 	tok, err := config.Exchange(oauth2.NoContext, code)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
+		fatal("fail to retrieve token from web", err)
 	}
 	return tok
 }
 
-// tokenCacheFile generates credential file path/filename.
-// It returns the generated credential path/filename.
-func tokenCacheFile() (string, error) {
-	usr, err := user.Current()
-	if err != nil {
-		return "", err
-	}
-	tokenCacheDir := filepath.Join(usr.HomeDir, ".credentials")
-	os.MkdirAll(tokenCacheDir, 0700)
-	return filepath.Join(tokenCacheDir,
-		url.QueryEscape("calendar-go-quickstart.json")), err
-}
-
-// tokenFromFile retrieves a Token from a given file path.
-// It returns the retrieved Token and any read error encountered.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	t := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(t)
-	defer f.Close()
-	return t, err
-}
-
-// saveToken uses a file path to create a file and store the
-// token in it.
-func saveToken(file string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", file)
-	f, err := os.Create(file)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
 func main() {
 	ctx := context.Background()
-
-	b, err := ioutil.ReadFile("client_secret.json")
-	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+	if err := logging.Setup("calbot"); err != nil {
+		fatal("fail to set up logs", err)
 	}
 
-	// If modifying these scopes, delete your previously saved credentials
+	// If modifying the scopes, delete your previously saved credentials
 	// at ~/.credentials/calendar-go-quickstart.json
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	config, err := agenda.Config("client_secret.json")
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		fatal("fail to read client secret file", err)
 	}
 	client := getClient(ctx, config)
 
 	srv, err := calendar.New(client)
 	if err != nil {
-		log.Fatalf("Unable to retrieve calendar Client %v", err)
+		fatal("fail to create calendar client", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(srv)
+		return
 	}
 
-	t := time.Now().Format(time.RFC3339)
-	events, err := srv.Events.List("primary").ShowDeleted(false).
-		SingleEvents(true).TimeMin(t).MaxResults(10).OrderBy("startTime").Do()
+	items, err := agenda.Upcoming(srv, 10)
 	if err != nil {
-		log.Fatalf("Unable to retrieve next ten of the user's events. %v", err)
+		fatal("fail to retrieve upcoming events", err)
 	}
 
 	fmt.Println("Upcoming events:")
-	if len(events.Items) > 0 {
-		for _, i := range events.Items {
-			var when string
-			// If the DateTime is an empty string the Event is an all-day Event.
-			// So only Date is available.
-			if i.Start.DateTime != "" {
-				when = i.Start.DateTime
-			} else {
-				when = i.Start.Date
-			}
-			fmt.Printf("%s (%s)\n", i.Summary, when)
+	if len(items) > 0 {
+		for _, i := range items {
+			fmt.Printf("%s (%s)\n", i.Summary, agenda.When(i))
 		}
 	} else {
 		fmt.Printf("No upcoming events found.\n")
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/interact"
+	"github.com/aitva/slackbot/slackenv"
+	"google.golang.org/api/calendar/v3"
+)
+
+// RSVP answers, as named by the Calendar API.
+var rsvpLabels = map[string]string{
+	"accepted":  "Going",
+	"tentative": "Maybe",
+	"declined":  "Not going",
+}
+
+var rsvpOrder = []string{"accepted", "tentative", "declined"}
+
+// rsvpButtons answers an event of the agenda.
+func rsvpButtons(e *calendar.Event) blockkit.Block {
+	var buttons blockkit.Elements
+	for _, status := range rsvpOrder {
+		b := &blockkit.Button{
+			Text:     blockkit.Plain(rsvpLabels[status]),
+			ActionID: "rsvp_" + status,
+			Value:    e.Id,
+		}
+		if status == "accepted" {
+			b.Style = blockkit.Primary
+		}
+		buttons = append(buttons, b)
+	}
+	return &blockkit.Actions{Elements: buttons}
+}
+
+// rsvp sets the answer of the calendar owner to an event.
+func rsvp(srv *calendar.Service, eventID, status string) (*calendar.Event, error) {
+	e, err := srv.Events.Get("primary", eventID).Do()
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, a := range e.Attendees {
+		if a.Self {
+			a.ResponseStatus = status
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("you are not invited to %s", e.Summary)
+	}
+	return srv.Events.Patch("primary", eventID, &calendar.Event{Attendees: e.Attendees}).Do()
+}
+
+// newInteractions handles the RSVP buttons of the agenda.
+func newInteractions(srv *calendar.Service, secret string, slack *slackenv.Config) *interact.Router {
+	r := interact.NewRouter(secret)
+	client := interact.NewClient("", slack)
+	for _, status := range rsvpOrder {
+		status := status
+		r.HandleFunc(interact.BlockActions, "rsvp_"+status, func(ctx context.Context, p *interact.Payload) (*interact.Response, error) {
+			text := ""
+			e, err := rsvp(srv, p.Action.Value, status)
+			if err != nil {
+				text = "Sorry, I could not answer: " + err.Error()
+			} else {
+				text = fmt.Sprintf("You answered *%s* to %s.", rsvpLabels[status], e.Summary)
+			}
+			return nil, client.Respond(ctx, p.ResponseURL, map[string]interface{}{
+				"response_type":    "ephemeral",
+				"replace_original": false,
+				"text":             text,
+			})
+		})
+	}
+	return r
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aitva/slackbot/agenda"
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/slackenv"
+	"github.com/aitva/slackbot/slash"
+	"google.golang.org/api/calendar/v3"
+)
+
+// serve answers slash commands over HTTP.
+func serve(srv *calendar.Service) {
+	secret := os.Getenv("SLACK_SIGNING_SECRET")
+	if secret == "" {
+		slog.Error("variable SLACK_SIGNING_SECRET must be defined")
+		os.Exit(1)
+	}
+	addr := os.Getenv("ADDR")
+	if addr == "" {
+		addr = ":3003"
+	}
+	slack, err := slackenv.Load()
+	if err != nil {
+		fatal("fail to load Slack config", err)
+	}
+
+	router := command.NewRouter(10 * time.Second)
+	router.Handle("agenda", agenda.Handler(srv, rsvpButtons))
+	http.Handle("/slack/commands", slash.NewHandler(router, secret))
+	http.Handle("/slack/interactive", newInteractions(srv, secret, slack))
+
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9104"
+	}
+	go func() {
+		fatal("fail to serve admin endpoints", metrics.ListenAndServe(adminAddr, nil))
+	}()
+
+	slog.Info("listening", "addr", addr)
+	fatal("fail to serve", http.ListenAndServe(addr, nil))
+}
@@ -0,0 +1,248 @@
+// Package cassette records RTM sessions to JSONL files and replays
+// them, so the parsing and routing of the bots can be checked without
+// network access.
+//
+// A cassette holds one frame per line: the start of the session, then
+// the frames received and sent by the bot, in order. Tokens and user
+// IDs are redacted while recording:
+//
+//	{"dir":"start","t":0,"frame":{"ok":true,"self":{"id":"U00000001"}}}
+//	{"dir":"recv","t":112,"frame":{"type":"hello"}}
+//	{"dir":"recv","t":5380,"frame":{"type":"message","user":"U00000002","text":"<@U00000001>: help"}}
+//	{"dir":"send","t":5402,"frame":{"id":0,"type":"message","text":"Hello!"}}
+//
+// Messages sent with the Web API, like the ones with blocks, are not
+// part of the session and are not recorded.
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of a frame.
+const (
+	Start = "start"
+	Recv  = "recv"
+	Send  = "send"
+)
+
+// Frame is a line of a cassette.
+type Frame struct {
+	Dir string `json:"dir"`
+	// T is the time since the start of the session, in milliseconds.
+	// It is informative, replays don't wait.
+	T     int64           `json:"t"`
+	Frame json.RawMessage `json:"frame"`
+}
+
+// Conn is the part of a websocket used by the bots.
+// *websocket.Conn implements it.
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteJSON(v interface{}) error
+}
+
+var (
+	tokenRe = regexp.MustCompile(`xox[a-z]-[A-Za-z0-9-]+`)
+	userRe  = regexp.MustCompile(`\b[UW][A-Z0-9]{6,}\b`)
+)
+
+// redactedKeys are removed from the frames, they describe users.
+var redactedKeys = map[string]bool{
+	"name":         true,
+	"user_profile": true,
+	"profile":      true,
+	"real_name":    true,
+	"display_name": true,
+	"email":        true,
+}
+
+// Redactor hides tokens and users. Each user ID is replaced by the
+// same pseudonym for the whole session, so conversations still make
+// sense when replayed.
+type Redactor struct {
+	mu    sync.Mutex
+	users map[string]string
+}
+
+// NewRedactor creates a redactor.
+func NewRedactor() *Redactor {
+	return &Redactor{users: make(map[string]string)}
+}
+
+// User returns the pseudonym of a user ID.
+func (r *Redactor) User(id string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.users[id]
+	if !ok {
+		p = fmt.Sprintf("%c%08d", id[0], len(r.users)+1)
+		r.users[id] = p
+	}
+	return p
+}
+
+// String redacts the tokens and user IDs found in s.
+func (r *Redactor) String(s string) string {
+	s = tokenRe.ReplaceAllStringFunc(s, func(tok string) string {
+		return tok[:5] + "REDACTED"
+	})
+	return userRe.ReplaceAllStringFunc(s, func(id string) string {
+		// IDs have digits, unlike shouted words.
+		if !strings.ContainsAny(id, "0123456789") {
+			return id
+		}
+		return r.User(id)
+	})
+}
+
+// Frame redacts a JSON frame.
+func (r *Redactor) Frame(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return marshal(r.value(v))
+}
+
+// marshal encodes v without escaping HTML, so mentions like <@U1>
+// stay readable.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (r *Redactor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return r.String(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = r.value(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			if redactedKeys[k] {
+				delete(v, k)
+				continue
+			}
+			v[k] = r.value(v[k])
+		}
+	}
+	return v
+}
+
+// Recorder writes a session to a cassette.
+type Recorder struct {
+	// Redact hides tokens and users, frames are recorded as is if
+	// nil.
+	Redact *Redactor
+
+	mu    sync.Mutex
+	w     io.Writer
+	start time.Time
+	err   error
+}
+
+// NewRecorder creates a recorder writing to w, with redaction.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{Redact: NewRedactor(), w: w, start: time.Now()}
+}
+
+// Record writes a frame going in dir.
+func (r *Recorder) Record(dir string, frame []byte) error {
+	err := r.record(dir, frame)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *Recorder) record(dir string, frame []byte) error {
+	var err error
+	if r.Redact != nil {
+		frame, err = r.Redact.Frame(frame)
+		if err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, err := marshal(&Frame{
+		Dir:   dir,
+		T:     int64(time.Since(r.start) / time.Millisecond),
+		Frame: frame,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(b, '\n'))
+	return err
+}
+
+// Err returns the first error met while recording. The connections
+// returned by Conn don't fail when a frame cannot be recorded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Start records the start of the session, like the answer of
+// rtm.start. Fields telling where to connect must be cleared by the
+// caller.
+func (r *Recorder) Start(v interface{}) error {
+	b, err := marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.Record(Start, b)
+}
+
+// Conn returns a connection recording the frames read from and
+// written to c.
+func (r *Recorder) Conn(c Conn) Conn {
+	return &recordConn{Conn: c, rec: r}
+}
+
+type recordConn struct {
+	Conn
+	rec *Recorder
+}
+
+func (c *recordConn) ReadMessage() (int, []byte, error) {
+	typ, p, err := c.Conn.ReadMessage()
+	if err == nil && isJSON(p) {
+		c.rec.Record(Recv, p)
+	}
+	return typ, p, err
+}
+
+// WriteJSON records v before writing it, so the answer of Slack can't
+// be recorded first.
+func (c *recordConn) WriteJSON(v interface{}) error {
+	if b, err := marshal(v); err == nil {
+		c.rec.Record(Send, b)
+	}
+	return c.Conn.WriteJSON(v)
+}
+
+func isJSON(p []byte) bool {
+	return strings.HasPrefix(string(bytes.TrimSpace(p)), "{")
+}
@@ -0,0 +1,102 @@
+package cassette
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactor(t *testing.T) {
+	r := NewRedactor()
+	tests := []struct {
+		frame, want string
+	}{
+		{
+			`{"type":"message","user":"U0AAAAAAA","text":"<@U0BBBBBBB>: hi, token xoxb-123-abc"}`,
+			`{"text":"<@U00000002>: hi, token xoxb-REDACTED","type":"message","user":"U00000001"}`,
+		},
+		// The same user keeps the same pseudonym, words in capitals
+		// are left alone.
+		{`{"user":"U0AAAAAAA","text":"DEPLOYED"}`, `{"text":"DEPLOYED","user":"U00000001"}`},
+		{
+			`{"self":{"id":"U0BBBBBBB","name":"timerbot"},"user":{"name":"alice","real_name":"Alice","profile":{"email":"a@example.com"}}}`,
+			`{"self":{"id":"U00000002"},"user":{}}`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := r.Frame([]byte(tt.frame))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Frame(%s)\n got %s\nwant %s", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	if err := rec.Start(map[string]interface{}{"ok": true}); err != nil {
+		t.Fatal(err)
+	}
+	rec.Record(Recv, []byte(`{"type":"message","text":"ping"}`))
+	rec.Record(Send, []byte(`{"id":0,"type":"message","text":"pong"}`))
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Timeout = 100 * time.Millisecond
+	_, b, err := p.ReadMessage()
+	if err != nil || !strings.Contains(string(b), "ping") {
+		t.Fatalf("ReadMessage = %s, %v", b, err)
+	}
+	if err := p.WriteJSON(map[string]interface{}{"type": "message", "id": 0, "text": "pong"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.ReadMessage(); err != ErrEnd {
+		t.Errorf("err = %v, want ErrEnd", err)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	doc := `{"dir":"recv","t":0,"frame":{"type":"message","text":"ping"}}
+{"dir":"send","t":1,"frame":{"id":0,"type":"message","text":"pong"}}
+`
+	p, err := Load(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Timeout = 50 * time.Millisecond
+	if _, _, err := p.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bot never answers, Wait gives up after the timeout.
+	done := make(chan error, 1)
+	go func() { done <- p.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait hangs")
+	}
+	m, ok := err.(*Mismatch)
+	if !ok || m.Index != 0 || m.Got != nil {
+		t.Fatalf("err = %v, want a missing frame 0", err)
+	}
+
+	// The bot answers differently.
+	p.WriteJSON(map[string]interface{}{"id": 0, "type": "message", "text": "pang"})
+	m, ok = p.Wait().(*Mismatch)
+	if !ok || m.Index != 0 || m.Got == nil {
+		t.Errorf("err = %v, want frame 0 to differ", m)
+	}
+}
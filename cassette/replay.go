@@ -0,0 +1,236 @@
+package cassette
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultTimeout is how long a player waits for the frames the bot
+// should send.
+const DefaultTimeout = 2 * time.Second
+
+// ErrEnd is returned by Player.ReadMessage once every frame of the
+// cassette was read.
+var ErrEnd = errors.New("cassette: end of session")
+
+// Player replays a cassette to a bot. It implements Conn: reads return
+// the frames the bot received during the recording, and writes are
+// compared with the frames it sent.
+//
+// The frames are returned in the order of the cassette, without
+// waiting. Before returning a frame, the player waits for the bot to
+// send the frames recorded before it, so the replay doesn't depend on
+// timing:
+//
+//	p, err := cassette.Open("testdata/help.jsonl")
+//	go workers.Read(ctx, p, log) // the outbox sends to p
+//	if err := p.Wait(); err != nil {
+//		t.Fatal(err)
+//	}
+type Player struct {
+	// Timeout is how long to wait for the frames the bot should send.
+	Timeout time.Duration
+
+	start  json.RawMessage
+	frames []*Frame
+	want   []json.RawMessage
+
+	mu      sync.Mutex
+	next    int
+	got     []json.RawMessage
+	changed chan struct{}
+}
+
+// Load reads a cassette from r.
+func Load(r io.Reader) (*Player, error) {
+	p := &Player{Timeout: DefaultTimeout, changed: make(chan struct{})}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+		f := &Frame{}
+		if err := json.Unmarshal(s.Bytes(), f); err != nil {
+			return nil, fmt.Errorf("cassette: line %d: %v", line, err)
+		}
+		switch f.Dir {
+		case Start:
+			p.start = f.Frame
+		case Recv:
+			p.frames = append(p.frames, f)
+		case Send:
+			p.frames = append(p.frames, f)
+			p.want = append(p.want, f.Frame)
+		default:
+			return nil, fmt.Errorf("cassette: line %d: unexpected direction %q", line, f.Dir)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Open reads the cassette in filename.
+func Open(filename string) (*Player, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// Start decodes the start of the session into v, like the answer of
+// rtm.start.
+func (p *Player) Start(v interface{}) error {
+	if p.start == nil {
+		return errors.New("cassette: no start frame")
+	}
+	return json.Unmarshal(p.start, v)
+}
+
+// ReadMessage returns the next frame received by the bot, ErrEnd at
+// the end of the cassette.
+func (p *Player) ReadMessage() (int, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.next < len(p.frames) {
+		f := p.frames[p.next]
+		if f.Dir == Send {
+			p.next++
+			continue
+		}
+		if err := p.waitLocked(p.sentBefore()); err != nil {
+			return 0, nil, err
+		}
+		p.next++
+		return websocket.TextMessage, f.Frame, nil
+	}
+	return 0, nil, ErrEnd
+}
+
+// WriteJSON records a frame sent by the bot.
+func (p *Player) WriteJSON(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.got = append(p.got, b)
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return nil
+}
+
+// waitLocked waits for the bot to send n frames, and returns a
+// *Mismatch past p.Timeout. The caller must hold p.mu.
+func (p *Player) waitLocked(n int) error {
+	timer := time.NewTimer(p.Timeout)
+	defer timer.Stop()
+	for len(p.got) < n {
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+			p.mu.Lock()
+		case <-timer.C:
+			p.mu.Lock()
+			return p.mismatch(len(p.got))
+		}
+	}
+	return nil
+}
+
+// sentBefore counts the frames the bot sent before the next one it
+// receives. The caller must hold p.mu.
+func (p *Player) sentBefore() int {
+	n := 0
+	for _, f := range p.frames[:p.next] {
+		if f.Dir == Send {
+			n++
+		}
+	}
+	return n
+}
+
+// Sent returns the frames sent by the bot.
+func (p *Player) Sent() []json.RawMessage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]json.RawMessage(nil), p.got...)
+}
+
+// Wait waits for the bot to send every frame of the cassette, then
+// compares them with the recording. It returns a *Mismatch describing
+// the first difference.
+func (p *Player) Wait() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.waitLocked(len(p.want)); err != nil {
+		return err
+	}
+	return p.mismatch(-1)
+}
+
+// Mismatch is a frame the bot sent differently from the recording.
+type Mismatch struct {
+	// Index is the position of the frame among the sent ones.
+	Index int
+	// Want is the recorded frame, Got the one sent by the bot. Either
+	// is nil when the bot sent too few or too many frames.
+	Want, Got json.RawMessage
+}
+
+func (m *Mismatch) Error() string {
+	switch {
+	case m.Got == nil:
+		return fmt.Sprintf("cassette: frame %d not sent, want %s", m.Index, m.Want)
+	case m.Want == nil:
+		return fmt.Sprintf("cassette: unexpected frame %d: %s", m.Index, m.Got)
+	}
+	return fmt.Sprintf("cassette: frame %d differs:\n got %s\nwant %s", m.Index, m.Got, m.Want)
+}
+
+// mismatch compares the frames sent so far with the recording, then
+// reports the frame at index missing if the bot didn't send it. The
+// caller must hold p.mu.
+func (p *Player) mismatch(missing int) error {
+	for i := 0; i < len(p.got) && i < len(p.want); i++ {
+		if !sameJSON(p.got[i], p.want[i]) {
+			return &Mismatch{Index: i, Want: p.want[i], Got: p.got[i]}
+		}
+	}
+	if missing >= 0 && missing < len(p.want) {
+		return &Mismatch{Index: missing, Want: p.want[missing]}
+	}
+	if len(p.got) > len(p.want) {
+		return &Mismatch{Index: len(p.want), Got: p.got[len(p.want)]}
+	}
+	return nil
+}
+
+// sameJSON compares JSON documents, ignoring the order of the keys.
+func sameJSON(a, b []byte) bool {
+	var va, vb interface{}
+	da := json.NewDecoder(bytes.NewReader(a))
+	da.UseNumber()
+	db := json.NewDecoder(bytes.NewReader(b))
+	db.UseNumber()
+	if da.Decode(&va) != nil || db.Decode(&vb) != nil {
+		return bytes.Equal(a, b)
+	}
+	return reflect.DeepEqual(va, vb)
+}
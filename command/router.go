@@ -0,0 +1,179 @@
+// Package command routes the commands users give to the bots, either
+// by mentioning them over RTM or with slash commands, to the same
+// handlers.
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/metrics"
+)
+
+// ErrUnknownCommand is returned by Dispatch for commands without
+// handler.
+var ErrUnknownCommand = errors.New("command: unknown command")
+
+// Sources of a command.
+const (
+	RTM   = "rtm"
+	Slash = "slash"
+)
+
+// Request is a command given by a user.
+type Request struct {
+	// Name is the command, without the leading slash.
+	Name string
+	// Args are the words following the command, Text the raw text.
+	Args []string
+	Text string
+
+	Source  string
+	Team    string
+	Channel string
+	User    string
+	// TS identifies the message holding the command, ThreadTS the
+	// thread it was sent in, if any.
+	TS       string
+	ThreadTS string
+	// TriggerID lets slash commands open modals.
+	TriggerID string
+	// ResponseURL is where slash commands post delayed responses.
+	ResponseURL string
+}
+
+// Response is the answer to a command.
+type Response struct {
+	Text   string
+	Blocks blockkit.Blocks
+	// InChannel makes the answer to a slash command visible to
+	// everyone, it is only shown to the user otherwise. Answers over
+	// RTM are always visible.
+	InChannel bool
+	// ReplaceOriginal makes the delayed answer to a slow slash
+	// command replace its acknowledgment. Slack only replaces it with
+	// an ephemeral answer.
+	ReplaceOriginal bool
+	// Thread asks to answer in a thread, to keep the messages of a
+	// multi-step command together.
+	Thread bool
+}
+
+// Handler answers a command.
+type Handler interface {
+	ServeCommand(ctx context.Context, req *Request) (*Response, error)
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, req *Request) (*Response, error)
+
+// ServeCommand calls f.
+func (f HandlerFunc) ServeCommand(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
+
+// Router dispatches commands to handlers by name.
+type Router struct {
+	// Timeout bounds the time a handler may take, no bound if zero.
+	Timeout time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+// NewRouter creates a router whose handlers may run for timeout.
+func NewRouter(timeout time.Duration) *Router {
+	return &Router{Timeout: timeout, handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler of a command.
+func (r *Router) Handle(name string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[strings.TrimPrefix(name, "/")] = h
+}
+
+// HandleFunc registers a function as the handler of a command.
+func (r *Router) HandleFunc(name string, f func(ctx context.Context, req *Request) (*Response, error)) {
+	r.Handle(name, HandlerFunc(f))
+}
+
+// Names returns the registered commands, sorted.
+func (r *Router) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dispatch runs the handler of a command, bounded by the router
+// timeout.
+func (r *Router) Dispatch(ctx context.Context, req *Request) (*Response, error) {
+	r.mu.RLock()
+	h, ok := r.handlers[req.Name]
+	r.mu.RUnlock()
+	if !ok {
+		// Names typed by users would make too many series.
+		metrics.Commands.Inc("", "unknown")
+		return nil, ErrUnknownCommand
+	}
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		resp *Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// The handler runs apart from the middlewares of the caller,
+		// its panics are recovered here.
+		defer func() {
+			if v := recover(); v != nil {
+				buf := make([]byte, 4096)
+				buf = buf[:runtime.Stack(buf, false)]
+				done <- result{nil, fmt.Errorf("command: %s: panic: %v\n%s", req.Name, v, buf)}
+			}
+		}()
+		resp, err := h.ServeCommand(ctx, req)
+		done <- result{resp, err}
+	}()
+	select {
+	case res := <-done:
+		outcome := "ok"
+		if res.err != nil {
+			outcome = "error"
+		}
+		metrics.Commands.Inc(req.Name, outcome)
+		return res.resp, res.err
+	case <-ctx.Done():
+		metrics.Commands.Inc(req.Name, "timeout")
+		return nil, ctx.Err()
+	}
+}
+
+// Parse splits the text of a command into its name and arguments.
+func Parse(text string) (name string, args []string, rest string) {
+	text = strings.TrimSpace(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", nil, ""
+	}
+	name = strings.TrimPrefix(fields[0], "/")
+	rest = strings.TrimSpace(text[len(fields[0]):])
+	return name, fields[1:], rest
+}
@@ -0,0 +1,57 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	r := NewRouter(50 * time.Millisecond)
+	r.HandleFunc("hello", func(ctx context.Context, req *Request) (*Response, error) {
+		return &Response{Text: "Hello " + req.Text}, nil
+	})
+	r.HandleFunc("/slow", func(ctx context.Context, req *Request) (*Response, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	r.HandleFunc("panic", func(ctx context.Context, req *Request) (*Response, error) {
+		var m map[string]int
+		m[req.Name]++
+		return nil, nil
+	})
+
+	resp, err := r.Dispatch(context.Background(), &Request{Name: "hello", Text: "world"})
+	if err != nil || resp.Text != "Hello world" {
+		t.Errorf("hello = %+v, %v", resp, err)
+	}
+	if _, err := r.Dispatch(context.Background(), &Request{Name: "nope"}); err != ErrUnknownCommand {
+		t.Errorf("nope: err = %v, want %v", err, ErrUnknownCommand)
+	}
+	if _, err := r.Dispatch(context.Background(), &Request{Name: "slow"}); err != context.DeadlineExceeded {
+		t.Errorf("slow: err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	// A panic of the handler is an error, the bot keeps running.
+	resp, err = r.Dispatch(context.Background(), &Request{Name: "panic"})
+	if resp != nil || err == nil || !strings.Contains(err.Error(), "panic: assignment to entry in nil map") {
+		t.Errorf("panic = %+v, %v", resp, err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text, name, rest string
+		args             []string
+	}{
+		{"", "", "", nil},
+		{"  /timer start  acme ", "timer", "start  acme", []string{"start", "acme"}},
+		{"help", "help", "", []string{}},
+	}
+	for _, tt := range tests {
+		name, args, rest := Parse(tt.text)
+		if name != tt.name || rest != tt.rest || strings.Join(args, " ") != strings.Join(tt.args, " ") {
+			t.Errorf("Parse(%q) = %q, %q, %q", tt.text, name, args, rest)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package dialog keeps multi-turn conversations between users and a
+// bot. A conversation follows a Flow, a list of questions whose
+// answers are validated before the next question is asked.
+//
+// Conversations are plain data saved in a Store, so the ones in
+// flight when the bot stops resume when it starts again.
+package dialog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout is how long a conversation waits for an answer when
+// its flow doesn't say.
+const DefaultTimeout = 5 * time.Minute
+
+// CancelWord stops a conversation.
+const CancelWord = "cancel"
+
+// ErrUnknownFlow is returned by Start for flows not registered.
+var ErrUnknownFlow = errors.New("dialog: unknown flow")
+
+// Key identifies a conversation. Thread is the timestamp of the
+// thread the conversation happens in, empty outside threads.
+type Key struct {
+	Team    string `json:"team"`
+	Channel string `json:"channel"`
+	User    string `json:"user"`
+	Thread  string `json:"thread"`
+}
+
+func (k Key) String() string {
+	return strings.Join([]string{k.Team, k.Channel, k.User, k.Thread}, "/")
+}
+
+// State is a conversation in flight.
+type State struct {
+	Key     Key               `json:"key"`
+	Flow    string            `json:"flow"`
+	Step    int               `json:"step"`
+	Answers map[string]string `json:"answers"`
+	Expires time.Time         `json:"expires"`
+}
+
+// Step is a question of a flow. The answer is saved in
+// State.Answers under Name.
+type Step struct {
+	Name string
+	// Prompt returns the question.
+	Prompt func(st *State) string
+	// Validate checks the answer, its error is shown to the user and
+	// the question is asked again. Optional. Prompt and Validate get
+	// a copy of the state.
+	Validate func(answer string, st *State) error
+}
+
+// Flow is a conversation.
+type Flow struct {
+	Name    string
+	Steps   []Step
+	Timeout time.Duration
+	// Done is called with every answer, it returns the last message
+	// of the conversation.
+	Done func(ctx context.Context, st *State) (string, error)
+}
+
+// Ask returns a step asking a fixed question.
+func Ask(name, question string, validate func(string, *State) error) Step {
+	return Step{
+		Name:     name,
+		Prompt:   func(*State) string { return question },
+		Validate: validate,
+	}
+}
+
+// Store saves the conversations in flight.
+type Store interface {
+	Load() ([]*State, error)
+	Save(states []*State) error
+}
+
+// Manager runs the conversations.
+type Manager struct {
+	mu     sync.Mutex
+	store  Store
+	now    func() time.Time
+	flows  map[string]*Flow
+	states map[Key]*State
+}
+
+// NewManager resumes the conversations saved in store.
+func NewManager(store Store) (*Manager, error) {
+	m := &Manager{
+		store:  store,
+		now:    time.Now,
+		flows:  make(map[string]*Flow),
+		states: make(map[Key]*State),
+	}
+	states, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range states {
+		m.states[st.Key] = st
+	}
+	return m, nil
+}
+
+// Register adds a flow conversations can follow.
+func (m *Manager) Register(f *Flow) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flows[f.Name] = f
+}
+
+// save writes the conversations, the caller must hold m.mu.
+func (m *Manager) save() error {
+	states := make([]*State, 0, len(m.states))
+	for _, st := range m.states {
+		states = append(states, st)
+	}
+	return m.store.Save(states)
+}
+
+// Flush writes the conversations, once the changes in progress are
+// done.
+func (m *Manager) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.save()
+}
+
+func (m *Manager) timeout(f *Flow) time.Duration {
+	if f.Timeout > 0 {
+		return f.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Start starts the conversation key following flow, replacing the
+// one in flight. Answers already known can be given, their steps
+// are skipped. It returns the first question, or the last message
+// if every answer is known.
+func (m *Manager) Start(ctx context.Context, key Key, flow string, answers map[string]string) (string, error) {
+	m.mu.Lock()
+	f, ok := m.flows[flow]
+	if !ok {
+		m.mu.Unlock()
+		return "", ErrUnknownFlow
+	}
+	st := &State{Key: key, Flow: flow, Answers: make(map[string]string)}
+	for k, v := range answers {
+		st.Answers[k] = v
+	}
+	m.states[key] = st
+	m.mu.Unlock()
+	return m.next(ctx, f, st)
+}
+
+// next asks the first question without answer, or ends the
+// conversation.
+func (m *Manager) next(ctx context.Context, f *Flow, st *State) (string, error) {
+	m.mu.Lock()
+	for st.Step < len(f.Steps) {
+		if _, ok := st.Answers[f.Steps[st.Step].Name]; !ok {
+			break
+		}
+		st.Step++
+	}
+	if st.Step < len(f.Steps) {
+		st.Expires = m.now().Add(m.timeout(f))
+		err := m.save()
+		step, snap := f.Steps[st.Step], st.copy()
+		m.mu.Unlock()
+		return step.Prompt(snap), err
+	}
+	delete(m.states, st.Key)
+	err := m.save()
+	m.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	// st left m.states, nothing else changes it.
+	return f.Done(ctx, st)
+}
+
+// copy returns a copy of st flows can read without m.mu.
+func (st *State) copy() *State {
+	c := *st
+	c.Answers = make(map[string]string, len(st.Answers))
+	for k, v := range st.Answers {
+		c.Answers[k] = v
+	}
+	return &c
+}
+
+// Handle gives a message to the conversation key. It returns false
+// if no conversation waits for this message, which should then be
+// handled as usual.
+func (m *Manager) Handle(ctx context.Context, key Key, text string) (reply string, ok bool, err error) {
+	m.mu.Lock()
+	st, ok := m.states[key]
+	if ok && m.now().After(st.Expires) {
+		delete(m.states, key)
+		ok = false
+		err = m.save()
+	}
+	if !ok {
+		m.mu.Unlock()
+		return "", false, err
+	}
+	// A conversation saved by another version of the bot may follow a
+	// flow removed since, or with fewer steps.
+	f, known := m.flows[st.Flow]
+	if !known || st.Step < 0 || st.Step >= len(f.Steps) ||
+		strings.EqualFold(strings.TrimSpace(text), CancelWord) {
+		delete(m.states, key)
+		err := m.save()
+		m.mu.Unlock()
+		return "Okay, never mind.", true, err
+	}
+	at := st.Step
+	step, snap := f.Steps[at], st.copy()
+	m.mu.Unlock()
+
+	answer := strings.TrimSpace(text)
+	if step.Validate != nil {
+		if err := step.Validate(answer, snap); err != nil {
+			return fmt.Sprintf("%v\n%s (or `%s`)", err, step.Prompt(snap), CancelWord), true, nil
+		}
+	}
+	m.mu.Lock()
+	if m.states[key] != st || st.Step != at {
+		// Cancelled, replaced or answered meanwhile.
+		m.mu.Unlock()
+		return "", false, nil
+	}
+	st.Answers[step.Name] = answer
+	st.Step++
+	m.mu.Unlock()
+	reply, err = m.next(ctx, f, st)
+	return reply, true, err
+}
+
+// Cancel stops the conversation key.
+func (m *Manager) Cancel(key Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.states[key]; !ok {
+		return nil
+	}
+	delete(m.states, key)
+	return m.save()
+}
+
+// Expire stops the conversations which waited too long for an
+// answer and returns them, so users can be told.
+func (m *Manager) Expire() ([]*State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var expired []*State
+	now := m.now()
+	for key, st := range m.states {
+		if now.After(st.Expires) {
+			expired = append(expired, st)
+			delete(m.states, key)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	return expired, m.save()
+}
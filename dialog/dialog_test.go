@@ -0,0 +1,130 @@
+package dialog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore keeps the conversations in memory.
+type memStore struct {
+	mu     sync.Mutex
+	states []*State
+}
+
+func (s *memStore) Load() ([]*State, error) { return s.states, nil }
+
+func (s *memStore) Save(states []*State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states = states
+	return nil
+}
+
+var pizzaFlow = &Flow{
+	Name: "pizza",
+	Steps: []Step{
+		Ask("size", "Which size?", func(size string, st *State) error {
+			if size != "small" && size != "large" {
+				return errors.New("Small or large.")
+			}
+			return nil
+		}),
+		Ask("topping", "Which topping?", nil),
+	},
+	Done: func(ctx context.Context, st *State) (string, error) {
+		return fmt.Sprintf("A %s pizza with %s.", st.Answers["size"], st.Answers["topping"]), nil
+	},
+}
+
+func newTestManager(t *testing.T, saved ...*State) *Manager {
+	m, err := NewManager(&memStore{states: saved})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Register(pizzaFlow)
+	return m
+}
+
+func TestFlow(t *testing.T) {
+	m := newTestManager(t)
+	key := Key{Team: "T1", Channel: "C1", User: "U1"}
+	ctx := context.Background()
+	steps := []struct {
+		text, reply string
+	}{
+		{"medium", "Small or large.\nWhich size? (or `cancel`)"},
+		{"large", "Which topping?"},
+		{"olives", "A large pizza with olives."},
+	}
+	q, err := m.Start(ctx, key, "pizza", nil)
+	if err != nil || q != "Which size?" {
+		t.Fatalf("Start = %q, %v", q, err)
+	}
+	for _, s := range steps {
+		reply, ok, err := m.Handle(ctx, key, s.text)
+		if err != nil || !ok || reply != s.reply {
+			t.Fatalf("Handle(%q) = %q, %v, %v, want %q", s.text, reply, ok, err, s.reply)
+		}
+	}
+	if _, ok, _ := m.Handle(ctx, key, "hello"); ok {
+		t.Error("conversation still in flight")
+	}
+}
+
+func TestHandleStaleState(t *testing.T) {
+	key := Key{Team: "T1", Channel: "C1", User: "U1"}
+	expires := time.Now().Add(time.Hour)
+	tests := []struct {
+		name string
+		st   *State
+	}{
+		{"step past the flow", &State{Key: key, Flow: "pizza", Step: 5, Answers: map[string]string{}, Expires: expires}},
+		{"negative step", &State{Key: key, Flow: "pizza", Step: -1, Answers: map[string]string{}, Expires: expires}},
+		{"unknown flow", &State{Key: key, Flow: "burger", Answers: map[string]string{}, Expires: expires}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, tt.st)
+			reply, ok, err := m.Handle(context.Background(), key, "large")
+			if err != nil || !ok || reply != "Okay, never mind." {
+				t.Fatalf("Handle = %q, %v, %v", reply, ok, err)
+			}
+			if _, ok, _ := m.Handle(context.Background(), key, "large"); ok {
+				t.Error("stale conversation kept")
+			}
+		})
+	}
+}
+
+func TestHandleConcurrent(t *testing.T) {
+	// The file store reads the states while they are answered.
+	m, err := NewManager(NewFileStore(filepath.Join(t.TempDir(), "dialogs.json")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Register(pizzaFlow)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		key := Key{Team: "T1", Channel: "C1", User: fmt.Sprint("U", i%2)}
+		if _, err := m.Start(ctx, key, "pizza", nil); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, text := range []string{"small", "ham", "large"} {
+				if _, _, err := m.Handle(ctx, key, text); err != nil {
+					t.Error(err)
+				}
+				m.Flush()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,59 @@
+package dialog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore saves conversations in a JSON file.
+type FileStore struct {
+	mu       sync.Mutex
+	filename string
+}
+
+// NewFileStore creates a store writing to filename.
+func NewFileStore(filename string) *FileStore {
+	return &FileStore{filename: filename}
+}
+
+// Load reads the conversations, none if the file doesn't exist.
+func (s *FileStore) Load() ([]*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := ioutil.ReadFile(s.filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []*State
+	err = json.Unmarshal(b, &states)
+	return states, err
+}
+
+// Save replaces the conversations of the file.
+func (s *FileStore) Save(states []*State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.filename), ".dialogs")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename)
+}
@@ -0,0 +1,135 @@
+// Package events receives the Events API callbacks of a Slack app.
+//
+// Slack expects an answer within three seconds, so handlers run after
+// the callback is acknowledged.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/signature"
+)
+
+// Envelope wraps every callback.
+type Envelope struct {
+	Token     string          `json:"token"`
+	TeamID    string          `json:"team_id"`
+	APIAppID  string          `json:"api_app_id"`
+	Type      string          `json:"type"`
+	Challenge string          `json:"challenge"`
+	EventID   string          `json:"event_id"`
+	EventTime int64           `json:"event_time"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// Event holds the common fields of events. Handlers needing more
+// decode Envelope.Event themselves.
+type Event struct {
+	Type     string `json:"type"`
+	User     string `json:"user"`
+	Channel  string `json:"channel"`
+	Tab      string `json:"tab"`
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	BotID    string `json:"bot_id"`
+	EventTS  string `json:"event_ts"`
+}
+
+// HandlerFunc handles an event.
+type HandlerFunc func(ctx context.Context, env *Envelope, ev *Event) error
+
+// Router dispatches events by type.
+type Router struct {
+	// SigningSecret is the signing secret of the Slack app.
+	SigningSecret string
+	// Timeout bounds the time handlers may take.
+	Timeout time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+// NewRouter creates a router checking requests against secret.
+func NewRouter(secret string) *Router {
+	return &Router{
+		SigningSecret: secret,
+		Timeout:       30 * time.Second,
+		handlers:      make(map[string]HandlerFunc),
+	}
+}
+
+// HandleFunc registers the handler of the events of type typ.
+func (r *Router) HandleFunc(typ string, f HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[typ] = f
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := signature.Verify(req, r.SigningSecret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch env.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(env.Challenge))
+		return
+	case "event_callback":
+	default:
+		slog.Warn("unexpected callback type", "team", env.TeamID, "type", env.Type)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var ev Event
+	if err := json.Unmarshal(env.Event, &ev); err != nil {
+		http.Error(w, "invalid event: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	metrics.Events.Inc(env.TeamID, ev.Type)
+	r.mu.RLock()
+	h, ok := r.handlers[ev.Type]
+	r.mu.RUnlock()
+	if ok {
+		go func() {
+			log := slog.With(logging.Event(env.TeamID, ev.Channel, ev.User, ev.Type)...)
+			// Nothing above recovers the panics of the handler.
+			defer func() {
+				if v := recover(); v != nil {
+					buf := make([]byte, 4096)
+					buf = buf[:runtime.Stack(buf, false)]
+					log.Error("fail to handle event", "id", env.EventID, "err", fmt.Sprintf("panic: %v", v), "stack", string(buf))
+				}
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+			defer cancel()
+			if err := h(ctx, &env, &ev); err != nil {
+				log.Error("fail to handle event", "id", env.EventID, "err", err)
+			}
+		}()
+	}
+	w.WriteHeader(http.StatusOK)
+}
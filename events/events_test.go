@@ -0,0 +1,89 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/signature"
+)
+
+// logLines sends what is logged to a channel.
+type logLines chan string
+
+func (l logLines) Write(b []byte) (int, error) {
+	l <- string(b)
+	return len(b), nil
+}
+
+// post sends body to r, signed with secret.
+func post(r *Router, secret, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/slack/events", bytes.NewReader([]byte(body)))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", signature.Sign(secret, ts, []byte(body)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter("secret")
+	w := post(r, "secret", `{"type":"url_verification","challenge":"abc"}`)
+	if w.Code != http.StatusOK || w.Body.String() != "abc" {
+		t.Errorf("challenge = %d %q", w.Code, w.Body.String())
+	}
+	if w := post(r, "forged", `{"type":"url_verification","challenge":"abc"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("forged: status = %d", w.Code)
+	}
+
+	got := make(chan *Event, 1)
+	r.HandleFunc("app_home_opened", func(ctx context.Context, env *Envelope, ev *Event) error {
+		got <- ev
+		return nil
+	})
+	w = post(r, "secret", `{"type":"event_callback","team_id":"T1","event":{"type":"app_home_opened","user":"U1","tab":"home"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	select {
+	case ev := <-got:
+		if ev.User != "U1" || ev.Tab != "home" {
+			t.Errorf("event = %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not handled")
+	}
+}
+
+func TestRouterRecovers(t *testing.T) {
+	logs := make(logLines, 10)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+
+	r := NewRouter("secret")
+	r.HandleFunc("message", func(ctx context.Context, env *Envelope, ev *Event) error {
+		var m map[string]int
+		m[ev.User]++
+		return nil
+	})
+	if w := post(r, "secret", `{"type":"event_callback","event":{"type":"message","user":"U1"}}`); w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	// The panic of the handler is logged instead of stopping the bot.
+	select {
+	case line := <-logs:
+		if !strings.Contains(line, "fail to handle event") || !strings.Contains(line, "assignment to entry in nil map") {
+			t.Errorf("logged %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic not logged")
+	}
+}
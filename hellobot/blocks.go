@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/aitva/slackbot/blockkit"
+	"gopkg.in/yaml.v2"
+)
+
+// readBlocks loads Block Kit blocks from a JSON or YAML file. The
+// file holds either a list of blocks or an object with a "blocks"
+// field, as exported by Slack's Block Kit Builder.
+func readBlocks(filename string) (blockkit.Blocks, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == ".yaml" || ext == ".yml" {
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		b, err = json.Marshal(jsonValue(v))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blocks blockkit.Blocks
+	trimmed := strings.TrimSpace(string(b))
+	if strings.HasPrefix(trimmed, "{") {
+		var doc struct {
+			Blocks blockkit.Blocks `json:"blocks"`
+		}
+		err = json.Unmarshal(b, &doc)
+		blocks = doc.Blocks
+	} else {
+		err = json.Unmarshal(b, &blocks)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("no blocks in %s", filename)
+	}
+	return blocks, blocks.Validate()
+}
+
+// jsonValue converts the maps decoded by yaml, which have interface
+// keys, into maps encoding/json can marshal.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+	}
+	return v
+}
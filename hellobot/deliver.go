@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/slackenv"
+)
+
+// errSpooled is returned by Send when a message could not be
+// delivered but was saved in the spool.
+var errSpooled = errors.New("Slack is unreachable, the message is spooled")
+
+// deliveryError is a failed attempt to post a message. Temporary
+// errors are worth retrying; RetryAfter is the delay asked by Slack.
+type deliveryError struct {
+	Err        error
+	Temporary  bool
+	RetryAfter time.Duration
+}
+
+func (e *deliveryError) Error() string {
+	return e.Err.Error()
+}
+
+// deliverer posts messages to an incoming webhook. It retries with
+// exponential backoff on network errors, 5xx and 429 responses, and
+// spools the messages it cannot deliver when a spool is set.
+type deliverer struct {
+	URL     string
+	Retries int
+	// MaxWait bounds the delay between two attempts, including the
+	// delay asked by Slack in Retry-After.
+	MaxWait time.Duration
+	// Spool is a directory where undelivered messages are saved,
+	// delivery is not retried later if empty.
+	Spool string
+	// Slack tells where the webhooks are.
+	Slack *slackenv.Source
+
+	client *http.Client
+	sleep  func(time.Duration)
+}
+
+// deliveryFlags registers the flags configuring the delivery.
+func deliveryFlags(fs *flag.FlagSet) *deliverer {
+	d := &deliverer{
+		MaxWait: 30 * time.Second,
+		client:  metrics.NewClient(10 * time.Second),
+		sleep:   time.Sleep,
+		Slack:   slackenv.SourceFromEnv(),
+	}
+	d.Slack.AddFlags(fs)
+	usage := fs.Usage
+	fs.Usage = func() {
+		usage()
+		fmt.Fprint(fs.Output(), envHelp)
+	}
+	fs.IntVar(&d.Retries, "retries", 3, "number of retries when Slack is unavailable")
+	fs.StringVar(&d.Spool, "spool", os.Getenv("HELLOBOT_SPOOL"), "directory where undelivered messages are saved for hellobot flush")
+	return d
+}
+
+// post makes one attempt at sending a JSON payload to url. Slack
+// answers errors with a plain text body like "invalid_payload".
+func (d *deliverer) post(url string, payload []byte) error {
+	resp, err := d.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return &deliveryError{
+			Err:       fmt.Errorf("fail to communicate with Slack: %v", err),
+			Temporary: true,
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	return &deliveryError{
+		Err:        fmt.Errorf("Slack refused the message: %s (%d)", strings.TrimSpace(string(body)), resp.StatusCode),
+		Temporary:  resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, given in seconds or
+// as an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// postRetry sends payload to url, retrying temporary errors.
+func (d *deliverer) postRetry(url string, payload []byte) error {
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := d.post(url, payload)
+		derr, ok := err.(*deliveryError)
+		if err == nil || !ok || !derr.Temporary || attempt >= d.Retries {
+			return err
+		}
+		wait := backoff + time.Duration(rand.Int63n(int64(backoff)/2))
+		if derr.RetryAfter > 0 {
+			wait = derr.RetryAfter
+		}
+		if wait > d.MaxWait {
+			return err
+		}
+		slog.Warn("fail to deliver message, retrying", "err", err, "wait", wait.Round(time.Millisecond))
+		d.sleep(wait)
+		backoff *= 2
+	}
+}
+
+// Send delivers msg. With a spool, messages are delivered in order:
+// the spool is flushed first and msg joins it if Slack is still
+// unavailable.
+func (d *deliverer) Send(msg *message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("fail to encode message: %v", err)
+	}
+	if d.Spool == "" {
+		return d.postRetry(d.URL, payload)
+	}
+
+	// msg goes after the messages the spool still holds, whether
+	// Slack is unavailable or the spool can't be read.
+	_, err = d.Flush()
+	if err == nil {
+		err = d.postRetry(d.URL, payload)
+		if derr, ok := err.(*deliveryError); !ok || !derr.Temporary {
+			return err
+		}
+	} else if _, ok := err.(*deliveryError); !ok {
+		slog.Error("fail to flush the spool", "spool", d.Spool, "err", err)
+	}
+	if serr := d.spool(d.URL, payload); serr != nil {
+		return fmt.Errorf("%v, and fail to spool the message: %v", err, serr)
+	}
+	return errSpooled
+}
+
+// spooledMessage is a message saved in the spool with the webhook it
+// goes to, the spool is shared by every webhook.
+type spooledMessage struct {
+	URL     string          `json:"url"`
+	Message json.RawMessage `json:"message"`
+}
+
+// spool saves payload to url in the spool directory. File names sort
+// in the order messages were spooled.
+func (d *deliverer) spool(url string, payload []byte) error {
+	err := os.MkdirAll(d.Spool, 0700)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(&spooledMessage{URL: url, Message: payload})
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%020d.json", time.Now().UnixNano())
+	tmp := filepath.Join(d.Spool, "."+name)
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.Spool, name))
+}
+
+// Flush delivers the spooled messages in order, each to the webhook
+// it was sent to. It stops at the first temporary error to keep the
+// order; messages Slack refuses are renamed with a .failed extension.
+// It returns the number of messages delivered.
+func (d *deliverer) Flush() (int, error) {
+	files, err := filepath.Glob(filepath.Join(d.Spool, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(files)
+	sent := 0
+	for _, filename := range files {
+		url, payload, err := d.readSpooled(filename)
+		if err != nil {
+			return sent, err
+		}
+		err = d.postRetry(url, payload)
+		if derr, ok := err.(*deliveryError); ok && derr.Temporary {
+			return sent, err
+		}
+		if err != nil {
+			slog.Error("fail to deliver spooled message", "file", filename, "err", err)
+			err = os.Rename(filename, filename+".failed")
+		} else {
+			sent++
+			err = os.Remove(filename)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// readSpooled reads a spooled message and its webhook. Messages
+// spooled before the webhook was saved along go to d.URL.
+func (d *deliverer) readSpooled(filename string) (url string, payload []byte, err error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	var m spooledMessage
+	if err := json.Unmarshal(b, &m); err != nil || m.URL == "" {
+		return d.URL, b, nil
+	}
+	return m.URL, m.Message, nil
+}
+
+// flush delivers the messages spooled while Slack was unreachable.
+func flush(args []string) {
+	fs := flag.NewFlagSet("hellobot flush", flag.ExitOnError)
+	d := deliveryFlags(fs)
+	fs.Parse(args)
+	logging.Fatal(d.Spool == "", "Flag -spool or variable HELLOBOT_SPOOL must be defined.")
+	d.URL = webhookURL(d.Slack)
+
+	n, err := d.Flush()
+	fmt.Println(n, "spooled messages sent.")
+	logging.Fatal(err != nil, err)
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhook is a fake incoming webhook answering with the statuses of
+// replies in turn, then 200.
+type webhook struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	replies  []int
+	received []string
+}
+
+func newWebhook(t *testing.T, replies ...int) *webhook {
+	wh := &webhook{replies: replies}
+	wh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			t.Errorf("invalid payload: %v", err)
+		}
+		wh.mu.Lock()
+		status := http.StatusOK
+		if len(wh.replies) > 0 {
+			status, wh.replies = wh.replies[0], wh.replies[1:]
+		}
+		if status == http.StatusOK {
+			wh.received = append(wh.received, msg.Text)
+		}
+		wh.mu.Unlock()
+		switch status {
+		case http.StatusOK:
+			w.Write([]byte("ok"))
+		case http.StatusTooManyRequests:
+			w.Header().Set("Retry-After", "2")
+			http.Error(w, "rate_limited", status)
+		case http.StatusBadRequest:
+			http.Error(w, "invalid_payload", status)
+		default:
+			http.Error(w, "service_unavailable", status)
+		}
+	}))
+	t.Cleanup(wh.Close)
+	return wh
+}
+
+func (wh *webhook) Received() []string {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	return append([]string(nil), wh.received...)
+}
+
+// newDeliverer returns a deliverer to wh recording its waits instead
+// of sleeping.
+func newDeliverer(wh *webhook, waits *[]time.Duration) *deliverer {
+	return &deliverer{
+		URL:     wh.URL,
+		Retries: 3,
+		MaxWait: 30 * time.Second,
+		client:  wh.Client(),
+		sleep:   func(d time.Duration) { *waits = append(*waits, d) },
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	wh := newWebhook(t, 500, 502, 503)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	if err := d.Send(&message{Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := wh.Received(); len(got) != 1 || got[0] != "hi" {
+		t.Errorf("received %q", got)
+	}
+	if len(waits) != 3 {
+		t.Fatalf("waited %v, want 3 waits", waits)
+	}
+	// Backoff doubles from 500ms, with up to 50% of jitter.
+	for i, w := range waits {
+		min := 500 * time.Millisecond << uint(i)
+		if w < min || w >= min*3/2 {
+			t.Errorf("wait %d = %v, want in [%v, %v)", i, w, min, min*3/2)
+		}
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	wh := newWebhook(t, 429)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	if err := d.Send(&message{Text: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(waits) != 1 || waits[0] != 2*time.Second {
+		t.Errorf("waited %v, want [2s]", waits)
+	}
+
+	// Slack asking to wait longer than MaxWait ends the retries.
+	wh = newWebhook(t, 429)
+	waits = nil
+	d = newDeliverer(wh, &waits)
+	d.MaxWait = time.Second
+	err := d.Send(&message{Text: "hi"})
+	if derr, ok := err.(*deliveryError); !ok || !derr.Temporary {
+		t.Errorf("err = %v, want a temporary error", err)
+	}
+	if len(waits) != 0 {
+		t.Errorf("waited %v", waits)
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	wh := newWebhook(t, 400)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	err := d.Send(&message{Text: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "invalid_payload") {
+		t.Errorf("err = %v, want invalid_payload", err)
+	}
+	if len(waits) != 0 {
+		t.Errorf("retried a refused message: %v", waits)
+	}
+
+	wh = newWebhook(t, 500, 500, 500, 500)
+	waits = nil
+	d = newDeliverer(wh, &waits)
+	err = d.Send(&message{Text: "hi"})
+	if derr, ok := err.(*deliveryError); !ok || !derr.Temporary {
+		t.Errorf("err = %v, want a temporary error", err)
+	}
+	if len(waits) != d.Retries {
+		t.Errorf("waited %v, want %d waits", waits, d.Retries)
+	}
+}
+
+func spooled(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSpoolAndFlush(t *testing.T) {
+	// Slack is down for the first two messages, with one retry each.
+	wh := newWebhook(t, 503, 503, 503, 503)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	d.Retries = 1
+	d.Spool = filepath.Join(t.TempDir(), "spool")
+
+	for _, text := range []string{"first", "second"} {
+		if err := d.Send(&message{Text: text}); err != errSpooled {
+			t.Fatalf("Send(%q) = %v, want errSpooled", text, err)
+		}
+	}
+	if n := len(spooled(t, d.Spool)); n != 2 {
+		t.Fatalf("%d messages spooled, want 2", n)
+	}
+
+	// Back up, the spool is flushed before the new message.
+	if err := d.Send(&message{Text: "third"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "second", "third"}
+	if got := wh.Received(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("received %q, want %q", got, want)
+	}
+	if n := len(spooled(t, d.Spool)); n != 0 {
+		t.Errorf("%d messages left in the spool", n)
+	}
+}
+
+func TestFlushRefused(t *testing.T) {
+	wh := newWebhook(t, 400)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	d.Spool = t.TempDir()
+	for _, text := range []string{"refused", "accepted"} {
+		b, _ := json.Marshal(&message{Text: text})
+		if err := d.spool(d.URL, b); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	n, err := d.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("%d messages sent, want 1", n)
+	}
+	failed, _ := filepath.Glob(filepath.Join(d.Spool, "*.json.failed"))
+	if len(failed) != 1 {
+		t.Errorf("failed messages = %q", failed)
+	}
+	if got := wh.Received(); len(got) != 1 || got[0] != "accepted" {
+		t.Errorf("received %q", got)
+	}
+}
+
+func TestSpoolWhenFlushFails(t *testing.T) {
+	wh := newWebhook(t)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	d.Spool = t.TempDir()
+	// A directory can't be read as a spooled message.
+	if err := os.Mkdir(filepath.Join(d.Spool, "00000000000000000001.json"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Send(&message{Text: "hi"}); err != errSpooled {
+		t.Fatalf("Send = %v, want errSpooled", err)
+	}
+	if got := wh.Received(); len(got) != 0 {
+		t.Errorf("sent ahead of the spool: %q", got)
+	}
+	files := spooled(t, d.Spool)
+	if len(files) != 2 {
+		t.Fatalf("spool = %q, want the message spooled", files)
+	}
+	b, err := ioutil.ReadFile(files[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"hi"`) {
+		t.Errorf("spooled %s", b)
+	}
+}
+
+func TestFlushToSpooledWebhook(t *testing.T) {
+	spool := t.TempDir()
+	var waits []time.Duration
+	down := newWebhook(t, 503)
+	d := newDeliverer(down, &waits)
+	d.Retries = 0
+	d.Spool = spool
+	if err := d.Send(&message{Text: "to down"}); err != errSpooled {
+		t.Fatalf("Send = %v, want errSpooled", err)
+	}
+
+	// Flushed by a deliverer to another webhook, the message still
+	// goes to the webhook it was sent to.
+	other := newWebhook(t)
+	d = newDeliverer(other, &waits)
+	d.Spool = spool
+	if err := d.Send(&message{Text: "to other"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := down.Received(); len(got) != 1 || got[0] != "to down" {
+		t.Errorf("down received %q", got)
+	}
+	if got := other.Received(); len(got) != 1 || got[0] != "to other" {
+		t.Errorf("other received %q", got)
+	}
+	if files := spooled(t, spool); len(files) != 0 {
+		t.Errorf("spool = %q, want empty", files)
+	}
+}
+
+func TestFlushLegacySpool(t *testing.T) {
+	wh := newWebhook(t)
+	var waits []time.Duration
+	d := newDeliverer(wh, &waits)
+	d.Spool = t.TempDir()
+	// Spooled before the webhook was saved with the message.
+	if err := os.WriteFile(filepath.Join(d.Spool, "00000000000000000001.json"), []byte(`{"text":"old"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := d.Flush(); n != 1 || err != nil {
+		t.Fatalf("Flush = %d, %v", n, err)
+	}
+	if got := wh.Received(); len(got) != 1 || got[0] != "old" {
+		t.Errorf("received %q", got)
+	}
+}
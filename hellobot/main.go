@@ -3,38 +3,206 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"net/http"
+	"io/ioutil"
+	"log/slog"
 	"os"
+	"strings"
+	"text/template"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/slackenv"
 )
 
 type message struct {
-	Text string `json:"text"`
+	Text        string          `json:"text"`
+	Blocks      blockkit.Blocks `json:"blocks,omitempty"`
+	Attachments []*attachment   `json:"attachments,omitempty"`
+	Username    string          `json:"username,omitempty"`
+	IconEmoji   string          `json:"icon_emoji,omitempty"`
+	IconURL     string          `json:"icon_url,omitempty"`
+	Channel     string          `json:"channel,omitempty"`
+	Mrkdwn      *bool           `json:"mrkdwn,omitempty"`
 }
 
-func main() {
-	token := os.Getenv("TOKEN")
-	if token == "" {
-		fmt.Println("Variable TOKEN must be defined.")
-		os.Exit(1)
+// varsFlag collects template variables given as key=value.
+type varsFlag map[string]interface{}
+
+func (v varsFlag) String() string {
+	return fmt.Sprint(map[string]interface{}(v))
+}
+
+func (v varsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("expected key=value, got %q", s)
 	}
-	url := fmt.Sprintf("https://hooks.slack.com/services/%s", token)
+	v[s[:i]] = s[i+1:]
+	return nil
+}
 
-	fmt.Println("I'm a Slack bot and I'm going to say hello.")
+// readText returns the text of the message, taken in order from the
+// -text flag, the -file flag ("-" for stdin) or stdin when it is not
+// a terminal.
+func readText(text, file string) (string, error) {
+	if text != "" {
+		return text, nil
+	}
+	if file == "" {
+		fi, err := os.Stdin.Stat()
+		if err != nil || fi.Mode()&os.ModeCharDevice != 0 {
+			return "", fmt.Errorf("no text given, use -text, -file or stdin")
+		}
+		file = "-"
+	}
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	return strings.TrimRight(string(b), "\n"), err
+}
 
-	var buf bytes.Buffer
-	e := json.NewEncoder(&buf)
-	err := e.Encode(&message{Text: "Hello Bro!"})
+// renderText executes text as a Go template. Templates see the
+// environment as .Env and variables as .Vars.
+func renderText(text string, vars map[string]interface{}) (string, error) {
+	tmpl, err := template.New("text").Option("missingkey=error").Parse(text)
 	if err != nil {
-		fmt.Println("I've fail to encode message:", err)
-		os.Exit(1)
+		return "", err
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		i := strings.Index(kv, "=")
+		env[kv[:i]] = kv[i+1:]
 	}
+	data := map[string]interface{}{"Env": env, "Vars": vars}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	return buf.String(), err
+}
 
-	resp, err := http.Post(url, "application/json", &buf)
-	if err != nil {
-		fmt.Println("I've fail to communicate with Slack:", err)
-		os.Exit(1)
+// envHelp documents the variables naming the webhook.
+const envHelp = `
+Environment:
+  TOKEN
+    	path of the incoming webhook, after /services/ in its URL
+  WEBHOOK_URL
+    	deprecated: whole URL of the webhook, set TOKEN and -slack-url,
+    	SLACK_BASE_URL or SLACK_WEBHOOK_URL instead
+`
+
+// overrideFlags registers the flags overriding the defaults of the
+// incoming webhook.
+func overrideFlags(fs *flag.FlagSet, msg *message) {
+	fs.StringVar(&msg.Username, "username", "", "override the name of the bot")
+	fs.StringVar(&msg.IconEmoji, "icon-emoji", "", "override the icon with an emoji, like :ghost:")
+	fs.StringVar(&msg.IconURL, "icon-url", "", "override the icon with an image")
+	fs.StringVar(&msg.Channel, "channel", "", "override the channel of the webhook")
+}
+
+// webhookURL returns the URL of the incoming webhook, TOKEN being its
+// path under the webhook URL of the Slack config. The deprecated
+// WEBHOOK_URL, the whole URL, still wins.
+func webhookURL(src *slackenv.Source) string {
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		slog.Warn("WEBHOOK_URL is deprecated, set SLACK_BASE_URL or SLACK_WEBHOOK_URL and TOKEN instead")
+		err := slackenv.CheckURL(url)
+		logging.Fatal(err != nil, "Variable WEBHOOK_URL is invalid:", err)
+		return url
 	}
+	token := os.Getenv("TOKEN")
+	logging.Fatal(token == "", "Variable TOKEN must be defined.")
+	conf, err := src.Load()
+	logging.Fatal(err != nil, "fail to load Slack config:", err)
+	return conf.Webhook(token)
+}
+
+// say posts a message written by the user.
+func say(args []string) {
+	fs := flag.NewFlagSet("hellobot", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: hellobot [flags]\n       hellobot notify [flags]\n       hellobot flush [flags]")
+		fs.PrintDefaults()
+	}
+	vars := make(varsFlag)
+	text := fs.String("text", "", "text of the message")
+	file := fs.String("file", "", "read the text from a file, - for stdin")
+	blocks := fs.String("blocks", "", "JSON or YAML file of Block Kit blocks")
+	msg := &message{}
+	overrideFlags(fs, msg)
+	d := deliveryFlags(fs)
+	mrkdwn := fs.Bool("mrkdwn", true, "format the text with Slack markup")
+	tmpl := fs.Bool("template", false, "render the text as a Go template")
+	varsFile := fs.String("vars", "", "JSON file of template variables")
+	fs.Var(vars, "var", "template variable as key=value, may be repeated")
+	fs.Parse(args)
+
+	d.URL = webhookURL(d.Slack)
+
+	var err error
+	if *blocks != "" {
+		msg.Blocks, err = readBlocks(*blocks)
+		logging.Fatal(err != nil, "I've fail to read blocks:", err)
+	}
+	// With blocks, the text is an optional fallback for notifications.
+	if *blocks == "" || *text != "" || *file != "" {
+		msg.Text, err = readText(*text, *file)
+		logging.Fatal(err != nil, "I've fail to read the message:", err)
+	}
+	if *tmpl {
+		if *varsFile != "" {
+			b, err := ioutil.ReadFile(*varsFile)
+			logging.Fatal(err != nil, "I've fail to read variables:", err)
+			fileVars := make(map[string]interface{})
+			err = json.Unmarshal(b, &fileVars)
+			logging.Fatal(err != nil, "I've fail to parse variables:", err)
+			// Variables from the command line win.
+			for k, v := range fileVars {
+				if _, ok := vars[k]; !ok {
+					vars[k] = v
+				}
+			}
+		}
+		msg.Text, err = renderText(msg.Text, vars)
+		logging.Fatal(err != nil, "I've fail to render the message:", err)
+	}
+	if !*mrkdwn {
+		msg.Mrkdwn = mrkdwn
+	}
+
+	sent(d.Send(msg))
+}
 
-	fmt.Println("resp.StatusCode:", resp.StatusCode)
+// exitSpooled is the exit status when the message is spooled, so
+// scripts can tell it from a message sent or refused.
+const exitSpooled = 3
+
+// sent reports the outcome of a delivery.
+func sent(err error) {
+	if err == errSpooled {
+		fmt.Fprintln(os.Stderr, "Slack is unreachable, the message will be sent by hellobot flush.")
+		os.Exit(exitSpooled)
+	}
+	logging.Fatal(err != nil, err)
+	fmt.Println("Message sent.")
+}
+
+func main() {
+	err := logging.Setup("hellobot")
+	logging.Fatal(err != nil, "fail to set up logs:", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "notify":
+			notify(os.Args[2:])
+			return
+		case "flush":
+			flush(os.Args[2:])
+			return
+		}
+	}
+	say(os.Args[1:])
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/slacktest"
+)
+
+func TestMain(m *testing.M) {
+	slacktest.RunMain(main)
+	os.Exit(m.Run())
+}
+
+// runBot runs hellobot with args against the webhook of s posting to
+// #general, and returns its exit status.
+func runBot(t *testing.T, s *slacktest.Server, env []string, args ...string) int {
+	t.Helper()
+	path := strings.TrimPrefix(s.AddWebhook(slacktest.General), s.URL+"/services/")
+	env = append([]string{"TOKEN=" + path, "SLACK_BASE_URL=" + s.URL, "WEBHOOK_URL="}, env...)
+	code, err := slacktest.StartMain(t, env, args...).Wait(10 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestEndToEnd(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+
+	if code := runBot(t, s, nil, "-text", "Hello {{.Vars.name}}", "-template", "-var", "name=world"); code != 0 {
+		t.Fatalf("exit status %d", code)
+	}
+	msg, err := s.WaitMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "Hello world" || msg.Channel != slacktest.General || msg.Via != slacktest.ViaWebhook {
+		t.Errorf("posted %+v", msg)
+	}
+
+	code := runBot(t, s, nil, "notify", "-ci", "none", "-status", "failure", "-title", "build 42", "-field", "branch=main")
+	if code != 0 {
+		t.Fatalf("notify: exit status %d", code)
+	}
+	msg, err = s.WaitMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(msg.Attachments), `"danger"`) || !strings.Contains(string(msg.Attachments), "branch") {
+		t.Errorf("notify posted %s", msg.Attachments)
+	}
+
+	code = runBot(t, s, nil, "notify", "-ci", "jenkins", "-status", "failure", "-title", "build 42")
+	if code != 1 {
+		t.Fatalf("notify -ci jenkins: exit status %d, want 1", code)
+	}
+}
+
+func TestEndToEndSpool(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	spool := []string{"HELLOBOT_SPOOL=" + t.TempDir()}
+
+	// Slack is down, the message waits in the spool.
+	s.Fail("webhook", "service_unavailable", 1)
+	if code := runBot(t, s, spool, "-text", "first", "-retries", "0"); code != exitSpooled {
+		t.Fatalf("exit status %d, want %d", code, exitSpooled)
+	}
+	if n := len(s.Posted()); n != 0 {
+		t.Fatalf("%d messages posted", n)
+	}
+
+	// Back up, flush sends it.
+	if code := runBot(t, s, spool, "flush"); code != 0 {
+		t.Fatalf("flush: exit status %d", code)
+	}
+	msg, err := s.WaitMessage(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "first" {
+		t.Errorf("flushed %+v", msg)
+	}
+}
+
+func TestEndToEndDeprecatedWebhookURL(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	p := slacktest.StartMain(t, []string{"WEBHOOK_URL=" + s.AddWebhook(slacktest.General)}, "-text", "hi")
+	if code, err := p.Wait(10 * time.Second); err != nil || code != 0 {
+		t.Fatalf("exit status %d, %v", code, err)
+	}
+	if !strings.Contains(p.Output(), "WEBHOOK_URL is deprecated") {
+		t.Errorf("no deprecation warning in %q", p.Output())
+	}
+	if msg, err := s.WaitMessage(time.Second); err != nil || msg.Text != "hi" {
+		t.Errorf("posted %+v, %v", msg, err)
+	}
+}
+
+func TestEndToEndInvalidWebhookURL(t *testing.T) {
+	for _, url := range []string{
+		"http://hooks.example.com/services/T1/B1/x",
+		"hooks.slack.com/services/T1/B1/x",
+		"ftp://localhost/services/T1/B1/x",
+	} {
+		p := slacktest.StartMain(t, []string{"WEBHOOK_URL=" + url}, "-text", "hi")
+		if code, err := p.Wait(10 * time.Second); err != nil || code != 1 {
+			t.Errorf("WEBHOOK_URL=%s: exit status %d, %v", url, code, err)
+		}
+		if !strings.Contains(p.Output(), "WEBHOOK_URL is invalid") {
+			t.Errorf("WEBHOOK_URL=%s: output %q", url, p.Output())
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{{"-h"}, {"notify", "-h"}, {"flush", "-h"}} {
+		p := slacktest.StartMain(t, nil, args...)
+		p.Wait(10 * time.Second)
+		if !strings.Contains(p.Output(), "WEBHOOK_URL\n    \tdeprecated") {
+			t.Errorf("%q: no deprecation of WEBHOOK_URL in:\n%s", args, p.Output())
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/logging"
+)
+
+// now is swapped by the tests.
+var now = time.Now
+
+// attachment is a legacy message attachment, still the only way to
+// show a colored bar next to a message.
+type attachment struct {
+	Fallback  string            `json:"fallback,omitempty"`
+	Color     string            `json:"color,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	TitleLink string            `json:"title_link,omitempty"`
+	Text      string            `json:"text,omitempty"`
+	Fields    []attachmentField `json:"fields,omitempty"`
+	Footer    string            `json:"footer,omitempty"`
+	Ts        int64             `json:"ts,omitempty"`
+	Blocks    blockkit.Blocks   `json:"blocks,omitempty"`
+}
+
+type attachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// status is the outcome of a build.
+type status struct {
+	Color string
+	Emoji string
+	Label string
+}
+
+var statuses = map[string]status{
+	"success": {"good", ":white_check_mark:", "succeeded"},
+	"failure": {"danger", ":x:", "failed"},
+	"warning": {"warning", ":warning:", "is unstable"},
+}
+
+// fieldsFlag collects attachment fields given as key=value.
+type fieldsFlag []attachmentField
+
+func (f *fieldsFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *fieldsFlag) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	// Slack drops fields without value, say so rather than losing it.
+	if i == len(s)-1 {
+		return fmt.Errorf("empty value for field %q", s[:i])
+	}
+	f.add(s[:i], s[i+1:])
+	return nil
+}
+
+// add appends a field, replacing any field with the same title.
+func (f *fieldsFlag) add(title, value string) {
+	if value == "" {
+		return
+	}
+	for i := range *f {
+		if (*f)[i].Title == title {
+			(*f)[i].Value = value
+			return
+		}
+	}
+	*f = append(*f, attachmentField{Title: title, Value: value, Short: len(value) < 40})
+}
+
+// notification describes a build to announce.
+type notification struct {
+	Status string
+	Title  string
+	Text   string
+	Link   string
+	Footer string
+	Fields fieldsFlag
+}
+
+// fromCI fills the notification from the environment of GitHub
+// Actions or GitLab CI. Only empty values are filled.
+func (n *notification) fromCI(ci string) error {
+	env := os.Getenv
+	if ci == "auto" {
+		switch {
+		case env("GITHUB_ACTIONS") == "true":
+			ci = "github"
+		case env("GITLAB_CI") == "true":
+			ci = "gitlab"
+		default:
+			return nil
+		}
+	}
+	var preset notification
+	switch ci {
+	case "github":
+		preset.Title = fmt.Sprintf("%s #%s", env("GITHUB_WORKFLOW"), env("GITHUB_RUN_NUMBER"))
+		if env("GITHUB_RUN_ID") != "" {
+			preset.Link = fmt.Sprintf("%s/%s/actions/runs/%s", env("GITHUB_SERVER_URL"), env("GITHUB_REPOSITORY"), env("GITHUB_RUN_ID"))
+		}
+		preset.Footer = "GitHub Actions"
+		preset.Fields.add("Repository", env("GITHUB_REPOSITORY"))
+		preset.Fields.add("Branch", env("GITHUB_REF_NAME"))
+		preset.Fields.add("Commit", shortSHA(env("GITHUB_SHA")))
+		preset.Fields.add("Author", env("GITHUB_ACTOR"))
+		preset.Fields.add("Event", env("GITHUB_EVENT_NAME"))
+	case "gitlab":
+		preset.Title = fmt.Sprintf("%s pipeline #%s", env("CI_PROJECT_PATH"), env("CI_PIPELINE_ID"))
+		preset.Link = env("CI_PIPELINE_URL")
+		preset.Footer = "GitLab CI"
+		switch env("CI_JOB_STATUS") {
+		case "success":
+			preset.Status = "success"
+		case "failed", "canceled":
+			preset.Status = "failure"
+		}
+		preset.Fields.add("Project", env("CI_PROJECT_PATH"))
+		preset.Fields.add("Branch", env("CI_COMMIT_REF_NAME"))
+		preset.Fields.add("Commit", env("CI_COMMIT_SHORT_SHA"))
+		preset.Fields.add("Author", env("GITLAB_USER_LOGIN"))
+		preset.Fields.add("Job", env("CI_JOB_NAME"))
+	case "none":
+		return nil
+	default:
+		return fmt.Errorf("unknown CI preset %q", ci)
+	}
+
+	if n.Status == "" {
+		n.Status = preset.Status
+	}
+	if n.Title == "" {
+		n.Title = preset.Title
+	}
+	if n.Link == "" {
+		n.Link = preset.Link
+	}
+	if n.Footer == "" {
+		n.Footer = preset.Footer
+	}
+	// Fields from the command line win.
+	for _, f := range n.Fields {
+		preset.Fields.add(f.Title, f.Value)
+	}
+	n.Fields = preset.Fields
+	return nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 8 {
+		return sha[:8]
+	}
+	return sha
+}
+
+// Attachment renders the notification as a legacy attachment.
+func (n *notification) Attachment(st status) *attachment {
+	return &attachment{
+		Fallback:  fmt.Sprintf("%s %s", n.Title, st.Label),
+		Color:     st.Color,
+		Title:     fmt.Sprintf("%s %s %s", st.Emoji, n.Title, st.Label),
+		TitleLink: n.Link,
+		Text:      n.Text,
+		Fields:    n.Fields,
+		Footer:    n.Footer,
+		Ts:        now().Unix(),
+	}
+}
+
+// BlocksAttachment renders the notification as Block Kit blocks,
+// wrapped in an attachment to keep the colored bar.
+func (n *notification) BlocksAttachment(st status) *attachment {
+	title := fmt.Sprintf("%s *%s* %s", st.Emoji, n.Title, st.Label)
+	if n.Link != "" {
+		title = fmt.Sprintf("%s *<%s|%s>* %s", st.Emoji, n.Link, n.Title, st.Label)
+	}
+	blocks := blockkit.Blocks{&blockkit.Section{Text: blockkit.Markdown(title)}}
+	if n.Text != "" {
+		blocks = append(blocks, &blockkit.Section{Text: blockkit.Markdown(n.Text)})
+	}
+	// A section holds at most ten fields.
+	for i := 0; i < len(n.Fields); i += blockkit.MaxFields {
+		section := &blockkit.Section{}
+		for j := i; j < len(n.Fields) && j < i+blockkit.MaxFields; j++ {
+			f := n.Fields[j]
+			section.Fields = append(section.Fields, blockkit.Markdown(fmt.Sprintf("*%s*\n%s", f.Title, f.Value)))
+		}
+		blocks = append(blocks, section)
+	}
+	footer := now().Format("Jan 2, 15:04 MST")
+	if n.Footer != "" {
+		footer = n.Footer + " | " + footer
+	}
+	blocks = append(blocks, &blockkit.Context{Elements: blockkit.Elements{blockkit.Plain(footer)}})
+	return &attachment{
+		Fallback: fmt.Sprintf("%s %s", n.Title, st.Label),
+		Color:    st.Color,
+		Blocks:   blocks,
+	}
+}
+
+// notify announces the outcome of a CI build.
+func notify(args []string) {
+	fs := flag.NewFlagSet("hellobot notify", flag.ExitOnError)
+	n := &notification{}
+	fs.StringVar(&n.Status, "status", "", "outcome of the build: success, failure or warning")
+	fs.StringVar(&n.Title, "title", "", "title of the notification")
+	fs.StringVar(&n.Text, "text", "", "text below the title")
+	fs.StringVar(&n.Link, "link", "", "URL the title links to")
+	fs.StringVar(&n.Footer, "footer", "", "footer of the notification")
+	fs.Var(&n.Fields, "field", "field as key=value, may be repeated")
+	ci := fs.String("ci", "auto", "read build details from the CI: auto, github, gitlab or none")
+	layout := fs.String("layout", "attachment", "layout of the notification: attachment or blocks")
+	msg := &message{}
+	overrideFlags(fs, msg)
+	d := deliveryFlags(fs)
+	fs.Parse(args)
+	d.URL = webhookURL(d.Slack)
+
+	err := n.fromCI(*ci)
+	logging.Fatal(err != nil, "Flag -ci must be auto, github, gitlab or none.")
+	st, ok := statuses[n.Status]
+	logging.Fatal(!ok, "Flag -status must be success, failure or warning.")
+	logging.Fatal(n.Title == "", "Flag -title must be defined.")
+
+	switch *layout {
+	case "attachment":
+		msg.Attachments = []*attachment{n.Attachment(st)}
+	case "blocks":
+		att := n.BlocksAttachment(st)
+		err = att.Blocks.Validate()
+		logging.Fatal(err != nil, "I've built invalid blocks:", err)
+		msg.Attachments = []*attachment{att}
+	default:
+		logging.Fatal(true, "Flag -layout must be attachment or blocks.")
+	}
+
+	sent(d.Send(msg))
+}
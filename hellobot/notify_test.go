@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+)
+
+func TestFieldsFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want fieldsFlag
+		err  bool
+	}{
+		{args: []string{"-field", "env=prod"}, want: fieldsFlag{{Title: "env", Value: "prod", Short: true}}},
+		{args: []string{"-field", "url=a=b"}, want: fieldsFlag{{Title: "url", Value: "a=b", Short: true}}},
+		{args: []string{"-field", "env=prod", "-field", "env=staging"}, want: fieldsFlag{{Title: "env", Value: "staging", Short: true}}},
+		{args: []string{"-field", "env="}, err: true},
+		{args: []string{"-field", "=prod"}, err: true},
+		{args: []string{"-field", "env"}, err: true},
+	}
+	for _, tt := range tests {
+		var got fieldsFlag
+		fs := flag.NewFlagSet("notify", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		fs.Var(&got, "field", "")
+		err := fs.Parse(tt.args)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: no error, fields %v", tt.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: fields = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+// ciEnv sets the variables of a GitHub Actions run or of a GitLab CI
+// job.
+func ciEnv(t *testing.T, ci string) {
+	env := map[string]string{
+		"GITHUB_ACTIONS":      "",
+		"GITHUB_WORKFLOW":     "CI",
+		"GITHUB_RUN_NUMBER":   "42",
+		"GITHUB_RUN_ID":       "1234",
+		"GITHUB_SERVER_URL":   "https://github.com",
+		"GITHUB_REPOSITORY":   "aitva/slackbot",
+		"GITHUB_REF_NAME":     "main",
+		"GITHUB_SHA":          "0123456789abcdef",
+		"GITHUB_ACTOR":        "octocat",
+		"GITHUB_EVENT_NAME":   "push",
+		"GITLAB_CI":           "",
+		"CI_PROJECT_PATH":     "aitva/slackbot",
+		"CI_PIPELINE_ID":      "7",
+		"CI_PIPELINE_URL":     "https://gitlab.com/aitva/slackbot/-/pipelines/7",
+		"CI_JOB_STATUS":       "failed",
+		"CI_COMMIT_REF_NAME":  "main",
+		"CI_COMMIT_SHORT_SHA": "01234567",
+		"GITLAB_USER_LOGIN":   "tanuki",
+		"CI_JOB_NAME":         "test",
+	}
+	switch ci {
+	case "github":
+		env["GITHUB_ACTIONS"] = "true"
+	case "gitlab":
+		env["GITLAB_CI"] = "true"
+	}
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+}
+
+func TestFromCI(t *testing.T) {
+	github := notification{
+		Title:  "CI #42",
+		Link:   "https://github.com/aitva/slackbot/actions/runs/1234",
+		Footer: "GitHub Actions",
+		Fields: fieldsFlag{
+			{"Repository", "aitva/slackbot", true},
+			{"Branch", "main", true},
+			{"Commit", "01234567", true},
+			{"Author", "octocat", true},
+			{"Event", "push", true},
+		},
+	}
+	gitlab := notification{
+		Status: "failure",
+		Title:  "aitva/slackbot pipeline #7",
+		Link:   "https://gitlab.com/aitva/slackbot/-/pipelines/7",
+		Footer: "GitLab CI",
+		Fields: fieldsFlag{
+			{"Project", "aitva/slackbot", true},
+			{"Branch", "main", true},
+			{"Commit", "01234567", true},
+			{"Author", "tanuki", true},
+			{"Job", "test", true},
+		},
+	}
+	tests := []struct {
+		name string
+		env  string
+		ci   string
+		want notification
+	}{
+		{"github", "github", "github", github},
+		{"auto github", "github", "auto", github},
+		{"gitlab", "gitlab", "gitlab", gitlab},
+		{"auto gitlab", "gitlab", "auto", gitlab},
+		{"auto elsewhere", "", "auto", notification{}},
+		{"none", "github", "none", notification{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciEnv(t, tt.env)
+			var n notification
+			if err := n.fromCI(tt.ci); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(n, tt.want) {
+				t.Errorf("fromCI(%q) = %+v, want %+v", tt.ci, n, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromCIFlags(t *testing.T) {
+	ciEnv(t, "gitlab")
+	n := notification{
+		Status: "success",
+		Title:  "release",
+		Fields: fieldsFlag{{"Branch", "v1.2", true}, {"Env", "prod", true}},
+	}
+	if err := n.fromCI("auto"); err != nil {
+		t.Fatal(err)
+	}
+	if n.Status != "success" || n.Title != "release" || n.Footer != "GitLab CI" {
+		t.Errorf("fromCI = %+v, want the flags kept and the footer filled", n)
+	}
+	want := fieldsFlag{
+		{"Project", "aitva/slackbot", true},
+		{"Branch", "v1.2", true},
+		{"Commit", "01234567", true},
+		{"Author", "tanuki", true},
+		{"Job", "test", true},
+		{"Env", "prod", true},
+	}
+	if !reflect.DeepEqual(n.Fields, want) {
+		t.Errorf("fields = %v, want %v", n.Fields, want)
+	}
+}
+
+func TestFromCIUnknown(t *testing.T) {
+	var n notification
+	if err := n.fromCI("jenkins"); err == nil {
+		t.Error("fromCI(jenkins) passed")
+	}
+}
+
+func TestAttachment(t *testing.T) {
+	at := time.Date(2020, 1, 2, 15, 4, 0, 0, time.UTC)
+	now = func() time.Time { return at }
+	defer func() { now = time.Now }()
+	n := &notification{
+		Title:  "build 42",
+		Text:   "all green",
+		Link:   "https://ci.example.com/42",
+		Footer: "CI",
+		Fields: fieldsFlag{{"branch", "main", true}},
+	}
+	st := statuses["success"]
+
+	got := n.Attachment(st)
+	want := &attachment{
+		Fallback:  "build 42 succeeded",
+		Color:     "good",
+		Title:     ":white_check_mark: build 42 succeeded",
+		TitleLink: "https://ci.example.com/42",
+		Text:      "all green",
+		Fields:    n.Fields,
+		Footer:    "CI",
+		Ts:        at.Unix(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Attachment = %+v, want %+v", got, want)
+	}
+
+	got = n.BlocksAttachment(st)
+	if got.Color != "good" || got.Fallback != "build 42 succeeded" {
+		t.Errorf("BlocksAttachment = color %q, fallback %q", got.Color, got.Fallback)
+	}
+	var texts []string
+	for _, b := range got.Blocks {
+		switch b := b.(type) {
+		case *blockkit.Section:
+			if b.Text != nil {
+				texts = append(texts, b.Text.Text)
+			}
+			for _, f := range b.Fields {
+				texts = append(texts, f.Text)
+			}
+		case *blockkit.Context:
+			for _, e := range b.Elements {
+				texts = append(texts, e.(*blockkit.Text).Text)
+			}
+		}
+	}
+	wantTexts := []string{
+		":white_check_mark: *<https://ci.example.com/42|build 42>* succeeded",
+		"all green",
+		"*branch*\nmain",
+		"CI | Jan 2, 15:04 UTC",
+	}
+	if !reflect.DeepEqual(texts, wantTexts) {
+		t.Errorf("BlocksAttachment texts = %q, want %q", texts, wantTexts)
+	}
+}
+
+func TestBlocksAttachmentFields(t *testing.T) {
+	n := &notification{Title: "build 42"}
+	for i := 0; i < 25; i++ {
+		n.Fields.add(fmt.Sprint("field ", i), "value")
+	}
+	att := n.BlocksAttachment(statuses["failure"])
+	if err := att.Blocks.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	// The title, three sections of fields and the footer.
+	var counts []int
+	for _, b := range att.Blocks {
+		if s, ok := b.(*blockkit.Section); ok && len(s.Fields) > 0 {
+			counts = append(counts, len(s.Fields))
+		}
+	}
+	if len(att.Blocks) != 5 || !reflect.DeepEqual(counts, []int{10, 10, 5}) {
+		t.Errorf("%d blocks, fields split in %v, want 5 blocks and [10 10 5]", len(att.Blocks), counts)
+	}
+}
@@ -0,0 +1,158 @@
+// Package interact handles the payloads Slack sends when users click
+// buttons, pick options, submit or close modals and run shortcuts.
+package interact
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aitva/slackbot/blockkit"
+)
+
+// Payload types.
+const (
+	BlockActions   = "block_actions"
+	ViewSubmission = "view_submission"
+	ViewClosed     = "view_closed"
+	Shortcut       = "shortcut"
+	MessageAction  = "message_action"
+)
+
+// Payload is an interaction of a user.
+type Payload struct {
+	Type string `json:"type"`
+	Team struct {
+		ID     string `json:"id"`
+		Domain string `json:"domain"`
+	} `json:"team"`
+	User struct {
+		ID     string `json:"id"`
+		Name   string `json:"name"`
+		TeamID string `json:"team_id"`
+	} `json:"user"`
+	Channel struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"channel"`
+	Container struct {
+		Type      string `json:"type"`
+		ViewID    string `json:"view_id"`
+		MessageTS string `json:"message_ts"`
+		ChannelID string `json:"channel_id"`
+	} `json:"container"`
+	TriggerID   string `json:"trigger_id"`
+	ResponseURL string `json:"response_url"`
+	// CallbackID identifies shortcuts and message actions.
+	CallbackID string          `json:"callback_id"`
+	Actions    []*Action       `json:"actions"`
+	View       *View           `json:"view"`
+	Message    json.RawMessage `json:"message"`
+
+	// Action is the action being handled, for block_actions.
+	Action *Action `json:"-"`
+}
+
+// Action is a click on a button or a choice in a menu.
+type Action struct {
+	ActionID       string           `json:"action_id"`
+	BlockID        string           `json:"block_id"`
+	Type           string           `json:"type"`
+	Value          string           `json:"value"`
+	SelectedOption *blockkit.Option `json:"selected_option"`
+	SelectedDate   string           `json:"selected_date"`
+	ActionTS       string           `json:"action_ts"`
+}
+
+// View is a modal or a Home tab as sent back by Slack.
+type View struct {
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	CallbackID      string          `json:"callback_id"`
+	ExternalID      string          `json:"external_id"`
+	Hash            string          `json:"hash"`
+	PrivateMetadata string          `json:"private_metadata"`
+	RootViewID      string          `json:"root_view_id"`
+	PreviousViewID  string          `json:"previous_view_id"`
+	Blocks          json.RawMessage `json:"blocks"`
+	State           State           `json:"state"`
+}
+
+// Metadata decodes the private metadata of the view into v.
+func (vw *View) Metadata(v interface{}) error {
+	if vw.PrivateMetadata == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(vw.PrivateMetadata), v)
+}
+
+// Metadata encodes v as the private metadata of a view, passing
+// state between the opening and the submission of a modal.
+func Metadata(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if len(b) > blockkit.MaxMetadata {
+		return "", fmt.Errorf("interact: metadata is %d bytes, max is %d", len(b), blockkit.MaxMetadata)
+	}
+	return string(b), nil
+}
+
+// State holds the values of the inputs of a view, by block and
+// action ID.
+type State struct {
+	Values map[string]map[string]*Value `json:"values"`
+}
+
+// Value is the value of an input.
+type Value struct {
+	Type           string           `json:"type"`
+	Value          string           `json:"value"`
+	SelectedOption *blockkit.Option `json:"selected_option"`
+	SelectedDate   string           `json:"selected_date"`
+}
+
+// Get returns the value of an input, or nil.
+func (s *State) Get(blockID, actionID string) *Value {
+	return s.Values[blockID][actionID]
+}
+
+// String returns the text typed or the value of the option chosen.
+func (v *Value) String() string {
+	switch {
+	case v == nil:
+		return ""
+	case v.SelectedOption != nil:
+		return v.SelectedOption.Value
+	case v.SelectedDate != "":
+		return v.SelectedDate
+	}
+	return v.Value
+}
+
+// Response is the answer to a view submission.
+type Response struct {
+	Action string            `json:"response_action"`
+	Errors map[string]string `json:"errors,omitempty"`
+	View   *blockkit.View    `json:"view,omitempty"`
+}
+
+// Errors shows errors next to the inputs of a modal, by block ID.
+func Errors(errs map[string]string) *Response {
+	return &Response{Action: "errors", Errors: errs}
+}
+
+// Update replaces the submitted modal with v.
+func Update(v *blockkit.View) *Response {
+	return &Response{Action: "update", View: v}
+}
+
+// Push shows v on top of the submitted modal.
+func Push(v *blockkit.View) *Response {
+	return &Response{Action: "push", View: v}
+}
+
+// Clear closes every modal of the stack.
+func Clear() *Response {
+	return &Response{Action: "clear"}
+}
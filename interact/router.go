@@ -0,0 +1,174 @@
+package interact
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/signature"
+)
+
+// Deadline is how long a view submission may take, Slack closes the
+// connection after three seconds.
+const Deadline = 2500 * time.Millisecond
+
+// Handler handles an interaction. Only the responses to view
+// submissions are sent to Slack, other handlers run after Slack got
+// its acknowledgment.
+type Handler interface {
+	ServeInteraction(ctx context.Context, p *Payload) (*Response, error)
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(ctx context.Context, p *Payload) (*Response, error)
+
+// ServeInteraction calls f.
+func (f HandlerFunc) ServeInteraction(ctx context.Context, p *Payload) (*Response, error) {
+	return f(ctx, p)
+}
+
+// Router dispatches interactions by payload type and ID: action_id
+// for block actions, callback_id for views and shortcuts.
+type Router struct {
+	// SigningSecret is the signing secret of the Slack app.
+	SigningSecret string
+	// Timeout bounds the time handlers run after the acknowledgment.
+	Timeout time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]map[string]Handler
+}
+
+// NewRouter creates a router checking requests against secret.
+func NewRouter(secret string) *Router {
+	return &Router{
+		SigningSecret: secret,
+		Timeout:       30 * time.Second,
+		handlers:      make(map[string]map[string]Handler),
+	}
+}
+
+// Handle registers the handler of the payloads of type typ with id.
+func (r *Router) Handle(typ, id string, h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.handlers[typ]
+	if !ok {
+		m = make(map[string]Handler)
+		r.handlers[typ] = m
+	}
+	m[id] = h
+}
+
+// HandleFunc registers a function as the handler of the payloads of
+// type typ with id.
+func (r *Router) HandleFunc(typ, id string, f func(ctx context.Context, p *Payload) (*Response, error)) {
+	r.Handle(typ, id, HandlerFunc(f))
+}
+
+func (r *Router) handler(typ, id string) Handler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.handlers[typ][id]
+}
+
+// logger returns the default logger, with the fields of p.
+func (p *Payload) logger() *slog.Logger {
+	return slog.With(logging.Event(p.Team.ID, p.Channel.ID, p.User.ID, p.Type)...)
+}
+
+// run calls h in the background, bounded by the router timeout. A
+// panic of h is logged like an error.
+func (r *Router) run(h Handler, p *Payload, id string) {
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				buf := make([]byte, 4096)
+				buf = buf[:runtime.Stack(buf, false)]
+				p.logger().Error("fail to handle interaction", "id", id, "err", fmt.Sprintf("panic: %v", v), "stack", string(buf))
+			}
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+		defer cancel()
+		if _, err := h.ServeInteraction(ctx, p); err != nil {
+			p.logger().Error("fail to handle interaction", "id", id, "err", err)
+		}
+	}()
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := signature.Verify(req, r.SigningSecret); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var p Payload
+	if err := json.Unmarshal([]byte(req.PostFormValue("payload")), &p); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch p.Type {
+	case BlockActions:
+		for _, a := range p.Actions {
+			h := r.handler(p.Type, a.ActionID)
+			if h == nil {
+				p.logger().Warn("no handler for action", "id", a.ActionID)
+				continue
+			}
+			ap := p
+			ap.Action = a
+			r.run(h, &ap, a.ActionID)
+		}
+	case ViewSubmission:
+		if p.View == nil {
+			http.Error(w, "missing view", http.StatusBadRequest)
+			return
+		}
+		h := r.handler(p.Type, p.View.CallbackID)
+		if h == nil {
+			p.logger().Warn("no handler for view", "id", p.View.CallbackID)
+			break
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), Deadline)
+		defer cancel()
+		resp, err := h.ServeInteraction(ctx, &p)
+		if err != nil {
+			p.logger().Error("fail to handle interaction", "id", p.View.CallbackID, "err", err)
+			http.Error(w, "fail to handle submission", http.StatusInternalServerError)
+			return
+		}
+		if resp != nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	case ViewClosed:
+		if p.View == nil {
+			break
+		}
+		if h := r.handler(p.Type, p.View.CallbackID); h != nil {
+			r.run(h, &p, p.View.CallbackID)
+		}
+	case Shortcut, MessageAction:
+		h := r.handler(p.Type, p.CallbackID)
+		if h == nil {
+			p.logger().Warn("no handler for callback", "id", p.CallbackID)
+			break
+		}
+		r.run(h, &p, p.CallbackID)
+	default:
+		p.logger().Warn("unexpected payload type")
+	}
+	w.WriteHeader(http.StatusOK)
+}
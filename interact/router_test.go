@@ -0,0 +1,58 @@
+package interact
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/signature"
+)
+
+// logLines sends what is logged to a channel.
+type logLines chan string
+
+func (l logLines) Write(b []byte) (int, error) {
+	l <- string(b)
+	return len(b), nil
+}
+
+func TestRouterRecovers(t *testing.T) {
+	logs := make(logLines, 10)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logs, nil)))
+
+	r := NewRouter("secret")
+	r.HandleFunc(BlockActions, "boom", func(ctx context.Context, p *Payload) (*Response, error) {
+		var m map[string]int
+		m[p.Action.ActionID]++
+		return nil, nil
+	})
+	body := []byte(url.Values{"payload": {`{"type":"block_actions","actions":[{"action_id":"boom"}]}`}}.Encode())
+	req := httptest.NewRequest("POST", "/slack/interactive", strings.NewReader(string(body)))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", signature.Sign("secret", ts, body))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	// The panic of the handler, run after the acknowledgment, is
+	// logged instead of stopping the bot.
+	select {
+	case line := <-logs:
+		if !strings.Contains(line, "fail to handle interaction") || !strings.Contains(line, "assignment to entry in nil map") {
+			t.Errorf("logged %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("panic not logged")
+	}
+}
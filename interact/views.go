@@ -0,0 +1,146 @@
+package interact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/slackenv"
+)
+
+// Client calls the Web API methods of views with a bot token.
+type Client struct {
+	Token string
+	Slack *slackenv.Config
+	HTTP  *http.Client
+}
+
+// NewClient creates a client calling slack with token, slack.com when
+// slack is nil.
+func NewClient(token string, slack *slackenv.Config) *Client {
+	if slack == nil {
+		slack = slackenv.Default()
+	}
+	return &Client{
+		Token: token,
+		Slack: slack,
+		HTTP:  metrics.NewClient(10 * time.Second),
+	}
+}
+
+// post sends v as JSON to url, with the token when auth is set.
+func (c *Client) post(ctx context.Context, url string, v interface{}, auth bool) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if auth {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTP.Do(req)
+}
+
+// Call calls a Web API method with params and decodes the answer
+// into result, if not nil.
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	resp, err := c.post(ctx, c.Slack.API(method), params, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status code: %d", method, resp.StatusCode)
+	}
+	var v struct {
+		Ok       bool   `json:"ok"`
+		Error    string `json:"error"`
+		Metadata struct {
+			Messages []string `json:"messages"`
+		} `json:"response_metadata"`
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+	if !v.Ok {
+		if len(v.Metadata.Messages) > 0 {
+			return fmt.Errorf("%s failed: %s %v", method, v.Error, v.Metadata.Messages)
+		}
+		return fmt.Errorf("%s failed: %s", method, v.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, result)
+}
+
+func (c *Client) callView(ctx context.Context, method string, params map[string]interface{}, v *blockkit.View) (*View, error) {
+	if err := v.Validate(); err != nil {
+		return nil, err
+	}
+	params["view"] = v
+	var result struct {
+		View *View `json:"view"`
+	}
+	if err := c.Call(ctx, method, params, &result); err != nil {
+		return nil, err
+	}
+	return result.View, nil
+}
+
+// OpenView opens a modal in answer to the interaction triggerID.
+func (c *Client) OpenView(ctx context.Context, triggerID string, v *blockkit.View) (*View, error) {
+	return c.callView(ctx, "views.open", map[string]interface{}{"trigger_id": triggerID}, v)
+}
+
+// PushView shows a modal on top of the current one.
+func (c *Client) PushView(ctx context.Context, triggerID string, v *blockkit.View) (*View, error) {
+	return c.callView(ctx, "views.push", map[string]interface{}{"trigger_id": triggerID}, v)
+}
+
+// UpdateView replaces the view viewID. With hash, the update fails
+// if the view changed since it was read.
+func (c *Client) UpdateView(ctx context.Context, viewID, hash string, v *blockkit.View) (*View, error) {
+	params := map[string]interface{}{"view_id": viewID}
+	if hash != "" {
+		params["hash"] = hash
+	}
+	return c.callView(ctx, "views.update", params, v)
+}
+
+// Respond posts a message to the response_url of an interaction.
+func (c *Client) Respond(ctx context.Context, url string, msg interface{}) error {
+	resp, err := c.post(ctx, url, msg, false)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("interact: unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// PublishView publishes the Home tab of user. With hash, the update
+// fails if the view changed since it was read.
+func (c *Client) PublishView(ctx context.Context, userID, hash string, v *blockkit.View) (*View, error) {
+	params := map[string]interface{}{"user_id": userID}
+	if hash != "" {
+		params["hash"] = hash
+	}
+	return c.callView(ctx, "views.publish", params, v)
+}
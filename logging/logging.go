@@ -0,0 +1,186 @@
+// Package logging sets up the logs of the bots: leveled, structured
+// as logfmt or JSON, and without secrets. Tokens, webhook and response
+// URLs and OAuth codes are redacted from every message and field.
+//
+// Bots call Setup first, then log with log/slog:
+//
+//	err := logging.Setup("timerbot")
+//	...
+//	slog.Error("fail to send message", logging.Event(team, channel, user, "message")...)
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+var (
+	// tokenRx matches bot, user, app and configuration tokens.
+	tokenRx = regexp.MustCompile(`\b(xox[a-z]|xapp)-[A-Za-z0-9-]+`)
+	// urlRx matches incoming webhooks, response URLs and workflow
+	// triggers, whose path is the secret.
+	urlRx = regexp.MustCompile(`(https?://[^\s/"'<>]+/(?:services|commands|actions|response|workflows|triggers)/)[^\s"'<>]+`)
+	// paramRx matches OAuth codes and secrets sent in a query or a
+	// form, jsonRx the same in JSON.
+	paramRx = regexp.MustCompile(`\b((?:code|client_secret|refresh_token|access_token)=)[^&\s"']+`)
+	jsonRx  = regexp.MustCompile(`("(?:code|client_secret|refresh_token|access_token|token)"\s*:\s*")[^"]*`)
+)
+
+// secretKeys are the fields whose whole value is redacted.
+var secretKeys = map[string]bool{
+	"token":         true,
+	"code":          true,
+	"secret":        true,
+	"client_secret": true,
+	"access_token":  true,
+	"refresh_token": true,
+	"password":      true,
+}
+
+// Redact removes the secrets from s.
+func Redact(s string) string {
+	s = tokenRx.ReplaceAllString(s, "$1-"+redacted)
+	s = urlRx.ReplaceAllString(s, "${1}"+redacted)
+	s = paramRx.ReplaceAllString(s, "${1}"+redacted)
+	return jsonRx.ReplaceAllString(s, "${1}"+redacted)
+}
+
+// Event returns the fields telling where an event comes from, without
+// the empty ones.
+func Event(team, channel, user, eventType string) []interface{} {
+	var fields []interface{}
+	for _, f := range [][2]string{{"team", team}, {"channel", channel}, {"user", user}, {"type", eventType}} {
+		if f[1] != "" {
+			fields = append(fields, f[0], f[1])
+		}
+	}
+	return fields
+}
+
+// Format is the format of the logs, Text for logfmt.
+type Format string
+
+const (
+	Text Format = "text"
+	JSON Format = "json"
+)
+
+// New creates a logger writing to w the records of level and above.
+func New(w io.Writer, format Format, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	if format == JSON {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(&redactor{h: h})
+}
+
+// FromEnv creates a logger writing to w, configured by LOG_LEVEL
+// (debug, info, warn or error, info by default) and LOG_FORMAT (text or
+// json, text by default).
+func FromEnv(w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if s := os.Getenv("LOG_LEVEL"); s != "" {
+		err := level.UnmarshalText([]byte(s))
+		if err != nil {
+			return nil, fmt.Errorf("logging: invalid LOG_LEVEL %q", s)
+		}
+	}
+	format := Format(strings.ToLower(os.Getenv("LOG_FORMAT")))
+	switch format {
+	case "":
+		format = Text
+	case Text, JSON:
+	default:
+		return nil, fmt.Errorf("logging: invalid LOG_FORMAT %q", format)
+	}
+	return New(w, format, level), nil
+}
+
+// Setup makes the logger of the environment, writing to stderr, the
+// default one. Records name the bot, and the log package writes to it
+// as well.
+func Setup(bot string) error {
+	l, err := FromEnv(os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(l.With("bot", bot))
+	return nil
+}
+
+// Fatal logs a as an error and exits when failed is true. Bots use it
+// in main, where there is nobody to return an error to:
+//
+//	logging.Fatal(err != nil, "fail to load config:", err)
+func Fatal(failed bool, a ...interface{}) {
+	if !failed {
+		return
+	}
+	slog.Error(strings.TrimSuffix(fmt.Sprintln(a...), "\n"))
+	os.Exit(1)
+}
+
+// redactor removes the secrets of the records before passing them to
+// h.
+type redactor struct {
+	h slog.Handler
+}
+
+func (r *redactor) Enabled(ctx context.Context, level slog.Level) bool {
+	return r.h.Enabled(ctx, level)
+}
+
+func (r *redactor) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, Redact(rec.Message), rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return r.h.Handle(ctx, out)
+}
+
+func (r *redactor) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &redactor{h: r.h.WithAttrs(clean)}
+}
+
+func (r *redactor) WithGroup(name string) slog.Handler {
+	return &redactor{h: r.h.WithGroup(name)}
+}
+
+// redactAttr removes the secrets of a. Values which are not strings,
+// numbers, times or groups are logged as text, so no field of a struct
+// escapes.
+func redactAttr(a slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(v.String()))
+	case slog.KindGroup:
+		attrs := v.Group()
+		clean := make([]interface{}, len(attrs))
+		for i, ga := range attrs {
+			clean[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindAny:
+		return slog.String(a.Key, Redact(fmt.Sprintf("%+v", v.Any())))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
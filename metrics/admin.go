@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"net/http"
+)
+
+// Handler serves the admin endpoints of a bot: the metrics of r on
+// /metrics, /healthz answering while the bot runs, and /readyz
+// answering 503 as long as ready fails.
+func Handler(r *Registry, ready func() error) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		if ready != nil {
+			if err := ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte("ok\n"))
+	})
+	return mux
+}
+
+// ListenAndServe serves the admin endpoints of Default on addr.
+func ListenAndServe(addr string, ready func() error) error {
+	return http.ListenAndServe(addr, Handler(Default, ready))
+}
@@ -0,0 +1,296 @@
+// Package metrics exposes the metrics of the bots in the Prometheus
+// text format, along with liveness and readiness endpoints.
+//
+// Metrics are counters, gauges and histograms with labels, created in
+// a Registry, Default unless told otherwise:
+//
+//	var sent = metrics.NewCounter("messages_sent_total", "Messages sent.", "channel")
+//	...
+//	sent.Inc(channel)
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is a family of series sharing a name.
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds metrics by name.
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the registry of the package level functions.
+var Default = NewRegistry()
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.metrics[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.metrics[name] = m
+}
+
+// Expose writes every metric to w, sorted by name.
+func (r *Registry) Expose(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// ServeHTTP serves the metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Expose(w)
+}
+
+// desc describes a metric and keeps its series by label values.
+type desc struct {
+	name, help, typ string
+	labels          []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values  []string
+	value   float64
+	buckets []float64
+	sum     float64
+	count   float64
+}
+
+func newDesc(name, help, typ string, labels []string) *desc {
+	return &desc{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+// get returns the series of values, creating it. The caller must hold
+// d.mu.
+func (d *desc) get(values []string) *series {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", d.name, len(d.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := d.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		d.series[key] = s
+	}
+	return s
+}
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.Replace(d.help, "\n", " ", -1), d.name, d.typ)
+}
+
+// sorted returns the series ordered by label values. The caller must
+// hold d.mu.
+func (d *desc) sorted() []*series {
+	keys := make([]string, 0, len(d.series))
+	for k := range d.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	all := make([]*series, len(keys))
+	for i, k := range keys {
+		all[i] = d.series[k]
+	}
+	return all
+}
+
+// labelString formats labels as {a="1",b="2"}, extra being added
+// last.
+func (d *desc) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range d.labels {
+		pairs = append(pairs, l+`="`+escape(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (d *desc) write(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.header(w)
+	for _, s := range d.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", d.name, d.labelString(s.values), formatFloat(s.value))
+	}
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter counts events, by label values.
+type Counter struct {
+	d *desc
+}
+
+// NewCounter creates a counter in r.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{d: newDesc(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter creates a counter in Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Add adds v, which must not be negative, to the series of values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter " + c.d.name + " decreased")
+	}
+	c.d.mu.Lock()
+	c.d.get(values).value += v
+	c.d.mu.Unlock()
+}
+
+// Inc adds one to the series of values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w io.Writer) { c.d.write(w) }
+
+// Gauge is a value going up and down, by label values.
+type Gauge struct {
+	d *desc
+}
+
+// NewGauge creates a gauge in r.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{d: newDesc(name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// NewGauge creates a gauge in Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// Set sets the series of values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.d.mu.Lock()
+	g.d.get(values).value = v
+	g.d.mu.Unlock()
+}
+
+// Add adds v to the series of values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.d.mu.Lock()
+	g.d.get(values).value += v
+	g.d.mu.Unlock()
+}
+
+// Inc adds one to the series of values.
+func (g *Gauge) Inc(values ...string) { g.Add(1, values...) }
+
+// Dec removes one from the series of values.
+func (g *Gauge) Dec(values ...string) { g.Add(-1, values...) }
+
+func (g *Gauge) write(w io.Writer) { g.d.write(w) }
+
+// DefBuckets suit durations in seconds, from 5ms to 10s.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets, by label values.
+type Histogram struct {
+	d       *desc
+	buckets []float64
+}
+
+// NewHistogram creates a histogram in r. Buckets are the upper bounds,
+// in increasing order.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{d: newDesc(name, help, "histogram", labels), buckets: buckets}
+	r.register(name, h)
+	return h
+}
+
+// NewHistogram creates a histogram in Default.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Observe records v in the series of values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.d.mu.Lock()
+	defer h.d.mu.Unlock()
+	s := h.d.get(values)
+	if s.buckets == nil {
+		s.buckets = make([]float64, len(h.buckets))
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	d := h.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.header(w)
+	for _, s := range d.sorted() {
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %s\n", d.name, d.labelString(s.values, "le", formatFloat(b)), formatFloat(s.buckets[i]))
+		}
+		fmt.Fprintf(w, "%s_bucket%s %s\n", d.name, d.labelString(s.values, "le", "+Inf"), formatFloat(s.count))
+		fmt.Fprintf(w, "%s_sum%s %s\n", d.name, d.labelString(s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %s\n", d.name, d.labelString(s.values), formatFloat(s.count))
+	}
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The metrics shared by the bots.
+var (
+	RTMConnected = NewGauge("slack_rtm_connected",
+		"Whether the RTM websocket of the team is open.", "team")
+	RTMReady = NewGauge("slack_rtm_ready",
+		"Whether Slack said hello on the RTM websocket of the team.", "team")
+	RTMConnects = NewCounter("slack_rtm_connects_total",
+		"RTM websockets opened, more than one per team counts reconnections.", "team")
+	Events = NewCounter("slack_events_received_total",
+		"Events received over RTM or the Events API, by type.", "team", "type")
+	Commands = NewCounter("slack_commands_total",
+		"Commands handled, by name and outcome: ok, error, timeout or unknown.", "command", "outcome")
+	APILatency = NewHistogram("slack_api_request_duration_seconds",
+		"Latency of the calls to Slack, by API method.", DefBuckets, "method")
+	APIErrors = NewCounter("slack_api_errors_total",
+		"Calls to Slack which failed, by API method and error code.", "method", "error")
+	RateLimits = NewCounter("slack_rate_limited_total",
+		"Requests rate limited, by Slack or by the bot, by source.", "source")
+	QueueDepth = NewGauge("slack_queue_depth",
+		"Messages waiting in a queue: rtm for the events to handle, outbox for the replies to send.", "queue")
+	QueueWait = NewHistogram("slack_queue_wait_seconds",
+		"Time messages waited for a worker, by queue.", DefBuckets, "queue")
+	QueueFull = NewCounter("slack_queue_full_total",
+		"Messages which waited for room in a full queue, by queue.", "queue")
+	WorkersBusy = NewGauge("slack_workers_busy",
+		"Workers handling a message, by pool.", "pool")
+	Outbound = NewCounter("slack_outbound_messages_total",
+		"Messages queued for Slack, by outcome: sent, coalesced, dropped or failed.", "outcome")
+)
+
+// Transport records the calls to Slack made through Base,
+// http.DefaultTransport if nil: latency, errors and rate limits.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewClient creates an HTTP client recording the calls to Slack.
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: &Transport{}}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	method := apiMethod(req)
+	start := time.Now()
+	resp, err := base.RoundTrip(req)
+	APILatency.Observe(time.Since(start).Seconds(), method)
+	if err != nil {
+		APIErrors.Inc(method, "network")
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		RateLimits.Inc("slack")
+	}
+	if resp.StatusCode >= 300 {
+		APIErrors.Inc(method, "http_"+strconv.Itoa(resp.StatusCode))
+		return resp, nil
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		// Web API errors come with a 200, in the body.
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+		if err != nil {
+			return resp, nil
+		}
+		var api struct {
+			Ok    *bool  `json:"ok"`
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(b, &api) == nil && api.Ok != nil && !*api.Ok {
+			APIErrors.Inc(method, api.Error)
+		}
+	}
+	return resp, nil
+}
+
+// apiMethod names the Slack endpoint called by req.
+func apiMethod(req *http.Request) string {
+	path := req.URL.Path
+	if i := strings.Index(path, "/api/"); i >= 0 {
+		return path[i+len("/api/"):]
+	}
+	switch {
+	case strings.Contains(path, "/services/"):
+		return "webhook"
+	case strings.Contains(path, "/commands/"), strings.Contains(path, "/actions/"), strings.Contains(path, "/response/"):
+		return "response_url"
+	}
+	return "other"
+}
@@ -0,0 +1,271 @@
+// Package middleware wraps the handlers of RTM messages: panic
+// recovery, request IDs, logging, rate limiting, filtering of bots,
+// channels and users, and timing.
+//
+// Middlewares compose with Chain, the first one sees the message
+// first:
+//
+//	h = middleware.Chain(h,
+//		middleware.RequestID(),
+//		middleware.Log(slog.Default()),
+//		middleware.Recover(),
+//		middleware.IgnoreBots(),
+//	)
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/rtm"
+)
+
+// Middleware wraps a handler.
+type Middleware func(rtm.Handler) rtm.Handler
+
+// Chain wraps h with mws, the first middleware being the outermost.
+func Chain(h rtm.Handler, mws ...Middleware) rtm.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// ID returns the request ID set by RequestID, "" if none.
+func ID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// RequestID gives each message an ID, found with ID. An ID already in
+// the context is kept.
+func RequestID() Middleware {
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+			if ID(ctx) == "" {
+				b := make([]byte, 8)
+				rand.Read(b)
+				ctx = context.WithValue(ctx, requestIDKey, hex.EncodeToString(b))
+			}
+			return next.ServeRTM(ctx, msg)
+		})
+	}
+}
+
+// Recover turns a panic of the handler into an error, so a faulty
+// command doesn't stop the bot.
+func Recover() Middleware {
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (resp *rtm.Message, err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					buf := make([]byte, 4096)
+					buf = buf[:runtime.Stack(buf, false)]
+					resp, err = nil, fmt.Errorf("middleware: panic: %v\n%s", v, buf)
+				}
+			}()
+			return next.ServeRTM(ctx, msg)
+		})
+	}
+}
+
+// Log logs every message answered or failed to l, with its request
+// ID, where it comes from and how long it took.
+func Log(l *slog.Logger) Middleware {
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+			start := time.Now()
+			resp, err := next.ServeRTM(ctx, msg)
+			if resp == nil && err == nil {
+				return resp, err
+			}
+			fields := append([]interface{}{"id", ID(ctx)}, logging.Event(msg.Team, msg.Channel, msg.User, msg.Type)...)
+			fields = append(fields, "duration", time.Since(start))
+			if err != nil {
+				// Keep the first line, stacks would break the log.
+				fields = append(fields, "err", strings.SplitN(err.Error(), "\n", 2)[0])
+				l.ErrorContext(ctx, "message failed", fields...)
+			} else {
+				l.InfoContext(ctx, "message answered", fields...)
+			}
+			return resp, err
+		})
+	}
+}
+
+// Timing reports how long the handler took.
+func Timing(observe func(d time.Duration, err error)) Middleware {
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+			start := time.Now()
+			resp, err := next.ServeRTM(ctx, msg)
+			observe(time.Since(start), err)
+			return resp, err
+		})
+	}
+}
+
+// filter drops the messages for which skip returns true.
+func filter(skip func(msg *rtm.Message) bool) Middleware {
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+			if skip(msg) {
+				return nil, nil
+			}
+			return next.ServeRTM(ctx, msg)
+		})
+	}
+}
+
+// IgnoreBots drops the messages sent by bots and integrations, which
+// could make bots answer each other forever.
+func IgnoreBots() Middleware {
+	return filter(func(msg *rtm.Message) bool {
+		return msg.BotID != "" || msg.Subtype == "bot_message" || msg.User == ""
+	})
+}
+
+// list is an allow list and a deny list. An empty allow list allows
+// everything not denied.
+type list struct {
+	allow, deny map[string]bool
+}
+
+func newList(allow, deny []string) *list {
+	l := &list{allow: make(map[string]bool), deny: make(map[string]bool)}
+	for _, id := range allow {
+		l.allow[id] = true
+	}
+	for _, id := range deny {
+		l.deny[id] = true
+	}
+	return l
+}
+
+func (l *list) allowed(id string) bool {
+	if l.deny[id] {
+		return false
+	}
+	return len(l.allow) == 0 || l.allow[id]
+}
+
+// Channels drops the messages of the channels denied or not allowed.
+// An empty allow list allows every channel.
+func Channels(allow, deny []string) Middleware {
+	l := newList(allow, deny)
+	return filter(func(msg *rtm.Message) bool { return !l.allowed(msg.Channel) })
+}
+
+// Users drops the messages of the users denied or not allowed. An
+// empty allow list allows every user.
+func Users(allow, deny []string) Middleware {
+	l := newList(allow, deny)
+	return filter(func(msg *rtm.Message) bool { return !l.allowed(msg.User) })
+}
+
+// now is the clock of the rate limits.
+var now = time.Now
+
+// bucket counts the messages of a user.
+type bucket struct {
+	tokens float64
+	last   time.Time
+	warned bool
+}
+
+// limiter holds the buckets of the users. A bucket idle for a period
+// is full again, it is dropped at the next sweep.
+type limiter struct {
+	n      int
+	period time.Duration
+	rate   float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(n int, period time.Duration) *limiter {
+	return &limiter{
+		n:       n,
+		period:  period,
+		rate:    float64(n) / float64(period),
+		buckets: make(map[string]*bucket),
+		swept:   now(),
+	}
+}
+
+// take takes a token from the bucket of key. It tells if the message
+// is limited, and if the user must be warned.
+func (l *limiter) take(key string) (limited, warn bool) {
+	t := now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t.Sub(l.swept) >= l.period {
+		for k, b := range l.buckets {
+			if t.Sub(b.last) >= l.period {
+				delete(l.buckets, k)
+			}
+		}
+		l.swept = t
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.n), last: t}
+		l.buckets[key] = b
+	}
+	b.tokens += float64(t.Sub(b.last)) * l.rate
+	if b.tokens > float64(l.n) {
+		b.tokens = float64(l.n)
+	}
+	b.last = t
+	limited = b.tokens < 1
+	warn = limited && !b.warned
+	if limited {
+		b.warned = true
+	} else {
+		b.tokens--
+		b.warned = false
+	}
+	return limited, warn
+}
+
+// RateLimit lets each user send n messages per period to the handler,
+// with bursts of n. Users going too fast are told once, then ignored
+// until they slow down. It belongs in front of commands, not of every
+// message of a channel.
+func RateLimit(n int, period time.Duration) Middleware {
+	l := newLimiter(n, period)
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+			limited, warn := l.take(msg.Team + "/" + msg.User)
+			if warn {
+				metrics.RateLimits.Inc("user")
+				return &rtm.Message{
+					Type:     "message",
+					Channel:  msg.Channel,
+					ThreadTS: msg.ThreadTS,
+					Text:     "You're going too fast, I'll answer again in a moment.",
+				}, nil
+			}
+			if limited {
+				metrics.RateLimits.Inc("user")
+				return nil, nil
+			}
+			return next.ServeRTM(ctx, msg)
+		})
+	}
+}
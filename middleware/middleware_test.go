@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/rtm"
+)
+
+// echo answers every message with its text.
+var echo = rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+	return &rtm.Message{Type: "message", Channel: msg.Channel, Text: msg.Text}, nil
+})
+
+// tag appends name to the text of the messages going through.
+func tag(name string) Middleware {
+	return func(next rtm.Handler) rtm.Handler {
+		return rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+			m := *msg
+			m.Text += name
+			return next.ServeRTM(ctx, &m)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	h := Chain(echo, tag("a"), tag("b"), tag("c"))
+	resp, err := h.ServeRTM(context.Background(), &rtm.Message{Text: ">"})
+	if err != nil || resp.Text != ">abc" {
+		t.Errorf("Chain = %+v, %v, want the first middleware outermost", resp, err)
+	}
+	if resp, _ := Chain(echo).ServeRTM(context.Background(), &rtm.Message{Text: "x"}); resp.Text != "x" {
+		t.Errorf("empty Chain = %+v", resp)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var ids []string
+	h := Chain(rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+		ids = append(ids, ID(ctx))
+		return nil, nil
+	}), RequestID())
+	h.ServeRTM(context.Background(), &rtm.Message{})
+	h.ServeRTM(context.Background(), &rtm.Message{})
+	if len(ids[0]) != 16 || ids[0] == ids[1] {
+		t.Errorf("IDs = %q, want two different IDs", ids)
+	}
+	// An ID set upstream is kept.
+	ctx := context.WithValue(context.Background(), requestIDKey, "upstream")
+	h.ServeRTM(ctx, &rtm.Message{})
+	if ids[2] != "upstream" {
+		t.Errorf("ID = %q, want upstream", ids[2])
+	}
+	if id := ID(context.Background()); id != "" {
+		t.Errorf("ID without RequestID = %q", id)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Chain(rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+		var m map[string]int
+		m[msg.Text]++
+		return &rtm.Message{}, nil
+	}), Recover())
+	resp, err := h.ServeRTM(context.Background(), &rtm.Message{Text: "hi"})
+	if resp != nil || err == nil || !strings.HasPrefix(err.Error(), "middleware: panic: assignment to entry in nil map") {
+		t.Errorf("Recover = %+v, %v", resp, err)
+	}
+	if resp, err := Chain(echo, Recover()).ServeRTM(context.Background(), &rtm.Message{Text: "hi"}); err != nil || resp.Text != "hi" {
+		t.Errorf("Recover without panic = %+v, %v", resp, err)
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, nil))
+	fail := rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+		return nil, errors.New("first line\nstack")
+	})
+	ignore := rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+		return nil, nil
+	})
+	msg := &rtm.Message{Type: "message", Team: "T1", Channel: "C1", User: "U1"}
+	Chain(echo, RequestID(), Log(l)).ServeRTM(context.Background(), msg)
+	Chain(fail, Log(l)).ServeRTM(context.Background(), msg)
+	Chain(ignore, Log(l)).ServeRTM(context.Background(), msg)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("logged %q, want a line per answer or error", lines)
+	}
+	for _, want := range []string{"level=INFO", `msg="message answered"`, "channel=C1", "user=U1"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("answer logged %q, want %s", lines[0], want)
+		}
+	}
+	if !strings.Contains(lines[1], "level=ERROR") || !strings.Contains(lines[1], `err="first line"`) {
+		t.Errorf("error logged %q, want its first line", lines[1])
+	}
+}
+
+func TestTiming(t *testing.T) {
+	var got error
+	calls := 0
+	h := Chain(rtm.HandlerFunc(func(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+		return nil, errors.New("failed")
+	}), Timing(func(d time.Duration, err error) {
+		calls++
+		got = err
+	}))
+	h.ServeRTM(context.Background(), &rtm.Message{})
+	if calls != 1 || got == nil || got.Error() != "failed" {
+		t.Errorf("observed %d times, err %v", calls, got)
+	}
+}
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		mw     Middleware
+		msg    rtm.Message
+		answer bool
+	}{
+		{"user", IgnoreBots(), rtm.Message{User: "U1"}, true},
+		{"bot id", IgnoreBots(), rtm.Message{User: "U1", BotID: "B1"}, false},
+		{"bot message", IgnoreBots(), rtm.Message{Subtype: "bot_message"}, false},
+		{"no user", IgnoreBots(), rtm.Message{}, false},
+
+		{"channel allowed", Channels([]string{"C1"}, nil), rtm.Message{Channel: "C1"}, true},
+		{"channel not allowed", Channels([]string{"C1"}, nil), rtm.Message{Channel: "C2"}, false},
+		{"channel denied", Channels(nil, []string{"C1"}), rtm.Message{Channel: "C1"}, false},
+		{"channel not denied", Channels(nil, []string{"C1"}), rtm.Message{Channel: "C2"}, true},
+		{"deny wins", Channels([]string{"C1"}, []string{"C1"}), rtm.Message{Channel: "C1"}, false},
+		{"no channel list", Channels(nil, nil), rtm.Message{Channel: "C1"}, true},
+
+		{"user allowed", Users([]string{"U1"}, nil), rtm.Message{User: "U1"}, true},
+		{"user not allowed", Users([]string{"U1"}, nil), rtm.Message{User: "U2"}, false},
+		{"user denied", Users(nil, []string{"U1"}), rtm.Message{User: "U1"}, false},
+		{"user not denied", Users(nil, []string{"U1"}), rtm.Message{User: "U2"}, true},
+	}
+	for _, tt := range tests {
+		msg := tt.msg
+		msg.Text = "hi"
+		resp, err := Chain(echo, tt.mw).ServeRTM(context.Background(), &msg)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if answer := resp != nil; answer != tt.answer {
+			t.Errorf("%s: answered %v, want %v", tt.name, answer, tt.answer)
+		}
+	}
+}
+
+// fakeClock replaces the clock of the rate limits until the test ends.
+func fakeClock(t *testing.T) *time.Time {
+	clock := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	now = func() time.Time { return clock }
+	t.Cleanup(func() { now = time.Now })
+	return &clock
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := fakeClock(t)
+	h := Chain(echo, RateLimit(2, time.Minute))
+	send := func(user string) string {
+		t.Helper()
+		resp, err := h.ServeRTM(context.Background(), &rtm.Message{Team: "T1", Channel: "C1", User: user, Text: "ok"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil {
+			return ""
+		}
+		return resp.Text
+	}
+	tests := []struct {
+		wait time.Duration
+		user string
+		want string
+	}{
+		// A burst of two, a warning, then silence.
+		{0, "U1", "ok"},
+		{0, "U1", "ok"},
+		{0, "U1", "You're going too fast, I'll answer again in a moment."},
+		{0, "U1", ""},
+		// Other users have their own bucket.
+		{0, "U2", "ok"},
+		// A token every 30 seconds.
+		{29 * time.Second, "U1", ""},
+		{time.Second, "U1", "ok"},
+		{0, "U1", "You're going too fast, I'll answer again in a moment."},
+		{time.Minute, "U1", "ok"},
+		{0, "U1", "ok"},
+	}
+	for i, tt := range tests {
+		*clock = clock.Add(tt.wait)
+		if got := send(tt.user); got != tt.want {
+			t.Errorf("%d: %s got %q, want %q", i, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitEvictsIdleUsers(t *testing.T) {
+	clock := fakeClock(t)
+	l := newLimiter(1, time.Minute)
+	l.take("T1/U1")
+	l.take("T1/U2")
+	*clock = clock.Add(30 * time.Second)
+	l.take("T1/U2")
+	if n := len(l.buckets); n != 2 {
+		t.Fatalf("%d buckets, want 2", n)
+	}
+
+	// U1 is idle for a period, its bucket is full again and dropped.
+	*clock = clock.Add(45 * time.Second)
+	l.take("T1/U3")
+	if _, ok := l.buckets["T1/U1"]; ok || len(l.buckets) != 2 {
+		t.Errorf("buckets = %v, want U2 and U3", l.buckets)
+	}
+	// A user coming back starts with a full bucket.
+	*clock = clock.Add(time.Hour)
+	if limited, _ := l.take("T1/U1"); limited {
+		t.Error("U1 limited after an hour")
+	}
+	if len(l.buckets) != 1 {
+		t.Errorf("%d buckets after an hour, want 1", len(l.buckets))
+	}
+}
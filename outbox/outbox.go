@@ -0,0 +1,309 @@
+// Package outbox sends the messages of a bot within the rate limits of
+// Slack, about one message per second per channel. Messages wait in a
+// queue per channel and leave in the order they were queued; when a
+// channel falls too far behind, the new messages are merged into the
+// last one waiting or dropped.
+//
+//	q := outbox.New(func(msg *rtm.Message) error {
+//		return client.Send(conn, msg)
+//	})
+//	go q.Run()
+//	...
+//	err := q.Send(msg)
+//	...
+//	err = q.Close(ctx)
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/rtm"
+)
+
+var (
+	// ErrClosed is returned by Send once the queue is closed.
+	ErrClosed = errors.New("outbox: queue closed")
+	// ErrDropped is returned by Send when the channel has too many
+	// messages waiting.
+	ErrDropped = errors.New("outbox: too many messages waiting, message dropped")
+)
+
+// now is the clock of the queues, tests replace it.
+var now = time.Now
+
+// maxText is the longest text Slack accepts over RTM, coalesced
+// messages stay below.
+const maxText = 4000
+
+// Policy tells what becomes of a message sent to a channel with
+// MaxPending messages waiting.
+type Policy string
+
+const (
+	// Coalesce appends the text of the message to the last one
+	// waiting, when both are plain text in the same thread, and drops
+	// it otherwise.
+	Coalesce Policy = "coalesce"
+	// Drop drops the message.
+	Drop Policy = "drop"
+)
+
+// ParsePolicy reads a policy, Coalesce if s is empty.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case "":
+		return Coalesce, nil
+	case Coalesce, Drop:
+		return p, nil
+	}
+	return "", fmt.Errorf("outbox: unknown policy %q", s)
+}
+
+// Queue holds the messages of a bot until they can be sent. A single
+// goroutine, Run, sends them.
+type Queue struct {
+	// PerChannel is the delay between two messages of a channel, and
+	// Global between any two messages.
+	PerChannel time.Duration
+	Global     time.Duration
+	// MaxPending is the number of messages waiting in a channel past
+	// which Policy applies, no limit if zero.
+	MaxPending int
+	Policy     Policy
+	Log        *slog.Logger
+
+	send func(*rtm.Message) error
+
+	mu       sync.Mutex
+	channels map[string]*channel
+	pending  int
+	// last is when the last message was sent, pause until when Slack
+	// asked to wait.
+	last, pause time.Time
+	// closed refuses new messages, abandon makes Run return with
+	// messages left.
+	closed, abandon bool
+	wake            chan struct{}
+	done            chan struct{}
+}
+
+type channel struct {
+	items []item
+	// next is when the channel can get a message again.
+	next time.Time
+}
+
+type item struct {
+	msg *rtm.Message
+	at  time.Time
+}
+
+// New creates a queue passing the messages to send. The defaults
+// follow Slack: a message per second per channel, four overall, and
+// ten messages waiting per channel before coalescing.
+func New(send func(*rtm.Message) error) *Queue {
+	return &Queue{
+		PerChannel: time.Second,
+		Global:     250 * time.Millisecond,
+		MaxPending: 10,
+		Policy:     Coalesce,
+		Log:        slog.Default(),
+		send:       send,
+		channels:   make(map[string]*channel),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+// Send queues msg, which belongs to the queue until it is sent.
+func (q *Queue) Send(msg *rtm.Message) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	ch, ok := q.channels[msg.Channel]
+	if !ok {
+		ch = &channel{}
+		q.channels[msg.Channel] = ch
+	}
+	if q.MaxPending > 0 && len(ch.items) >= q.MaxPending {
+		if q.Policy == Coalesce && coalesce(ch.items[len(ch.items)-1].msg, msg) {
+			metrics.Outbound.Inc("coalesced")
+			return nil
+		}
+		metrics.Outbound.Inc("dropped")
+		return ErrDropped
+	}
+	ch.items = append(ch.items, item{msg: msg, at: now()})
+	q.pending++
+	metrics.QueueDepth.Inc("outbox")
+	q.signal()
+	return nil
+}
+
+// coalesce appends the text of msg to last, if both are plain text in
+// the same thread.
+func coalesce(last, msg *rtm.Message) bool {
+	if len(last.Blocks) > 0 || len(msg.Blocks) > 0 || last.ThreadTS != msg.ThreadTS {
+		return false
+	}
+	if len(last.Text)+1+len(msg.Text) > maxText {
+		return false
+	}
+	last.Text += "\n" + msg.Text
+	return true
+}
+
+// signal wakes Run up. The caller must hold q.mu.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends the messages until the queue is closed and empty.
+func (q *Queue) Run() {
+	defer close(q.done)
+	for {
+		msg, wait, ok := q.next()
+		if !ok {
+			return
+		}
+		if msg == nil {
+			q.sleep(wait)
+			continue
+		}
+		err := q.send(msg)
+		q.sent(msg, err)
+	}
+}
+
+// sleep waits for d, forever if negative, or until a change in the
+// queue.
+func (q *Queue) sleep(d time.Duration) {
+	if d < 0 {
+		<-q.wake
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-q.wake:
+	}
+}
+
+// next takes the message to send now: among the channels allowed to
+// get one, the message waiting for the longest time. Otherwise it
+// returns how long to wait, negative if nothing waits, and false once
+// Run must return.
+func (q *Queue) next() (*rtm.Message, time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.abandon || q.closed && q.pending == 0 {
+		return nil, 0, false
+	}
+	now := now()
+	ready := q.last.Add(q.Global)
+	if q.pause.After(ready) {
+		ready = q.pause
+	}
+	var best *channel
+	wait := time.Duration(-1)
+	for name, ch := range q.channels {
+		if len(ch.items) == 0 {
+			if !ch.next.After(now) {
+				delete(q.channels, name)
+			}
+			continue
+		}
+		t := ready
+		if ch.next.After(t) {
+			t = ch.next
+		}
+		if t.After(now) {
+			if d := t.Sub(now); wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+		if best == nil || ch.items[0].at.Before(best.items[0].at) {
+			best = ch
+		}
+	}
+	if best == nil {
+		return nil, wait, true
+	}
+	msg := best.items[0].msg
+	best.items = best.items[1:]
+	best.next = now.Add(q.PerChannel)
+	q.last = now
+	q.pending--
+	metrics.QueueDepth.Dec("outbox")
+	return msg, 0, true
+}
+
+// sent records the outcome of sending msg. Messages rate limited by
+// Slack go back first in their channel, and the queue waits the delay
+// Slack asked for.
+func (q *Queue) sent(msg *rtm.Message, err error) {
+	if rerr, ok := err.(*rtm.RateLimitError); ok {
+		wait := rerr.RetryAfter
+		if wait <= 0 {
+			wait = q.PerChannel
+		}
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.abandon {
+			metrics.Outbound.Inc("dropped")
+			return
+		}
+		q.Log.Warn("rate limited by Slack, waiting", "channel", msg.Channel, "wait", wait)
+		ch, ok := q.channels[msg.Channel]
+		if !ok {
+			ch = &channel{}
+			q.channels[msg.Channel] = ch
+		}
+		ch.items = append([]item{{msg: msg, at: now()}}, ch.items...)
+		q.pending++
+		metrics.QueueDepth.Inc("outbox")
+		q.pause = now().Add(wait)
+		return
+	}
+	if err != nil {
+		metrics.Outbound.Inc("failed")
+		q.Log.Error("fail to send message", "channel", msg.Channel, "err", err)
+		return
+	}
+	metrics.Outbound.Inc("sent")
+}
+
+// Close refuses new messages and waits for the ones waiting to be
+// sent. Once ctx is done, the messages left are dropped.
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.signal()
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	q.abandon = true
+	n := q.pending
+	q.signal()
+	q.mu.Unlock()
+	metrics.Outbound.Add(float64(n), "dropped")
+	metrics.QueueDepth.Add(-float64(n), "outbox")
+	return fmt.Errorf("outbox: %d messages dropped: %v", n, ctx.Err())
+}
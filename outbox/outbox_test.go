@@ -0,0 +1,238 @@
+package outbox
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/rtm"
+)
+
+// clock is a fake clock moved by the tests.
+type clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func fakeClock(t *testing.T) *clock {
+	c := &clock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	now = func() time.Time {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.t
+	}
+	t.Cleanup(func() { now = time.Now })
+	return c
+}
+
+func (c *clock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// newQueue returns a queue whose messages are taken by the test with
+// next, or by Run sending them to sent.
+func newQueue(sent chan<- *rtm.Message) *Queue {
+	q := New(func(msg *rtm.Message) error {
+		sent <- msg
+		return nil
+	})
+	q.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	return q
+}
+
+// queue sends the texts to channels given as "C1:text", a millisecond
+// apart so they are ordered.
+func queue(t *testing.T, q *Queue, c *clock, msgs ...string) {
+	t.Helper()
+	for _, m := range msgs {
+		channel, text, _ := strings.Cut(m, ":")
+		if err := q.Send(&rtm.Message{Channel: channel, Text: text}); err != nil {
+			t.Fatal(err)
+		}
+		c.Add(time.Millisecond)
+	}
+}
+
+// expect checks the next message taken from q, or the wait when text
+// is empty.
+func expect(t *testing.T, q *Queue, text string, wait time.Duration) {
+	t.Helper()
+	msg, d, ok := q.next()
+	switch {
+	case !ok:
+		t.Fatal("queue done")
+	case text == "" && msg != nil:
+		t.Fatalf("next = %q, want to wait %v", msg.Text, wait)
+	case text == "" && d != wait:
+		t.Fatalf("wait %v, want %v", d, wait)
+	case text != "" && msg == nil:
+		t.Fatalf("next waits %v, want %q", d, text)
+	case text != "" && msg.Text != text:
+		t.Fatalf("next = %q, want %q", msg.Text, text)
+	}
+}
+
+func TestPerChannel(t *testing.T) {
+	c := fakeClock(t)
+	q := newQueue(nil)
+	q.Global = 0
+	queue(t, q, c, "C1:a", "C1:b", "C2:c")
+
+	expect(t, q, "a", 0)
+	// C1 waits a second, C2 is free.
+	expect(t, q, "c", 0)
+	expect(t, q, "", time.Second)
+	c.Add(time.Second)
+	expect(t, q, "b", 0)
+	// Nothing waits.
+	expect(t, q, "", -1)
+}
+
+func TestGlobal(t *testing.T) {
+	c := fakeClock(t)
+	q := newQueue(nil)
+	queue(t, q, c, "C1:a", "C2:b", "C3:c")
+
+	expect(t, q, "a", 0)
+	expect(t, q, "", 250*time.Millisecond)
+	c.Add(100 * time.Millisecond)
+	expect(t, q, "", 150*time.Millisecond)
+	c.Add(150 * time.Millisecond)
+	expect(t, q, "b", 0)
+	c.Add(250 * time.Millisecond)
+	expect(t, q, "c", 0)
+}
+
+func TestMaxPending(t *testing.T) {
+	long := strings.Repeat("x", maxText)
+	tests := []struct {
+		policy Policy
+		msg    rtm.Message
+		err    error
+		last   string
+	}{
+		{Coalesce, rtm.Message{Text: "c"}, nil, "b\nc"},
+		{Coalesce, rtm.Message{Text: "c", ThreadTS: "1.2"}, ErrDropped, "b"},
+		{Coalesce, rtm.Message{Text: "c", Blocks: blockkit.Blocks{&blockkit.Divider{}}}, ErrDropped, "b"},
+		{Coalesce, rtm.Message{Text: long}, ErrDropped, "b"},
+		{Drop, rtm.Message{Text: "c"}, ErrDropped, "b"},
+	}
+	for _, tt := range tests {
+		c := fakeClock(t)
+		q := newQueue(nil)
+		q.MaxPending = 2
+		q.Policy = tt.policy
+		queue(t, q, c, "C1:a", "C1:b")
+		// Other channels have room.
+		queue(t, q, c, "C2:x")
+
+		tt.msg.Channel = "C1"
+		if err := q.Send(&tt.msg); err != tt.err {
+			t.Errorf("%s: Send(%.10q) = %v, want %v", tt.policy, tt.msg.Text, err, tt.err)
+		}
+		items := q.channels["C1"].items
+		if len(items) != 2 || items[1].msg.Text != tt.last {
+			t.Errorf("%s: Send(%.10q) leaves %q last", tt.policy, tt.msg.Text, items[len(items)-1].msg.Text)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Policy
+		ok   bool
+	}{
+		{"", Coalesce, true},
+		{"coalesce", Coalesce, true},
+		{"drop", Drop, true},
+		{"Drop", "", false},
+		{"block", "", false},
+	}
+	for _, tt := range tests {
+		p, err := ParsePolicy(tt.s)
+		if p != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParsePolicy(%q) = %q, %v", tt.s, p, err)
+		}
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	c := fakeClock(t)
+	q := newQueue(nil)
+	q.Global = 0
+	queue(t, q, c, "C1:a", "C1:b", "C2:c")
+
+	msg, _, _ := q.next()
+	q.sent(msg, &rtm.RateLimitError{RetryAfter: 5 * time.Second})
+	// Every channel waits for Slack. a goes back first in C1, and
+	// counts as queued again.
+	expect(t, q, "", 5*time.Second)
+	c.Add(5 * time.Second)
+	expect(t, q, "c", 0)
+	expect(t, q, "a", 0)
+	expect(t, q, "", time.Second)
+
+	// Without Retry-After, the queue waits a message's delay.
+	c.Add(time.Second)
+	msg, _, _ = q.next()
+	q.sent(msg, &rtm.RateLimitError{})
+	expect(t, q, "", time.Second)
+	c.Add(time.Second)
+	expect(t, q, "b", 0)
+	expect(t, q, "", -1)
+}
+
+func TestClose(t *testing.T) {
+	sent := make(chan *rtm.Message, 10)
+	q := newQueue(sent)
+	q.PerChannel, q.Global = 0, 0
+	go q.Run()
+	for _, text := range []string{"a", "b"} {
+		if err := q.Send(&rtm.Message{Channel: "C1", Text: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 {
+		t.Errorf("%d messages sent, want 2", len(sent))
+	}
+	if err := q.Send(&rtm.Message{Channel: "C1"}); err != ErrClosed {
+		t.Errorf("Send after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestCloseDeadline(t *testing.T) {
+	c := fakeClock(t)
+	sent := make(chan *rtm.Message, 10)
+	q := newQueue(sent)
+	// The clock hardly moves, the second message never leaves.
+	q.PerChannel = time.Hour
+	go q.Run()
+	queue(t, q, c, "C1:a", "C1:b", "C1:c")
+	<-sent
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := q.Close(ctx)
+	if err == nil || !strings.Contains(err.Error(), "2 messages dropped") {
+		t.Errorf("Close = %v, want 2 messages dropped", err)
+	}
+	select {
+	case <-q.done:
+	case <-time.After(time.Second):
+		t.Fatal("Run still running after Close")
+	}
+	if len(sent) != 0 {
+		t.Errorf("%d messages sent past the deadline", len(sent))
+	}
+}
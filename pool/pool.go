@@ -0,0 +1,246 @@
+// Package pool handles the messages of a bot on a fixed number of
+// workers. The messages of a conversation are handled one at a time,
+// in the order they were submitted; conversations run concurrently, so
+// a slow command only holds up its own conversation.
+//
+//	p := pool.New(func(ctx context.Context, msg *rtm.Message) {
+//		...
+//	})
+//	p.Start(ctx) // before Submit
+//	...
+//	err := p.Submit(ctx, msg)
+//	...
+//	p.Close()
+//
+// Handlers reply through an outbox.Queue, which is the only writer of
+// the websocket.
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aitva/slackbot/cassette"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/rtm"
+)
+
+// ErrClosed is returned by Submit once the pool is closed.
+var ErrClosed = errors.New("pool: closed")
+
+// Conversation groups the messages of a user in a channel, or in a
+// thread, like the dialogs.
+func Conversation(msg *rtm.Message) string {
+	return msg.Team + "/" + msg.Channel + "/" + msg.ThreadTS + "/" + msg.User
+}
+
+// Pool runs handlers on workers.
+type Pool struct {
+	// Name labels the metrics of the pool.
+	Name string
+	// Size is the number of workers, Queue the number of messages
+	// submitted and not handled yet past which Submit waits.
+	Size  int
+	Queue int
+	// Timeout bounds the context of a handler, no limit if zero.
+	Timeout time.Duration
+	// Key tells the conversation of a message, Conversation by
+	// default.
+	Key func(msg *rtm.Message) string
+
+	handle func(ctx context.Context, msg *rtm.Message)
+	slots  chan struct{}
+	// jobs passes to the workers the conversations with messages
+	// waiting and no worker.
+	jobs chan string
+	wg   sync.WaitGroup
+	once sync.Once
+
+	mu      sync.Mutex
+	convs   map[string][]job
+	pending int
+	closed  bool
+}
+
+type job struct {
+	msg rtm.Message
+	at  time.Time
+}
+
+// New creates a pool calling handle, with eight workers, room for a
+// hundred messages and handlers cancelled after 30 seconds.
+func New(handle func(ctx context.Context, msg *rtm.Message)) *Pool {
+	return &Pool{
+		Name:    "rtm",
+		Size:    8,
+		Queue:   100,
+		Timeout: 30 * time.Second,
+		Key:     Conversation,
+		handle:  handle,
+		convs:   make(map[string][]job),
+	}
+}
+
+// FromEnv creates a pool calling handle, configured by WORKERS,
+// WORKER_QUEUE and HANDLER_TIMEOUT.
+func FromEnv(handle func(ctx context.Context, msg *rtm.Message)) (*Pool, error) {
+	p := New(handle)
+	for _, v := range []struct {
+		name string
+		n    *int
+	}{{"WORKERS", &p.Size}, {"WORKER_QUEUE", &p.Queue}} {
+		if s := os.Getenv(v.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("pool: invalid %s %q", v.name, s)
+			}
+			*v.n = n
+		}
+	}
+	if s := os.Getenv("HANDLER_TIMEOUT"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("pool: invalid HANDLER_TIMEOUT %q", s)
+		}
+		p.Timeout = d
+	}
+	return p, nil
+}
+
+// Start starts the workers. Handlers get a context derived from ctx.
+func (p *Pool) Start(ctx context.Context) {
+	p.mu.Lock()
+	p.slots = make(chan struct{}, p.Queue)
+	// A conversation holds at least one slot, the channel never
+	// blocks.
+	p.jobs = make(chan string, p.Queue)
+	p.mu.Unlock()
+	for i := 0; i < p.Size; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+}
+
+// Submit queues msg for a worker. When the pool is full, it waits for
+// room or for ctx to be done.
+func (p *Pool) Submit(ctx context.Context, msg rtm.Message) error {
+	// A free slot must not win over a done context.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		metrics.QueueFull.Inc(p.Name)
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	key := p.Key(&msg)
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.slots
+		return ErrClosed
+	}
+	waiting := p.convs[key]
+	p.convs[key] = append(waiting, job{msg: msg, at: time.Now()})
+	p.pending++
+	p.mu.Unlock()
+	metrics.QueueDepth.Inc(p.Name)
+	// Otherwise the worker of the conversation takes it next.
+	if len(waiting) == 0 {
+		p.jobs <- key
+	}
+	return nil
+}
+
+// Read submits the messages of c until ctx is done or c fails.
+func (p *Pool) Read(ctx context.Context, c cassette.Conn, log *slog.Logger) {
+	rtm.ReadMessages(ctx, c, log, func(msg rtm.Message) error {
+		return p.Submit(ctx, msg)
+	})
+}
+
+// work handles the first message of the conversations it is passed,
+// and passes them back while messages are left.
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+	for key := range p.jobs {
+		p.mu.Lock()
+		j := p.convs[key][0]
+		p.mu.Unlock()
+		metrics.QueueDepth.Dec(p.Name)
+		metrics.QueueWait.Observe(time.Since(j.at).Seconds(), p.Name)
+		p.run(ctx, &j.msg)
+
+		p.mu.Lock()
+		left := p.convs[key][1:]
+		if len(left) == 0 {
+			delete(p.convs, key)
+		} else {
+			p.convs[key] = left
+		}
+		p.pending--
+		idle := p.closed && p.pending == 0
+		p.mu.Unlock()
+		if len(left) > 0 {
+			p.jobs <- key
+		}
+		<-p.slots
+		if idle {
+			p.once.Do(func() { close(p.jobs) })
+		}
+	}
+}
+
+func (p *Pool) run(ctx context.Context, msg *rtm.Message) {
+	metrics.WorkersBusy.Inc(p.Name)
+	defer metrics.WorkersBusy.Dec(p.Name)
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	p.handle(ctx, msg)
+}
+
+// Close refuses new messages and returns once the messages submitted
+// are handled. A pool never started has nothing to wait for.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	idle := p.pending == 0
+	started := p.jobs != nil
+	p.mu.Unlock()
+	if idle && started {
+		p.once.Do(func() { close(p.jobs) })
+	}
+	p.wg.Wait()
+}
+
+// Drain waits for done, closed once the pools are closed. Past the
+// deadline of ctx, the handlers are cancelled with abort and given a
+// second to return.
+func Drain(ctx context.Context, done <-chan struct{}, abort context.CancelFunc) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	slog.Warn("drain timeout, cancelling handlers")
+	abort()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		slog.Error("handlers still running, giving up")
+	}
+}
@@ -0,0 +1,203 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/rtm"
+)
+
+func TestOrder(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string][]string)
+	p := New(func(ctx context.Context, msg *rtm.Message) {
+		mu.Lock()
+		got[msg.User] = append(got[msg.User], msg.Text)
+		mu.Unlock()
+	})
+	p.Size = 4
+	p.Start(context.Background())
+	users := []string{"U1", "U2", "U3"}
+	for i := 0; i < 50; i++ {
+		for _, user := range users {
+			msg := rtm.Message{Channel: "C1", User: user, Text: fmt.Sprint(i)}
+			if err := p.Submit(context.Background(), msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	p.Close()
+
+	for _, user := range users {
+		if len(got[user]) != 50 {
+			t.Fatalf("%s: %d messages handled, want 50", user, len(got[user]))
+		}
+		for i, text := range got[user] {
+			if text != fmt.Sprint(i) {
+				t.Fatalf("%s: handled %q, want in order", user, got[user])
+			}
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	var mu sync.Mutex
+	busy, most := 0, 0
+	p := New(func(ctx context.Context, msg *rtm.Message) {
+		mu.Lock()
+		busy++
+		if busy > most {
+			most = busy
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		busy--
+		mu.Unlock()
+	})
+	p.Size = 3
+	p.Start(context.Background())
+	for i := 0; i < 30; i++ {
+		msg := rtm.Message{Channel: "C1", User: fmt.Sprint("U", i)}
+		if err := p.Submit(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p.Close()
+	if most != p.Size {
+		t.Errorf("%d handlers at once, want %d", most, p.Size)
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	p := New(func(ctx context.Context, msg *rtm.Message) {
+		<-release
+	})
+	p.Size = 1
+	p.Queue = 2
+	p.Start(context.Background())
+	defer p.Close()
+	defer close(release)
+
+	for i := 0; i < p.Queue; i++ {
+		if err := p.Submit(context.Background(), rtm.Message{User: "U1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.Submit(ctx, rtm.Message{User: "U2"}); err != context.DeadlineExceeded {
+		t.Errorf("Submit to a full pool = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSubmitDone(t *testing.T) {
+	handled := make(chan string, 1)
+	p := New(func(ctx context.Context, msg *rtm.Message) {
+		handled <- msg.Text
+	})
+	p.Start(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// Many tries, a free slot is picked at random against ctx.Done.
+	for i := 0; i < 100; i++ {
+		if err := p.Submit(ctx, rtm.Message{Text: "late"}); err != context.Canceled {
+			t.Fatalf("Submit = %v, want %v", err, context.Canceled)
+		}
+	}
+	p.Close()
+	select {
+	case text := <-handled:
+		t.Errorf("handled %q after its context was done", text)
+	default:
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	errs := make(chan error, 1)
+	p := New(func(ctx context.Context, msg *rtm.Message) {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	})
+	p.Timeout = 10 * time.Millisecond
+	p.Start(context.Background())
+	if err := p.Submit(context.Background(), rtm.Message{}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errs:
+		if err != context.DeadlineExceeded {
+			t.Errorf("handler context: %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handler not cancelled")
+	}
+	p.Close()
+}
+
+func TestClose(t *testing.T) {
+	p := New(func(ctx context.Context, msg *rtm.Message) {})
+	// Never started.
+	p.Close()
+
+	p = New(func(ctx context.Context, msg *rtm.Message) {})
+	p.Start(context.Background())
+	p.Close()
+	if err := p.Submit(context.Background(), rtm.Message{}); err != ErrClosed {
+		t.Errorf("Submit after Close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		workers, queue, timeout string
+		ok                      bool
+	}{
+		{"", "", "", true},
+		{"2", "10", "5s", true},
+		{"0", "", "", false},
+		{"", "many", "", false},
+		{"", "", "soon", false},
+	}
+	for _, tt := range tests {
+		t.Setenv("WORKERS", tt.workers)
+		t.Setenv("WORKER_QUEUE", tt.queue)
+		t.Setenv("HANDLER_TIMEOUT", tt.timeout)
+		_, err := FromEnv(nil)
+		if (err == nil) != tt.ok {
+			t.Errorf("FromEnv(%q, %q, %q) = %v", tt.workers, tt.queue, tt.timeout, err)
+		}
+	}
+	t.Setenv("WORKERS", "2")
+	t.Setenv("WORKER_QUEUE", "10")
+	t.Setenv("HANDLER_TIMEOUT", "5s")
+	p, _ := FromEnv(nil)
+	if p.Size != 2 || p.Queue != 10 || p.Timeout != 5*time.Second {
+		t.Errorf("FromEnv = size %d, queue %d, timeout %v", p.Size, p.Queue, p.Timeout)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	aborted := false
+	Drain(context.Background(), done, func() { aborted = true })
+	if aborted {
+		t.Error("aborted while done")
+	}
+
+	// Handlers returning once cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done = make(chan struct{})
+	Drain(ctx, done, func() { close(done) })
+	select {
+	case <-done:
+	default:
+		t.Error("handlers not cancelled past the deadline")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/aitva/slackbot/authcache"
+)
+
+// source is a third-party service sending webhooks to the relay.
+type source struct {
+	// Kind selects the default template and the verification of
+	// requests: github, alertmanager, grafana or generic.
+	Kind string `json:"kind"`
+	// Secret is the GitHub webhook secret used to verify requests.
+	Secret string `json:"secret"`
+	// Template is a file holding a Go template rendering a Slack
+	// message in JSON, it replaces the default one of the kind.
+	Template string `json:"template"`
+
+	tmpl *template.Template
+}
+
+// route sends the payloads matching a rule to a channel. Rules are
+// tried in order, the first match wins; a rule without source nor
+// field matches every payload.
+type route struct {
+	Source string `json:"source"`
+	Event  string `json:"event"`
+	// Field is a dotted path in the payload, like "repository.name"
+	// or "alerts.0.labels.severity".
+	Field  string `json:"field"`
+	Equals string `json:"equals"`
+	Match  string `json:"match"`
+	// Channel selects the incoming webhook installed through authsrv
+	// for this channel, WebhookURL gives it explicitly. Team, an ID or
+	// a name, picks the workspace when several have the channel.
+	Team       string `json:"team"`
+	Channel    string `json:"channel"`
+	WebhookURL string `json:"webhook_url"`
+
+	re *regexp.Regexp
+}
+
+type config struct {
+	// Credentials is the directory where authsrv caches tokens.
+	Credentials string             `json:"credentials"`
+	Sources     map[string]*source `json:"sources"`
+	Routes      []*route           `json:"routes"`
+
+	webhooks *authcache.Webhooks
+}
+
+// loadConfig reads the relay config from filename, with its
+// templates and the webhooks cached by authsrv.
+func loadConfig(filename string) (*config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	conf := &config{}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, err
+	}
+	if conf.Credentials == "" {
+		conf.Credentials, err = authcache.DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	conf.webhooks, err = authcache.NewWebhooks(conf.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, src := range conf.Sources {
+		text, ok := defaultTemplates[src.Kind]
+		if !ok {
+			return nil, fmt.Errorf("source %s: unknown kind %q", name, src.Kind)
+		}
+		if src.Kind == "github" && src.Secret == "" {
+			return nil, fmt.Errorf("source %s: GitHub sources need a secret", name)
+		}
+		if src.Template != "" {
+			b, err := ioutil.ReadFile(src.Template)
+			if err != nil {
+				return nil, fmt.Errorf("source %s: %v", name, err)
+			}
+			text = string(b)
+		}
+		src.tmpl, err = template.New(name).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %v", name, err)
+		}
+	}
+	for i, r := range conf.Routes {
+		if r.Match != "" {
+			r.re, err = regexp.Compile(r.Match)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %v", i, err)
+			}
+		}
+		if r.WebhookURL != "" {
+			continue
+		}
+		if r.Channel == "" {
+			return nil, fmt.Errorf("route %d: channel or webhook_url required", i)
+		}
+		// The app may be installed in the channel later.
+		url, err := conf.webhooks.URL(r.Team, r.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %v", i, err)
+		}
+		if url == "" {
+			slog.Warn("no webhook for channel yet", "route", i, "team", r.Team, "channel", r.Channel)
+		}
+	}
+	return conf, nil
+}
+
+// lookup returns the value at a dotted path in a decoded JSON
+// payload, nil if there is none.
+func lookup(v interface{}, path string) interface{} {
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// Matches reports whether the route applies to a payload.
+func (r *route) Matches(name, event string, payload interface{}) bool {
+	if r.Source != "" && r.Source != name {
+		return false
+	}
+	if r.Event != "" && r.Event != event {
+		return false
+	}
+	if r.Field == "" {
+		return true
+	}
+	v := lookup(payload, r.Field)
+	if v == nil {
+		return false
+	}
+	s := fmt.Sprint(v)
+	if r.re != nil {
+		return r.re.MatchString(s)
+	}
+	return s == r.Equals
+}
+
+// Route returns the first route matching a payload.
+func (conf *config) Route(name, event string, payload interface{}) *route {
+	for _, r := range conf.Routes {
+		if r.Matches(name, event, payload) {
+			return r
+		}
+	}
+	return nil
+}
+
+// WebhookURL returns the incoming webhook of a route. Webhooks of
+// apps installed since the relay started are found too.
+func (conf *config) WebhookURL(r *route) (string, error) {
+	if r.WebhookURL != "" {
+		return r.WebhookURL, nil
+	}
+	url, err := conf.webhooks.URL(r.Team, r.Channel)
+	if err != nil {
+		if url == "" {
+			return "", err
+		}
+		slog.Warn("fail to read the webhooks cached by authsrv", "err", err)
+	}
+	if url == "" {
+		if r.Team != "" {
+			return "", fmt.Errorf("no webhook for channel %q in team %q", r.Channel, r.Team)
+		}
+		return "", fmt.Errorf("no webhook for channel %q", r.Channel)
+	}
+	return url, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+)
+
+var global struct {
+	conf *config
+}
+
+// message is the part of a Slack message the relay checks.
+type message struct {
+	Text        string            `json:"text"`
+	Blocks      []json.RawMessage `json:"blocks"`
+	Attachments []json.RawMessage `json:"attachments"`
+}
+
+// verifyGitHub checks the X-Hub-Signature-256 header of a GitHub
+// webhook against the HMAC of the body.
+func verifyGitHub(secret string, body []byte, signature string) bool {
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// render turns a payload into a Slack message.
+func render(src *source, name, event string, body []byte, payload interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	data := map[string]interface{}{
+		"Source":  name,
+		"Event":   event,
+		"Payload": payload,
+		"Raw":     string(body),
+	}
+	err := src.tmpl.Execute(&buf, data)
+	if err != nil {
+		return nil, err
+	}
+	var msg message
+	err = json.Unmarshal(buf.Bytes(), &msg)
+	if err != nil {
+		return nil, fmt.Errorf("template rendered invalid JSON: %v", err)
+	}
+	if msg.Text == "" && len(msg.Blocks) == 0 && len(msg.Attachments) == 0 {
+		return nil, fmt.Errorf("template rendered an empty message")
+	}
+	return buf.Bytes(), nil
+}
+
+// slackClient posts to Slack, recording the calls in the metrics.
+var slackClient = metrics.NewClient(10 * time.Second)
+
+// post sends a rendered message to an incoming webhook.
+func post(url string, msg []byte) error {
+	resp, err := slackClient.Post(url, "application/json", bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Slack refused the message: %s (%d)", strings.TrimSpace(string(body)), resp.StatusCode)
+	}
+	return nil
+}
+
+// maxBody is the largest payload accepted from a source.
+const maxBody = 5 << 20
+
+// bodyErrorStatus returns the status answering a request whose body
+// could not be read: 413 when it is too large, 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// handleHook relays POST /hooks/{source} to Slack. With a trailing
+// /dry-run, it answers the rendered message instead of posting it.
+func handleHook(w http.ResponseWriter, r *http.Request) {
+	defer slog.Info("request", "method", r.Method, "path", r.URL.Path)
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/hooks/")
+	dryRun := strings.HasSuffix(name, "/dry-run")
+	name = strings.TrimSuffix(name, "/dry-run")
+	conf := global.conf
+	src, ok := conf.Sources[name]
+	if !ok {
+		http.Error(w, "unknown source", http.StatusNotFound)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		http.Error(w, err.Error(), bodyErrorStatus(err))
+		return
+	}
+	event := ""
+	if src.Kind == "github" {
+		if !verifyGitHub(src.Secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		event = r.Header.Get("X-GitHub-Event")
+	}
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, "invalid JSON payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rt := conf.Route(name, event, payload)
+	if rt == nil {
+		http.Error(w, "no route for payload", http.StatusUnprocessableEntity)
+		return
+	}
+	msg, err := render(src, name, event, body, payload)
+	if err != nil {
+		slog.Error("fail to render payload", "source", name, "event", event, "err", err)
+		http.Error(w, "fail to render message: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if dryRun {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Channel string          `json:"channel"`
+			Message json.RawMessage `json:"message"`
+		}{rt.Channel, msg})
+		return
+	}
+	url, err := conf.WebhookURL(rt)
+	if err != nil {
+		slog.Error("fail to relay payload", "source", name, "event", event, "channel", rt.Channel, "err", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err := post(url, msg); err != nil {
+		slog.Error("fail to relay payload", "source", name, "event", event, "channel", rt.Channel, "err", err)
+		http.Error(w, "fail to post to Slack", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func main() {
+	if err := logging.Setup("relaybot"); err != nil {
+		slog.Error("fail to set up logs", "err", err)
+		os.Exit(1)
+	}
+	filename := "relay.json"
+	if len(os.Args) > 1 {
+		filename = os.Args[1]
+	}
+	var err error
+	global.conf, err = loadConfig(filename)
+	if err != nil {
+		slog.Error("fail to load config", "err", err)
+		os.Exit(1)
+	}
+
+	http.HandleFunc("/hooks/", handleHook)
+
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9105"
+	}
+	go func() {
+		err := metrics.ListenAndServe(adminAddr, nil)
+		slog.Error("fail to serve admin endpoints", "err", err)
+		os.Exit(1)
+	}()
+
+	addr := ":3001"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	slog.Info("listening", "addr", addr)
+	err = http.ListenAndServe(addr, nil)
+	slog.Error("fail to serve", "err", err)
+	os.Exit(1)
+}
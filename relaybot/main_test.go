@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestHandleHookBody(t *testing.T) {
+	global.conf = &config{Sources: map[string]*source{"generic": {Kind: "generic"}}}
+	defer func() { global.conf = nil }()
+
+	tests := []struct {
+		name   string
+		body   io.Reader
+		status int
+	}{
+		{"too large", strings.NewReader(strings.Repeat("a", maxBody+1)), http.StatusRequestEntityTooLarge},
+		{"broken", brokenReader{}, http.StatusBadRequest},
+		{"not JSON", strings.NewReader("{"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleHook(w, httptest.NewRequest("POST", "/hooks/generic", tt.body))
+		if w.Code != tt.status {
+			t.Errorf("%s body: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
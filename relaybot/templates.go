@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"unicode"
+	"unicode/utf8"
+)
+
+// funcs are available in every template. Templates render JSON, so
+// strings must go through json to be escaped.
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+	"truncate": func(n int, s string) string {
+		r := []rune(s)
+		if n <= 0 {
+			return ""
+		}
+		if len(r) <= n {
+			return s
+		}
+		return string(r[:n-1]) + "…"
+	},
+	"lookup": lookup,
+	// Values looked up may be missing, the functions below take nil
+	// as an empty value rather than failing the template.
+	"upper": func(v interface{}) string {
+		return strings.ToUpper(str(v))
+	},
+	"title": func(v interface{}) string {
+		s := str(v)
+		r, n := utf8.DecodeRuneInString(s)
+		if n == 0 {
+			return s
+		}
+		return string(unicode.ToUpper(r)) + s[n:]
+	},
+	"len": func(v interface{}) (int, error) {
+		if v == nil {
+			return 0, nil
+		}
+		switch rv := reflect.ValueOf(v); rv.Kind() {
+		case reflect.Array, reflect.Chan, reflect.Map, reflect.Slice, reflect.String:
+			return rv.Len(), nil
+		}
+		return 0, fmt.Errorf("len of %T", v)
+	},
+}
+
+// str formats v, "" if nil.
+func str(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// defaultTemplates render the payloads of each kind of source.
+var defaultTemplates = map[string]string{
+	"github": `{{$p := .Payload -}}
+{{- $repo := lookup $p "repository.full_name" -}}
+{{- if eq .Event "push" -}}
+{"text": {{json (printf "[%s] %s pushed %d commit(s) to %s: %s" $repo (lookup $p "pusher.name") (len (lookup $p "commits")) (lookup $p "ref") (lookup $p "compare"))}}}
+{{- else if eq .Event "pull_request" -}}
+{"text": {{json (printf "[%s] Pull request %s by %s: <%s|%s>" $repo (lookup $p "action") (lookup $p "pull_request.user.login") (lookup $p "pull_request.html_url") (lookup $p "pull_request.title"))}}}
+{{- else if eq .Event "issues" -}}
+{"text": {{json (printf "[%s] Issue %s by %s: <%s|%s>" $repo (lookup $p "action") (lookup $p "issue.user.login") (lookup $p "issue.html_url") (lookup $p "issue.title"))}}}
+{{- else if eq .Event "ping" -}}
+{"text": {{json (printf "[%s] GitHub webhook configured: %s" (default "organization" $repo) (lookup $p "zen"))}}}
+{{- else -}}
+{"text": {{json (printf "[%s] GitHub event %s" (default "?" $repo) .Event)}}}
+{{- end}}`,
+
+	"alertmanager": `{{$p := .Payload -}}
+{"attachments": [
+{{- range $i, $a := lookup $p "alerts" -}}
+{{if $i}},{{end}}{
+	"color": {{if eq (lookup $a "status") "resolved"}}"good"{{else}}"danger"{{end}},
+	"title": {{json (printf "[%s] %s" (upper (lookup $a "status")) (default "alert" (lookup $a "labels.alertname")))}},
+	"title_link": {{json (default "" (lookup $a "generatorURL"))}},
+	"text": {{json (default "" (default (lookup $a "annotations.summary") (lookup $a "annotations.description")))}}
+}
+{{- end}}
+], "text": {{json (printf "%s: %d alert(s)" (title (lookup $p "status")) (len (lookup $p "alerts")))}}}`,
+
+	"grafana": `{{$p := .Payload -}}
+{"attachments": [{
+	"color": {{if or (eq (lookup $p "state") "ok") (eq (lookup $p "status") "resolved")}}"good"{{else}}"danger"{{end}},
+	"title": {{json (default "Grafana alert" (default (lookup $p "ruleName") (lookup $p "title")))}},
+	"title_link": {{json (default "" (default (lookup $p "ruleUrl") (lookup $p "externalURL")))}},
+	"text": {{json (default "" (lookup $p "message"))}}
+}], "text": {{json (default "Grafana alert" (default (lookup $p "ruleName") (lookup $p "title")))}}}`,
+
+	"generic": `{{$p := .Payload -}}
+{{- if lookup $p "text" -}}
+{"text": {{json (lookup $p "text")}}}
+{{- else -}}
+{"text": {{json (printf "%s sent:\n` + "```" + `%s` + "```" + `" .Source (.Raw))}}}
+{{- end}}`,
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// renderKind renders payload with the default template of kind.
+func renderKind(t *testing.T, kind, event, payload string) (map[string]interface{}, error) {
+	tmpl, err := template.New(kind).Funcs(funcs).Parse(defaultTemplates[kind])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p interface{}
+	if err := json.Unmarshal([]byte(payload), &p); err != nil {
+		t.Fatal(err)
+	}
+	b, err := render(&source{Kind: kind, tmpl: tmpl}, kind, event, []byte(payload), p)
+	if err != nil {
+		return nil, err
+	}
+	var msg map[string]interface{}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg, nil
+}
+
+func TestAlertmanagerTemplate(t *testing.T) {
+	msg, err := renderKind(t, "alertmanager", "", `{
+		"status": "firing",
+		"alerts": [
+			{"status": "firing", "labels": {"alertname": "DiskFull"},
+			 "annotations": {"summary": "Disk full", "description": "/var is 99% full on db1"}},
+			{"status": "resolved", "labels": {"alertname": "Load"},
+			 "annotations": {"summary": "Load is back to normal"}}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg["text"] != "Firing: 2 alert(s)" {
+		t.Errorf("text = %q", msg["text"])
+	}
+	atts := msg["attachments"].([]interface{})
+	first := atts[0].(map[string]interface{})
+	if first["text"] != "/var is 99% full on db1" {
+		t.Errorf("text of the first alert = %q, want the description", first["text"])
+	}
+	if first["title"] != "[FIRING] DiskFull" {
+		t.Errorf("title = %q", first["title"])
+	}
+	second := atts[1].(map[string]interface{})
+	if second["text"] != "Load is back to normal" {
+		t.Errorf("text of the second alert = %q, want the summary", second["text"])
+	}
+}
+
+func TestTemplatesMissingFields(t *testing.T) {
+	// Missing status and alerts must not fail upper, title nor len.
+	msg, err := renderKind(t, "alertmanager", "", `{"alerts": [{"labels": {}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg["text"] != ": 1 alert(s)" {
+		t.Errorf("text = %q", msg["text"])
+	}
+	if _, err := renderKind(t, "alertmanager", "", `{}`); err != nil {
+		t.Fatal(err)
+	}
+	msg, err = renderKind(t, "github", "push", `{"repository": {"full_name": "acme/app"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg["text"].(string), "pushed 0 commit(s)") {
+		t.Errorf("text = %q", msg["text"])
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{`{{truncate 5 "hello world"}}`, "hell…"},
+		{`{{truncate 20 "hello"}}`, "hello"},
+		{`{{truncate 1 "hello"}}`, "…"},
+		{`{{truncate 0 "hello"}}`, ""},
+		{`{{truncate -3 "hello"}}`, ""},
+		{`{{upper .missing}}|{{title .missing}}|{{len .missing}}`, "||0"},
+		{`{{upper "firing"}}|{{title "firing"}}|{{len "firing"}}`, "FIRING|Firing|6"},
+		{`{{title "été"}}|{{title "ßa"}}|{{title "déjà"}}`, "Été|ßa|Déjà"},
+		{`{{default "none" .missing}}`, "none"},
+	}
+	for _, tt := range tests {
+		tmpl, err := template.New("test").Funcs(funcs).Parse(tt.tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		if err := tmpl.Execute(&buf, map[string]interface{}{}); err != nil {
+			t.Errorf("%s: %v", tt.tmpl, err)
+			continue
+		}
+		if got := buf.String(); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,379 @@
+// Package rtm connects bots to the Real Time Messaging API: it starts
+// a session, reads the events of the websocket and sends messages.
+package rtm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/cassette"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/slackenv"
+	"github.com/gorilla/websocket"
+)
+
+// Start is the answer of rtm.start.
+type Start struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+	URL   string `json:"url"`
+	Self  struct {
+		ID string `json:"id"`
+	} `json:"self"`
+	Team struct {
+		ID     string `json:"id"`
+		Domain string `json:"domain,omitempty"`
+	} `json:"team"`
+}
+
+// Message is a message received or sent over RTM.
+type Message struct {
+	ID      int             `json:"id"`
+	Type    string          `json:"type"`
+	Channel string          `json:"channel"`
+	Team    string          `json:"team,omitempty"`
+	User    string          `json:"user,omitempty"`
+	Text    string          `json:"text"`
+	Blocks  blockkit.Blocks `json:"blocks,omitempty"`
+	// TS identifies a message, ThreadTS the thread it belongs to.
+	TS       string `json:"ts,omitempty"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+	// ReplyBroadcast also shows a reply in the channel.
+	ReplyBroadcast bool `json:"reply_broadcast,omitempty"`
+	// Subtype and BotID tell messages sent by integrations, like
+	// "bot_message".
+	Subtype string `json:"subtype,omitempty"`
+	BotID   string `json:"bot_id,omitempty"`
+}
+
+// Client talks to Slack on behalf of a bot.
+type Client struct {
+	Token string
+	Slack *slackenv.Config
+	HTTP  *http.Client
+
+	mu   sync.Mutex
+	conn *Conn
+}
+
+// NewClient creates a client using token.
+func NewClient(token string, slack *slackenv.Config) *Client {
+	return &Client{Token: token, Slack: slack, HTTP: metrics.NewClient(0)}
+}
+
+// Start starts a session.
+func (c *Client) Start() (*Start, error) {
+	resp, err := c.HTTP.Get(c.Slack.API("rtm.start") + "?token=" + url.QueryEscape(c.Token))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rtm: unexpected status code: %d", resp.StatusCode)
+	}
+	start := &Start{}
+	err = json.NewDecoder(resp.Body).Decode(start)
+	if err != nil {
+		return nil, err
+	}
+	if !start.Ok {
+		return nil, fmt.Errorf("rtm: rtm.start failed: %s", start.Error)
+	}
+	return start, nil
+}
+
+// Connect starts a session and opens its websocket.
+func (c *Client) Connect() (*Start, *Conn, error) {
+	start, err := c.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := dial(start.URL, start.Team.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	return start, conn, nil
+}
+
+// Ready fails until Slack said hello on the websocket of the last
+// session, or when the websocket is closed.
+func (c *Client) Ready() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	switch {
+	case conn == nil:
+		return errors.New("rtm: not connected")
+	case atomic.LoadInt32(&conn.closed) != 0:
+		return errors.New("rtm: connection closed")
+	case atomic.LoadInt32(&conn.hello) == 0:
+		return errors.New("rtm: waiting for hello")
+	}
+	return nil
+}
+
+type apiResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// RateLimitError is returned when Slack rate limits a call. RetryAfter
+// is the delay it asked for, zero if it didn't say.
+type RateLimitError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s rate limited, retry after %v", e.Method, e.RetryAfter)
+}
+
+// retryAfter reads a Retry-After header, given in seconds or as an
+// HTTP date.
+func retryAfter(v string) time.Duration {
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// PostMessage sends msg with the Web API. The RTM API only handles
+// plain text, so messages with blocks go through chat.postMessage.
+func (c *Client) PostMessage(msg *Message) error {
+	err := msg.Blocks.Validate()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", c.Slack.API("chat.postMessage"), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{
+			Method:     "chat.postMessage",
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	var api apiResponse
+	err = json.NewDecoder(resp.Body).Decode(&api)
+	if err != nil {
+		return err
+	}
+	if !api.Ok {
+		return fmt.Errorf("chat.postMessage failed: %s", api.Error)
+	}
+	return nil
+}
+
+// Send replies through the websocket, or the Web API for messages
+// with blocks.
+func (c *Client) Send(conn cassette.Conn, msg *Message) error {
+	if len(msg.Blocks) > 0 {
+		return c.PostMessage(msg)
+	}
+	return conn.WriteJSON(msg)
+}
+
+// Conn is the websocket of a session. Writes may come from several
+// goroutines.
+type Conn struct {
+	mu   sync.Mutex
+	ws   *websocket.Conn
+	team string
+	// hello and closed are set atomically.
+	hello, closed int32
+}
+
+// Dial opens the websocket at url, as returned by rtm.start.
+func Dial(url string) (*Conn, error) {
+	return dial(url, "")
+}
+
+func dial(url, team string) (*Conn, error) {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	metrics.RTMConnects.Inc(team)
+	metrics.RTMConnected.Set(1, team)
+	metrics.RTMReady.Set(0, team)
+	return &Conn{ws: ws, team: team}, nil
+}
+
+// ReadMessage reads the next event.
+func (c *Conn) ReadMessage() (int, []byte, error) {
+	typ, b, err := c.ws.ReadMessage()
+	if err != nil {
+		c.setClosed()
+		return typ, b, err
+	}
+	var ev struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(b, &ev) == nil && ev.Type != "" {
+		metrics.Events.Inc(c.team, ev.Type)
+		if ev.Type == "hello" && atomic.CompareAndSwapInt32(&c.hello, 0, 1) {
+			metrics.RTMReady.Set(1, c.team)
+		}
+	}
+	return typ, b, err
+}
+
+func (c *Conn) setClosed() {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		metrics.RTMConnected.Set(0, c.team)
+		metrics.RTMReady.Set(0, c.team)
+	}
+}
+
+// WriteJSON sends v.
+func (c *Conn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteJSON(v)
+}
+
+// Close says goodbye to Slack and closes the websocket.
+func (c *Conn) Close() error {
+	c.setClosed()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	err := c.ws.WriteMessage(websocket.CloseMessage, msg)
+	if cerr := c.ws.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Recording is a session being saved to a cassette. It implements
+// cassette.Conn.
+type Recording struct {
+	cassette.Conn
+	rec *cassette.Recorder
+	f   *os.File
+}
+
+// Record saves the session to filename, to be replayed by tests.
+func Record(filename string, c cassette.Conn, start *Start) (*Recording, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	rec := cassette.NewRecorder(f)
+	// The URL of the websocket is a secret.
+	s := *start
+	s.URL = ""
+	err = rec.Start(&s)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Recording{Conn: rec.Conn(c), rec: rec, f: f}, nil
+}
+
+// RecordFromEnv records the session to the file named by the variable
+// name when it is set. It returns the connection the bot should use,
+// c when not recording, and the recording to close on shutdown.
+func RecordFromEnv(name string, c cassette.Conn, start *Start) (cassette.Conn, *Recording, error) {
+	filename := os.Getenv(name)
+	if filename == "" {
+		return c, nil, nil
+	}
+	r, err := Record(filename, c, start)
+	if err != nil {
+		return nil, nil, err
+	}
+	slog.Info("recording session", "file", filename)
+	return r, r, nil
+}
+
+// Close closes the cassette. It returns the first error met while
+// recording, frames failing to be saved don't stop the session. A nil
+// recording does nothing.
+func (r *Recording) Close() error {
+	if r == nil {
+		return nil
+	}
+	err := r.rec.Err()
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// ReadMessages passes the messages read from c to submit, until c
+// fails or submit returns an error, which drops the message. Other
+// events are skipped. Read errors are logged unless ctx is done.
+func ReadMessages(ctx context.Context, c cassette.Conn, log *slog.Logger, submit func(msg Message) error) {
+	for {
+		_, frame, err := c.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Error("fail to read message", "err", err)
+			}
+			return
+		}
+		log.Debug("frame received", "frame", strings.TrimSpace(string(frame)))
+
+		msg := Message{}
+		err = json.Unmarshal(frame, &msg)
+		if err != nil {
+			log.Error("fail to parse message", "err", err)
+			continue
+		}
+		if msg.Type != "message" {
+			continue
+		}
+		err = submit(msg)
+		if err != nil {
+			log.With(logging.Event(msg.Team, msg.Channel, msg.User, msg.Type)...).Warn("message dropped, shutting down", "err", err)
+			return
+		}
+	}
+}
+
+// Handler answers the messages of a session.
+type Handler interface {
+	// ServeRTM returns the reply to msg, nil if the bot stays silent.
+	ServeRTM(ctx context.Context, msg *Message) (*Message, error)
+}
+
+// HandlerFunc adapts a function to Handler.
+type HandlerFunc func(ctx context.Context, msg *Message) (*Message, error)
+
+// ServeRTM calls f.
+func (f HandlerFunc) ServeRTM(ctx context.Context, msg *Message) (*Message, error) {
+	return f(ctx, msg)
+}
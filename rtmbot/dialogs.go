@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aitva/slackbot/dialog"
+)
+
+const introduceFlow = "introduce"
+
+// newDialogs resumes the conversations saved in filename.
+func newDialogs(filename string) (*dialog.Manager, error) {
+	m, err := dialog.NewManager(dialog.NewFileStore(filename))
+	if err != nil {
+		return nil, err
+	}
+	m.Register(&dialog.Flow{
+		Name: introduceFlow,
+		Steps: []dialog.Step{
+			dialog.Ask("name", "Hello! What's your name?", func(name string, st *dialog.State) error {
+				if name == "" {
+					return errors.New("I didn't get it.")
+				}
+				return nil
+			}),
+		},
+		Done: func(ctx context.Context, st *dialog.State) (string, error) {
+			return fmt.Sprintf("Nice to meet you, %s!", st.Answers["name"]), nil
+		},
+	})
+	return m, nil
+}
@@ -1,107 +1,173 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"context"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/aitva/slackbot/dialog"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/middleware"
+	"github.com/aitva/slackbot/outbox"
+	"github.com/aitva/slackbot/pool"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/slackenv"
 )
 
-type rtmResponse struct {
-	Ok    bool   `json:"ok"`
-	Error string `json:"error"`
-	URL   string `json:"url"`
-	Self  struct {
-		ID string `json:"id"`
-	} `json:"self"`
+var global struct {
+	RTM     *rtm.Client
+	Dialogs *dialog.Manager
+	Outbox  *outbox.Queue
 }
 
-type rtmMsg struct {
-	ID      int    `json:"id"`
-	Type    string `json:"type"`
-	Channel string `json:"channel"`
-	Text    string `json:"text"`
+// answer says hello, or carries on the introduce conversation.
+func answer(ctx context.Context, req *rtm.Message) (*rtm.Message, error) {
+	log := slog.With(logging.Event(req.Team, req.Channel, req.User, req.Type)...)
+	text := "Hello!"
+	key := dialog.Key{Team: req.Team, Channel: req.Channel, User: req.User, Thread: req.ThreadTS}
+	reply, ok, err := global.Dialogs.Handle(ctx, key, req.Text)
+	switch {
+	case err != nil:
+		log.ErrorContext(ctx, "fail to continue dialog", "err", err)
+	case ok:
+		text = reply
+	case strings.EqualFold(strings.TrimSpace(req.Text), "introduce"):
+		text, err = global.Dialogs.Start(ctx, key, introduceFlow, nil)
+		if err != nil {
+			log.ErrorContext(ctx, "fail to start dialog", "err", err)
+			text = "Hello!"
+		}
+	}
+	return &rtm.Message{
+		Type:    "message",
+		Text:    text,
+		Channel: req.Channel,
+		// Stay in the thread of messages sent in a thread.
+		ThreadTS: req.ThreadTS,
+	}, nil
 }
 
-func fatal(isOK bool, a ...interface{}) {
-	if !isOK {
-		return
+// writeRTM returns the handler of the workers: it answers a message,
+// the reply going through global.Outbox.
+func writeRTM() func(ctx context.Context, req *rtm.Message) {
+	h := middleware.Chain(rtm.HandlerFunc(answer),
+		middleware.RequestID(),
+		middleware.Log(slog.Default()),
+		middleware.Recover(),
+		middleware.IgnoreBots(),
+	)
+	return func(ctx context.Context, req *rtm.Message) {
+		resp, err := h.ServeRTM(ctx, req)
+		if err != nil {
+			slog.Error("fail to handle message", "err", err)
+			return
+		}
+		if resp == nil {
+			return
+		}
+		err = global.Outbox.Send(resp)
+		if err != nil {
+			slog.Warn("reply not sent", "channel", resp.Channel, "err", err)
+		}
 	}
-	fmt.Println(a...)
-	os.Exit(1)
 }
 
 func main() {
+	err := logging.Setup("rtmbot")
+	logging.Fatal(err != nil, "fail to set up logs:", err)
 	token := os.Getenv("TOKEN")
-	fatal(token == "", "Variable TOKEN must be defined.")
-
-	fmt.Println("Starting RTM service...")
-	url := fmt.Sprintf("https://slack.com/api/rtm.start?token=%s", token)
+	logging.Fatal(token == "", "Variable TOKEN must be defined.")
+	slack, err := slackenv.Load()
+	logging.Fatal(err != nil, "fail to load Slack config:", err)
+	global.RTM = rtm.NewClient(token, slack)
+	drainTimeout := 10 * time.Second
+	if s := os.Getenv("DRAIN_TIMEOUT"); s != "" {
+		drainTimeout, err = time.ParseDuration(s)
+		logging.Fatal(err != nil, "invalid DRAIN_TIMEOUT:", err)
+	}
+	policy, err := outbox.ParsePolicy(os.Getenv("OUTBOX_POLICY"))
+	logging.Fatal(err != nil, "invalid OUTBOX_POLICY:", err)
+	workers, err := pool.FromEnv(writeRTM())
+	logging.Fatal(err != nil, "fail to configure workers:", err)
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9101"
+	}
+	go func() {
+		err := metrics.ListenAndServe(adminAddr, global.RTM.Ready)
+		logging.Fatal(err != nil, "fail to serve admin endpoints:", err)
+	}()
 
-	resp, err := http.Get(url)
-	fatal(err != nil, "fail to reach server:", err)
-	fatal(resp.StatusCode != http.StatusOK, "unexpected status code:", resp.StatusCode)
+	filename := os.Getenv("RTMBOT_DIALOGS")
+	if filename == "" {
+		filename = "rtmbot-dialogs.json"
+	}
+	global.Dialogs, err = newDialogs(filename)
+	logging.Fatal(err != nil, "fail to load dialogs:", err)
 
-	var rtm rtmResponse
-	err = json.NewDecoder(resp.Body).Decode(&rtm)
-	fatal(err != nil, "fail to parse response:", err)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	slog.Info("connecting to RTM service")
+	start, c, err := global.RTM.Connect()
+	logging.Fatal(err != nil, "connection fail:", err)
 
-	fmt.Println("Connecting to RTM service...")
-	c, _, err := websocket.DefaultDialer.Dial(rtm.URL, nil)
-	fatal(err != nil, "connection fail:", err)
-	defer c.Close()
+	conn, rec, err := rtm.RecordFromEnv("RTMBOT_RECORD", c, start)
+	logging.Fatal(err != nil, "fail to record session:", err)
+	// Replies wait in the outbox for the rate limits of Slack; message
+	// IDs follow the order they are sent in.
+	id := 0
+	global.Outbox = outbox.New(func(msg *rtm.Message) error {
+		msg.ID = id
+		id++
+		return global.RTM.Send(conn, msg)
+	})
+	global.Outbox.Policy = policy
+	go global.Outbox.Run()
 
-	channels := make(chan rtmMsg)
+	// Signals stop the intake, then the messages submitted to the
+	// workers and the replies waiting get drainTimeout to be sent
+	// before the dialogs are saved and the socket closed. A lost
+	// connection does the same.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	hctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	workers.Start(hctx)
 	go func() {
-		for {
-			_, message, err := c.ReadMessage()
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "fail to read message:", err)
-				return
-			}
-			fmt.Println(string(message))
-
-			msg := rtmMsg{}
-			err = json.Unmarshal(message, &msg)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "fail to parse message:", err)
-				return
-			}
-			if msg.Type != "message" {
-				continue
-			}
-			channels <- msg
-		}
+		workers.Read(ctx, conn, slog.Default())
+		stop()
 	}()
 
+	select {
+	case sig := <-interrupt:
+		slog.Info("shutting down", "signal", sig)
+	case <-ctx.Done():
+		slog.Warn("RTM connection lost, shutting down")
+	}
+	stop()
+	done := make(chan struct{})
 	go func() {
-		id := 0
-		for {
-			req := <-channels
-			resp := rtmMsg{
-				ID:      id,
-				Type:    "message",
-				Text:    "Hello!",
-				Channel: req.Channel,
-			}
-
-			err := c.WriteJSON(&resp)
-			if err != nil {
-				fmt.Println("fail to send message:", err)
-				return
-			}
-			id++
-		}
+		workers.Close()
+		close(done)
 	}()
-
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	<-interrupt
-	fmt.Println("Closing RTM connection...")
-	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-	err = c.WriteMessage(websocket.CloseMessage, msg)
-	fatal(err != nil, "fail to close socker:", err)
+	dctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	pool.Drain(dctx, done, abort)
+	if err := global.Outbox.Close(dctx); err != nil {
+		slog.Error("fail to send replies", "err", err)
+	}
+	if err := global.Dialogs.Flush(); err != nil {
+		slog.Error("fail to save dialogs", "err", err)
+	}
+	if err := rec.Close(); err != nil {
+		slog.Error("fail to record session", "err", err)
+	}
+	slog.Info("closing RTM connection")
+	err = c.Close()
+	logging.Fatal(err != nil, "fail to close socket:", err)
 }
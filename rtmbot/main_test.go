@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/slacktest"
+)
+
+func TestMain(m *testing.M) {
+	slacktest.RunMain(main)
+	os.Exit(m.Run())
+}
+
+// startBot runs rtmbot against s, saving its dialogs to dialogs.
+func startBot(t *testing.T, s *slacktest.Server, dialogs string) *slacktest.Process {
+	t.Helper()
+	p := slacktest.StartMain(t, []string{
+		"TOKEN=" + slacktest.BotToken,
+		"SLACK_BASE_URL=" + s.URL,
+		"ADMIN_ADDR=127.0.0.1:0",
+		"RTMBOT_DIALOGS=" + dialogs,
+	})
+	if err := s.WaitConnected(5 * time.Second); err != nil {
+		t.Fatal("rtmbot did not connect:", err)
+	}
+	return p
+}
+
+// stopBot shuts p down and checks it exits cleanly.
+func stopBot(t *testing.T, s *slacktest.Server, p *slacktest.Process) {
+	t.Helper()
+	if code, err := p.Stop(5 * time.Second); err != nil || code != 0 {
+		t.Fatalf("exit status %d, %v", code, err)
+	}
+	if err := s.WaitDisconnected(5 * time.Second); err != nil {
+		t.Fatal("RTM connection left open:", err)
+	}
+}
+
+// converse sends text as U1 and checks the answer of the bot.
+func converse(t *testing.T, s *slacktest.Server, text, want string) {
+	t.Helper()
+	s.SendMessage(slacktest.General, "U1", text)
+	msg, err := s.WaitMessage(5 * time.Second)
+	if err != nil {
+		t.Fatalf("no answer to %q: %v", text, err)
+	}
+	if msg.Text != want || msg.Channel != slacktest.General || msg.Via != slacktest.ViaRTM {
+		t.Errorf("answer to %q = %+v, want %q", text, msg, want)
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser("U1", "alice")
+	p := startBot(t, s, filepath.Join(t.TempDir(), "dialogs.json"))
+
+	converse(t, s, "hi", "Hello!")
+	converse(t, s, "introduce", "Hello! What's your name?")
+	converse(t, s, "Alice", "Nice to meet you, Alice!")
+	converse(t, s, "hi", "Hello!")
+	stopBot(t, s, p)
+}
+
+func TestEndToEndResumesDialogs(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser("U1", "alice")
+	dialogs := filepath.Join(t.TempDir(), "dialogs.json")
+
+	// A conversation started before a restart goes on after it.
+	p := startBot(t, s, dialogs)
+	converse(t, s, "introduce", "Hello! What's your name?")
+	stopBot(t, s, p)
+	p = startBot(t, s, dialogs)
+	converse(t, s, "Bob", "Nice to meet you, Bob!")
+	stopBot(t, s, p)
+}
+
+func TestEndToEndRecord(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser("U0ALICE01", "alice")
+	filename := filepath.Join(t.TempDir(), "session.jsonl")
+	p := slacktest.StartMain(t, []string{
+		"TOKEN=" + slacktest.BotToken,
+		"SLACK_BASE_URL=" + s.URL,
+		"ADMIN_ADDR=127.0.0.1:0",
+		"RTMBOT_DIALOGS=" + filepath.Join(t.TempDir(), "dialogs.json"),
+		"RTMBOT_RECORD=" + filename,
+	})
+	if err := s.WaitConnected(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	s.SendMessage(slacktest.General, "U0ALICE01", "hi")
+	if _, err := s.WaitMessage(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+	stopBot(t, s, p)
+
+	// The cassette is complete once rtmbot is stopped, without the ID
+	// of the user, and replays.
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "U0ALICE01") || strings.Contains(string(b), slacktest.BotToken) {
+		t.Errorf("cassette not redacted:\n%s", b)
+	}
+	replay(t, filename)
+}
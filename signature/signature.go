@@ -0,0 +1,76 @@
+// Package signature verifies that HTTP requests come from Slack,
+// using the signing secret of the app.
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MaxAge is how old a request may be, to prevent replays.
+const MaxAge = 5 * time.Minute
+
+// MaxBodySize bounds the size of the requests read by Verify.
+const MaxBodySize = 1 << 20
+
+var (
+	ErrMissing = errors.New("signature: missing signature headers")
+	ErrExpired = errors.New("signature: request too old")
+	ErrInvalid = errors.New("signature: invalid signature")
+)
+
+// Sign computes the X-Slack-Signature of a body sent at timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reads the body of r and checks its X-Slack-Signature. On
+// success, it returns the body and leaves a copy in r.Body so forms
+// can still be parsed.
+func Verify(r *http.Request, secret string) ([]byte, error) {
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	sig := r.Header.Get("X-Slack-Signature")
+	if timestamp == "" || sig == "" {
+		return nil, ErrMissing
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	age := time.Since(time.Unix(secs, 0))
+	if age > MaxAge || age < -MaxAge {
+		return nil, ErrExpired
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if !hmac.Equal([]byte(sig), []byte(Sign(secret, timestamp, body))) {
+		return nil, ErrInvalid
+	}
+	return body, nil
+}
+
+// Handler only lets through requests signed with secret.
+func Handler(secret string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Verify(r, secret); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,120 @@
+// Package skill is the registry of the features hosted by the slackbot
+// binary. A skill registers a factory from an init function, the
+// binary creates it for each workspace enabling it in its config:
+//
+//	func init() {
+//		skill.Register("hello", func(env *skill.Env) (*skill.Skill, error) {
+//			return &skill.Skill{
+//				Commands: map[string]command.Handler{"hello": hello},
+//				Help:     "*hello*\nsay hello",
+//			}, nil
+//		})
+//	}
+package skill
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/dialog"
+	"github.com/aitva/slackbot/rtm"
+)
+
+// Skill is a feature of the bot in a workspace.
+type Skill struct {
+	// Commands are the commands answered by the skill, by name.
+	Commands map[string]command.Handler
+	// Help describes the commands, in markdown.
+	Help string
+	// Flush saves the state of the skill when the bot stops, if not
+	// nil.
+	Flush func() error
+}
+
+// Env is what a skill gets from the workspace it runs in.
+type Env struct {
+	// Workspace is the name of the workspace in the config.
+	Workspace string
+	// RTM posts messages as the bot.
+	RTM *rtm.Client
+	// Dialogs holds the conversations of the workspace, skills
+	// register their flows there.
+	Dialogs *dialog.Manager
+	// Settings are the settings of the skill in the config.
+	Settings map[string]string
+}
+
+// Setting returns a setting of the skill, def if unset.
+func (e *Env) Setting(name, def string) string {
+	if v, ok := e.Settings[name]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Factory creates a skill for a workspace.
+type Factory func(env *Env) (*Skill, error)
+
+var (
+	mu        sync.Mutex
+	factories = make(map[string]Factory)
+	// optIn are the skills left out of the default config.
+	optIn = make(map[string]bool)
+)
+
+// Register makes a skill available under name. It panics if the name
+// is taken.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic("skill: Register called twice for " + name)
+	}
+	factories[name] = f
+}
+
+// RegisterOptIn is Register for a skill that runs only in the
+// workspaces naming it in their config, like one needing credentials.
+func RegisterOptIn(name string, f Factory) {
+	Register(name, f)
+	mu.Lock()
+	optIn[name] = true
+	mu.Unlock()
+}
+
+// Names returns the registered skills, sorted.
+func Names() []string {
+	return names(true)
+}
+
+// Defaults returns the skills run without config, sorted: every
+// registered skill but the opt-in ones.
+func Defaults() []string {
+	return names(false)
+}
+
+func names(withOptIn bool) []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range factories {
+		if withOptIn || !optIn[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the skill registered under name.
+func New(name string, env *Env) (*Skill, error) {
+	mu.Lock()
+	f, ok := factories[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("skill: unknown skill %q", name)
+	}
+	return f(env)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aitva/slackbot/agenda"
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/skill"
+)
+
+// The calendar skill lists the upcoming events of a Google Calendar.
+// The "client_secret" setting names the OAuth client of the app, and
+// "token" the token calbot saved when it was granted access. It runs
+// only where the config enables it.
+func init() {
+	skill.RegisterOptIn("calendar", func(env *skill.Env) (*skill.Skill, error) {
+		conf, err := agenda.Config(env.Setting("client_secret", "client_secret.json"))
+		if err != nil {
+			return nil, err
+		}
+		filename := env.Setting("token", "")
+		if filename == "" {
+			filename, err = agenda.TokenFile()
+			if err != nil {
+				return nil, err
+			}
+		}
+		srv, err := agenda.NewService(context.Background(), conf, filename)
+		if err != nil {
+			return nil, err
+		}
+		return &skill.Skill{
+			Commands: map[string]command.Handler{
+				"agenda": agenda.Handler(srv, nil),
+			},
+			Help: "*agenda [n]*\nlist the next events of the calendar",
+		}, nil
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/aitva/slackbot/middleware"
+	"github.com/aitva/slackbot/outbox"
+	"github.com/aitva/slackbot/skill"
+)
+
+// workspaceConfig tells which skills run in a workspace.
+type workspaceConfig struct {
+	Name string `json:"name"`
+	// TokenEnv is the environment variable holding the bot token,
+	// TOKEN by default.
+	TokenEnv string `json:"token_env"`
+	// Skills are the skills of the workspace, with their settings.
+	Skills map[string]map[string]string `json:"skills"`
+	// Channels restrict the skills of some channels, by channel ID.
+	// The other channels get every skill of the workspace.
+	Channels map[string][]string `json:"channels"`
+	// Dialogs is the file saving the conversations in flight.
+	Dialogs string `json:"dialogs"`
+	// Middleware filters the messages of the workspace,
+	// SkillMiddleware the commands of a skill.
+	Middleware      *middlewareConfig            `json:"middleware"`
+	SkillMiddleware map[string]*middlewareConfig `json:"skill_middleware"`
+	// Outbox is what becomes of the replies to a channel too far
+	// behind the rate limits of Slack: coalesce (the default) or
+	// drop.
+	Outbox string `json:"outbox"`
+
+	policy outbox.Policy
+}
+
+// middlewareConfig configures the middlewares in front of a
+// workspace or a skill.
+type middlewareConfig struct {
+	// AllowBots lets bots and integrations talk to the bot.
+	AllowBots bool `json:"allow_bots"`
+	// RateLimit is the number of commands a user can send per
+	// RatePeriod, a minute by default.
+	RateLimit  int    `json:"rate_limit"`
+	RatePeriod string `json:"rate_period"`
+	// Empty allow lists allow everything not denied.
+	AllowChannels []string `json:"allow_channels"`
+	DenyChannels  []string `json:"deny_channels"`
+	AllowUsers    []string `json:"allow_users"`
+	DenyUsers     []string `json:"deny_users"`
+
+	period time.Duration
+}
+
+// check validates the config.
+func (c *middlewareConfig) check() error {
+	if c.RateLimit < 0 {
+		return fmt.Errorf("negative rate_limit")
+	}
+	c.period = time.Minute
+	if c.RatePeriod != "" {
+		d, err := time.ParseDuration(c.RatePeriod)
+		if err != nil {
+			return fmt.Errorf("rate_period: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("rate_period must be positive")
+		}
+		c.period = d
+	}
+	return nil
+}
+
+// filters returns the middlewares dropping messages.
+func (c *middlewareConfig) filters() []middleware.Middleware {
+	var mws []middleware.Middleware
+	if c == nil {
+		return []middleware.Middleware{middleware.IgnoreBots()}
+	}
+	if !c.AllowBots {
+		mws = append(mws, middleware.IgnoreBots())
+	}
+	if len(c.AllowChannels) > 0 || len(c.DenyChannels) > 0 {
+		mws = append(mws, middleware.Channels(c.AllowChannels, c.DenyChannels))
+	}
+	if len(c.AllowUsers) > 0 || len(c.DenyUsers) > 0 {
+		mws = append(mws, middleware.Users(c.AllowUsers, c.DenyUsers))
+	}
+	return mws
+}
+
+// limits returns the middlewares limiting commands.
+func (c *middlewareConfig) limits() []middleware.Middleware {
+	if c == nil || c.RateLimit == 0 {
+		return nil
+	}
+	return []middleware.Middleware{middleware.RateLimit(c.RateLimit, c.period)}
+}
+
+type config struct {
+	Workspaces []*workspaceConfig `json:"workspaces"`
+}
+
+// defaultConfig runs every skill but the opt-in ones in the workspace
+// of TOKEN.
+func defaultConfig() *config {
+	ws := &workspaceConfig{Name: "default", Skills: make(map[string]map[string]string), policy: outbox.Coalesce}
+	for _, name := range skill.Defaults() {
+		ws.Skills[name] = nil
+	}
+	return &config{Workspaces: []*workspaceConfig{ws}}
+}
+
+// loadConfig reads the config from filename, the default one if the
+// file doesn't exist.
+func loadConfig(filename string) (*config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return defaultConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	conf := &config{}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, err
+	}
+	if len(conf.Workspaces) == 0 {
+		return nil, fmt.Errorf("%s: no workspace", filename)
+	}
+	known := make(map[string]bool)
+	for _, name := range skill.Names() {
+		known[name] = true
+	}
+	names := make(map[string]bool)
+	for i, ws := range conf.Workspaces {
+		if ws == nil {
+			return nil, fmt.Errorf("workspace %d: empty", i)
+		}
+		if ws.Name == "" {
+			return nil, fmt.Errorf("workspace %d: missing name", i)
+		}
+		if names[ws.Name] {
+			return nil, fmt.Errorf("workspace %s: defined twice", ws.Name)
+		}
+		names[ws.Name] = true
+		for name := range ws.Skills {
+			if !known[name] {
+				return nil, fmt.Errorf("workspace %s: unknown skill %q", ws.Name, name)
+			}
+		}
+		if ws.Middleware != nil {
+			if err := ws.Middleware.check(); err != nil {
+				return nil, fmt.Errorf("workspace %s: middleware: %v", ws.Name, err)
+			}
+		}
+		for name, mw := range ws.SkillMiddleware {
+			if _, ok := ws.Skills[name]; !ok {
+				return nil, fmt.Errorf("workspace %s: middleware of skill %q, which is not enabled", ws.Name, name)
+			}
+			if mw == nil {
+				return nil, fmt.Errorf("workspace %s: middleware of skill %s: empty", ws.Name, name)
+			}
+			if err := mw.check(); err != nil {
+				return nil, fmt.Errorf("workspace %s: middleware of skill %s: %v", ws.Name, name, err)
+			}
+		}
+		ws.policy, err = outbox.ParsePolicy(ws.Outbox)
+		if err != nil {
+			return nil, fmt.Errorf("workspace %s: %v", ws.Name, err)
+		}
+		for channel, skills := range ws.Channels {
+			for _, name := range skills {
+				if _, ok := ws.Skills[name]; !ok {
+					return nil, fmt.Errorf("workspace %s: channel %s: skill %q is not enabled in the workspace", ws.Name, channel, name)
+				}
+			}
+		}
+	}
+	return conf, nil
+}
+
+// token returns the bot token of the workspace.
+func (c *workspaceConfig) token() string {
+	env := c.TokenEnv
+	if env == "" {
+		env = "TOKEN"
+	}
+	return os.Getenv(env)
+}
+
+// enabled tells if a skill answers in channel.
+func (c *workspaceConfig) enabled(channel, name string) bool {
+	skills, ok := c.Channels[channel]
+	if !ok {
+		_, ok := c.Skills[name]
+		return ok
+	}
+	for _, s := range skills {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aitva/slackbot/slackenv"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		doc, err string
+	}{
+		{`{"workspaces": [{"name": "acme", "skills": {"hello": null}}]}`, ""},
+		{`{"workspaces": []}`, "no workspace"},
+		{`{"workspaces": [null]}`, "workspace 0: empty"},
+		{`{"workspaces": [{"name": "acme", "skills": {"weather": {}}}]}`, `unknown skill "weather"`},
+		{`{"workspaces": [{"name": "acme", "skills": {"calendar": {"client_secret": "secret.json"}}}]}`, ""},
+		{
+			`{"workspaces": [{"name": "acme", "skills": {"hello": {}}, "skill_middleware": {"hello": null}}]}`,
+			"middleware of skill hello: empty",
+		},
+		{
+			`{"workspaces": [{"name": "acme", "skills": {"hello": {}}, "skill_middleware": {"timer": {}}}]}`,
+			`middleware of skill "timer", which is not enabled`,
+		},
+	}
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "slackbot.json")
+		if err := os.WriteFile(filename, []byte(tt.doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadConfig(filename)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("loadConfig(%s) = %v", tt.doc, err)
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("loadConfig(%s) = %v, want %q", tt.doc, err, tt.err)
+		}
+	}
+}
+
+func TestDefaultConfig(t *testing.T) {
+	skills := defaultConfig().Workspaces[0].Skills
+	for _, name := range []string{"hello", "timer"} {
+		if _, ok := skills[name]; !ok {
+			t.Errorf("skill %s not run by default", name)
+		}
+	}
+	// The calendar needs credentials.
+	if _, ok := skills["calendar"]; ok {
+		t.Error("skill calendar run by default")
+	}
+}
+
+func TestCalendarSkill(t *testing.T) {
+	t.Setenv("TOKEN", "xoxb-test")
+	dir := t.TempDir()
+	conf := &workspaceConfig{
+		Name:    "acme",
+		Dialogs: filepath.Join(dir, "dialogs.json"),
+		Skills: map[string]map[string]string{
+			"calendar": {"client_secret": filepath.Join(dir, "secret.json"), "token": filepath.Join(dir, "token.json")},
+		},
+	}
+	if _, err := newWorkspace(conf, slackenv.Default()); err == nil {
+		t.Fatal("calendar skill created without client secret")
+	}
+
+	secret := `{"installed": {"client_id": "id", "client_secret": "secret", "auth_uri": "https://accounts/auth", "token_uri": "https://accounts/token", "redirect_uris": ["urn:ietf:wg:oauth:2.0:oob"]}}`
+	if err := os.WriteFile(conf.Skills["calendar"]["client_secret"], []byte(secret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newWorkspace(conf, slackenv.Default()); err == nil || !strings.Contains(err.Error(), "run calbot") {
+		t.Fatalf("calendar skill without token: %v", err)
+	}
+
+	if err := os.WriteFile(conf.Skills["calendar"]["token"], []byte(`{"access_token": "ya29.test"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWorkspace(conf, slackenv.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.owners["agenda"] != "calendar" {
+		t.Errorf("agenda answered by %q", w.owners["agenda"])
+	}
+}
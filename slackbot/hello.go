@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/dialog"
+	"github.com/aitva/slackbot/skill"
+)
+
+const introduceFlow = "introduce"
+
+// The hello skill greets users, and asks their name on introduce.
+func init() {
+	skill.Register("hello", func(env *skill.Env) (*skill.Skill, error) {
+		env.Dialogs.Register(&dialog.Flow{
+			Name: introduceFlow,
+			Steps: []dialog.Step{
+				dialog.Ask("name", "Hello! What's your name?", func(name string, st *dialog.State) error {
+					if name == "" {
+						return errors.New("I didn't get it.")
+					}
+					return nil
+				}),
+			},
+			Done: func(ctx context.Context, st *dialog.State) (string, error) {
+				return fmt.Sprintf("Nice to meet you, %s!", st.Answers["name"]), nil
+			},
+		})
+		introduce := func(ctx context.Context, req *command.Request) (*command.Response, error) {
+			key := dialog.Key{Team: req.Team, Channel: req.Channel, User: req.User, Thread: req.ThreadTS}
+			text, err := env.Dialogs.Start(ctx, key, introduceFlow, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &command.Response{Text: text}, nil
+		}
+		return &skill.Skill{
+			Commands: map[string]command.Handler{
+				"hello":     reply("Hello!"),
+				"bye":       reply("Bye!"),
+				"introduce": command.HandlerFunc(introduce),
+			},
+			Help: "*hello*, *bye*\nsay hello or goodbye\n*introduce*\ntell me your name",
+		}, nil
+	})
+}
+
+// reply answers a command with text.
+func reply(text string) command.HandlerFunc {
+	return func(ctx context.Context, req *command.Request) (*command.Response, error) {
+		return &command.Response{Text: text, InChannel: true}, nil
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/pool"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/slackenv"
+)
+
+func main() {
+	err := logging.Setup("slackbot")
+	logging.Fatal(err != nil, "fail to set up logs:", err)
+	slack, err := slackenv.Load()
+	logging.Fatal(err != nil, "fail to load Slack config:", err)
+
+	filename := os.Getenv("SLACKBOT_CONFIG")
+	if filename == "" {
+		filename = "slackbot.json"
+	}
+	conf, err := loadConfig(filename)
+	logging.Fatal(err != nil, "fail to load config:", err)
+	drainTimeout := 10 * time.Second
+	if s := os.Getenv("DRAIN_TIMEOUT"); s != "" {
+		drainTimeout, err = time.ParseDuration(s)
+		logging.Fatal(err != nil, "invalid DRAIN_TIMEOUT:", err)
+	}
+
+	var workspaces []*workspace
+	for _, wc := range conf.Workspaces {
+		w, err := newWorkspace(wc, slack)
+		logging.Fatal(err != nil, "fail to load workspace:", err)
+		workspaces = append(workspaces, w)
+	}
+
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9103"
+	}
+	go func() {
+		err := metrics.ListenAndServe(adminAddr, func() error {
+			for _, w := range workspaces {
+				if err := w.rtm.Ready(); err != nil {
+					return fmt.Errorf("workspace %s: %v", w.conf.Name, err)
+				}
+			}
+			return nil
+		})
+		logging.Fatal(err != nil, "fail to serve admin endpoints:", err)
+	}()
+
+	// Signals stop the intake, then the messages submitted to the
+	// workers and the replies waiting get drainTimeout to be sent
+	// before the state is saved and the sockets closed. The bot stops
+	// as well as soon as a connection is lost, like rtmbot and
+	// timerbot, for its supervisor to restart it.
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	hctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	// A signal sent while connecting waits for the select below.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	lost := make(chan string, len(workspaces))
+	var conns []*rtm.Conn
+	for _, w := range workspaces {
+		slog.Info("connecting to workspace", "workspace", w.conf.Name)
+		c, err := w.connect()
+		logging.Fatal(err != nil, "connection fail:", err)
+		conns = append(conns, c)
+		// Commands run with hctx, which outlives ctx so the messages
+		// submitted are answered.
+		w.workers.Start(hctx)
+		go func(w *workspace, c *rtm.Conn) {
+			w.run(ctx, c)
+			lost <- w.conf.Name
+		}(w, c)
+	}
+
+	select {
+	case sig := <-interrupt:
+		slog.Info("shutting down", "signal", sig)
+	case name := <-lost:
+		slog.Warn("RTM connection lost, shutting down", "workspace", name)
+	}
+	stop()
+	done := make(chan struct{})
+	go func() {
+		for _, w := range workspaces {
+			w.workers.Close()
+		}
+		close(done)
+	}()
+	dctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	pool.Drain(dctx, done, abort)
+	for _, w := range workspaces {
+		if err := w.outbox.Close(dctx); err != nil {
+			w.log.Error("fail to send replies", "err", err)
+		}
+		if err := w.flush(); err != nil {
+			w.log.Error("fail to save state", "err", err)
+		}
+	}
+	slog.Info("closing RTM connections")
+	for _, c := range conns {
+		err := c.Close()
+		if err != nil {
+			slog.Error("fail to close socket", "err", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/slacktest"
+)
+
+func TestMain(m *testing.M) {
+	slacktest.RunMain(main)
+	os.Exit(m.Run())
+}
+
+func TestConnectionLost(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	dir := t.TempDir()
+	conf := `{"workspaces": [
+		{"name": "acme", "skills": {"hello": {}}, "dialogs": "` + filepath.Join(dir, "acme.json") + `"},
+		{"name": "beta", "token_env": "BETA_TOKEN", "skills": {"hello": {}}, "dialogs": "` + filepath.Join(dir, "beta.json") + `"}
+	]}`
+	filename := filepath.Join(dir, "slackbot.json")
+	if err := os.WriteFile(filename, []byte(conf), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := slacktest.StartMain(t, []string{
+		"TOKEN=" + slacktest.BotToken,
+		"BETA_TOKEN=" + slacktest.BotToken,
+		"SLACK_BASE_URL=" + s.URL,
+		"ADMIN_ADDR=127.0.0.1:0",
+		"SLACKBOT_CONFIG=" + filename,
+	})
+	if err := s.WaitConnections(2, 5*time.Second); err != nil {
+		t.Fatal("slackbot did not connect both workspaces:", err)
+	}
+
+	// One workspace losing its connection stops the bot, which closes
+	// the other one.
+	s.Disconnect()
+	if code, err := p.Wait(5 * time.Second); err != nil || code != 0 {
+		t.Fatalf("exit status %d, %v", code, err)
+	}
+	if err := s.WaitDisconnected(5 * time.Second); err != nil {
+		t.Fatal("RTM connection left open:", err)
+	}
+	if out := p.Output(); !strings.Contains(out, "RTM connection lost") {
+		t.Errorf("output:\n%s", out)
+	}
+}
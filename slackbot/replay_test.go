@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aitva/slackbot/cassette"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/slackenv"
+)
+
+// replay plays the cassette in filename to a workspace running every
+// skill, and checks slackbot answers like during the recording.
+func replay(t *testing.T, filename string) {
+	p, err := cassette.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One worker answers the messages in the order they came.
+	t.Setenv("TOKEN", "xoxb-replay")
+	t.Setenv("WORKERS", "1")
+	dir := t.TempDir()
+	conf := defaultConfig().Workspaces[0]
+	conf.Dialogs = filepath.Join(dir, "dialogs.json")
+	conf.Skills["timer"] = map[string]string{"data": filepath.Join(dir, "timers.json")}
+	w, err := newWorkspace(conf, slackenv.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.start = &rtm.Start{}
+	if err := p.Start(w.start); err != nil {
+		t.Fatal(err)
+	}
+	w.conn = p
+	// Replies leave at once, in order.
+	w.outbox.PerChannel, w.outbox.Global = 0, 0
+	go w.outbox.Run()
+
+	ctx, stop := context.WithCancel(context.Background())
+	w.workers.Start(ctx)
+	done := make(chan struct{})
+	go func() {
+		w.run(ctx, p)
+		close(done)
+	}()
+	if err := p.Wait(); err != nil {
+		t.Error(err)
+	}
+	stop()
+	<-done
+	w.workers.Close()
+	if err := w.outbox.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no cassette in testdata")
+	}
+	for _, filename := range files {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			replay(t, filename)
+		})
+	}
+}
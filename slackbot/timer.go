@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/skill"
+	"github.com/aitva/slackbot/timer"
+)
+
+// The timer skill tracks the time spent on projects. The "data"
+// setting names the file of the timers.
+func init() {
+	skill.Register("timer", func(env *skill.Env) (*skill.Skill, error) {
+		store, err := timer.Load(env.Setting("data", env.Workspace+"-timers.json"))
+		if err != nil {
+			return nil, err
+		}
+		env.Dialogs.Register(timer.NewProjectAddFlow(store))
+		return &skill.Skill{
+			Commands: map[string]command.Handler{
+				"timer": &timer.Handler{Store: store, Dialogs: env.Dialogs},
+			},
+			Help: "*timer start [project]*, *timer stop*\nstart or stop a timer\n" +
+				"*timer status*\nshow the timer and weekly totals\n" +
+				"*timer projects*, *timer project add <name>*\nlist or add projects",
+			Flush: store.Flush,
+		}, nil
+	})
+}
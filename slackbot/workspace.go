@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/cassette"
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/dialog"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/middleware"
+	"github.com/aitva/slackbot/outbox"
+	"github.com/aitva/slackbot/pool"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/skill"
+	"github.com/aitva/slackbot/slackenv"
+)
+
+// workspace is the bot connected to a workspace. Every skill of the
+// workspace shares its connection.
+type workspace struct {
+	conf    *workspaceConfig
+	rtm     *rtm.Client
+	start   *rtm.Start
+	dialogs *dialog.Manager
+	router  *command.Router
+	skills  map[string]*skill.Skill
+	// owners gives the skill of each command.
+	owners map[string]string
+	// handler answers every message, commands the commands, and
+	// skillHandlers the commands of each skill.
+	handler       rtm.Handler
+	commands      rtm.Handler
+	skillHandlers map[string]rtm.Handler
+	// workers answer the messages, outbox sends the replies on conn,
+	// numbered by id.
+	workers *pool.Pool
+	outbox  *outbox.Queue
+	conn    cassette.Conn
+	log     *slog.Logger
+	id      int
+}
+
+// newWorkspace creates the skills of a workspace.
+func newWorkspace(conf *workspaceConfig, slack *slackenv.Config) (*workspace, error) {
+	token := conf.token()
+	if token == "" {
+		return nil, fmt.Errorf("workspace %s: missing token", conf.Name)
+	}
+	filename := conf.Dialogs
+	if filename == "" {
+		filename = conf.Name + "-dialogs.json"
+	}
+	dialogs, err := dialog.NewManager(dialog.NewFileStore(filename))
+	if err != nil {
+		return nil, err
+	}
+	w := &workspace{
+		conf:    conf,
+		rtm:     rtm.NewClient(token, slack),
+		dialogs: dialogs,
+		router:  command.NewRouter(10 * time.Second),
+		skills:  make(map[string]*skill.Skill),
+		owners:  make(map[string]string),
+
+		skillHandlers: make(map[string]rtm.Handler),
+		log:           slog.With("workspace", conf.Name),
+	}
+	var names []string
+	for name := range conf.Skills {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s, err := skill.New(name, &skill.Env{
+			Workspace: conf.Name,
+			RTM:       w.rtm,
+			Dialogs:   dialogs,
+			Settings:  conf.Skills[name],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("workspace %s: skill %s: %v", conf.Name, name, err)
+		}
+		for cmd, h := range s.Commands {
+			if cmd == "help" {
+				return nil, fmt.Errorf("workspace %s: skill %s: command help is reserved", conf.Name, name)
+			}
+			if owner, ok := w.owners[cmd]; ok {
+				return nil, fmt.Errorf("workspace %s: skill %s: command %s is taken by %s", conf.Name, name, cmd, owner)
+			}
+			w.owners[cmd] = name
+			w.router.Handle(cmd, h)
+		}
+		w.skills[name] = s
+		mw := conf.SkillMiddleware[name]
+		var mws []middleware.Middleware
+		if mw != nil {
+			mws = append(mws, mw.filters()...)
+			mws = append(mws, mw.limits()...)
+		}
+		mws = append(mws, middleware.Recover(), middleware.Timing(w.observe(name)))
+		w.skillHandlers[name] = middleware.Chain(rtm.HandlerFunc(w.runCommand), mws...)
+	}
+	mws := []middleware.Middleware{
+		middleware.RequestID(),
+		middleware.Log(w.log),
+		middleware.Recover(),
+	}
+	mws = append(mws, conf.Middleware.filters()...)
+	w.handler = middleware.Chain(rtm.HandlerFunc(w.serve), mws...)
+	w.commands = middleware.Chain(rtm.HandlerFunc(w.dispatch), conf.Middleware.limits()...)
+	w.workers, err = pool.FromEnv(w.reply)
+	if err != nil {
+		return nil, err
+	}
+	w.workers.Name = "rtm:" + conf.Name
+	w.outbox = outbox.New(w.send)
+	w.outbox.Policy = conf.policy
+	w.outbox.Log = w.log
+	return w, nil
+}
+
+// connect opens the connection of the workspace, and starts sending
+// the replies on it.
+func (w *workspace) connect() (*rtm.Conn, error) {
+	start, c, err := w.rtm.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("workspace %s: %v", w.conf.Name, err)
+	}
+	w.start = start
+	w.conn = c
+	go w.outbox.Run()
+	return c, nil
+}
+
+// send writes msg on the connection. Only the outbox calls it.
+func (w *workspace) send(msg *rtm.Message) error {
+	msg.ID = w.id
+	w.id++
+	return w.rtm.Send(w.conn, msg)
+}
+
+// run passes the messages read from c to the workers, started
+// beforehand, until ctx is done or c fails.
+func (w *workspace) run(ctx context.Context, c cassette.Conn) {
+	go w.expireDialogs(ctx)
+	rtm.ReadMessages(ctx, c, w.log, func(msg rtm.Message) error {
+		// Even when bots are allowed, the bot doesn't answer itself.
+		if msg.User == w.start.Self.ID {
+			return nil
+		}
+		if msg.Team == "" {
+			msg.Team = w.start.Team.ID
+		}
+		return w.workers.Submit(ctx, msg)
+	})
+}
+
+// reply answers msg on a worker, the reply going through the outbox.
+func (w *workspace) reply(ctx context.Context, msg *rtm.Message) {
+	resp, err := w.handler.ServeRTM(ctx, msg)
+	if err != nil {
+		w.log.Error("fail to handle message", "err", err)
+		resp = &rtm.Message{
+			Type:     "message",
+			Channel:  msg.Channel,
+			ThreadTS: msg.ThreadTS,
+			Text:     "Sorry, something went wrong.",
+		}
+	}
+	if resp == nil {
+		return
+	}
+	err = w.outbox.Send(resp)
+	if err != nil {
+		w.log.Warn("reply not sent", "channel", resp.Channel, "err", err)
+	}
+}
+
+// flush saves the conversations and the state of the skills.
+func (w *workspace) flush() error {
+	err := w.dialogs.Flush()
+	for _, name := range w.skillNames() {
+		s := w.skills[name]
+		if s.Flush == nil {
+			continue
+		}
+		if ferr := s.Flush(); ferr != nil && err == nil {
+			err = fmt.Errorf("skill %s: %v", name, ferr)
+		}
+	}
+	return err
+}
+
+// serve continues the conversations, and passes the commands sent to
+// the bot to w.commands, with the mention trimmed from the text.
+func (w *workspace) serve(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+	botname := "<@" + w.start.Self.ID + ">"
+	// Accept "@bot: cmd" as well as "@bot cmd".
+	text := strings.TrimPrefix(msg.Text, botname)
+	text = strings.TrimSpace(strings.TrimPrefix(text, ":"))
+
+	key := dialog.Key{Team: msg.Team, Channel: msg.Channel, User: msg.User, Thread: msg.ThreadTS}
+	reply, ok, err := w.dialogs.Handle(ctx, key, text)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("fail to continue dialog: %v", err)
+	case ok:
+		// Answers of a conversation don't need a mention, and stay
+		// where the conversation happens.
+		return respond(msg, &command.Response{Text: reply}), nil
+	case !strings.HasPrefix(msg.Text, botname) && !strings.HasPrefix(msg.Channel, "D"):
+		// Commands are sent with a mention, or in a direct message.
+		return nil, nil
+	}
+	cmd := *msg
+	cmd.Text = text
+	return w.commands.ServeRTM(ctx, &cmd)
+}
+
+// dispatch passes the command in msg to the skill answering it in the
+// channel.
+func (w *workspace) dispatch(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+	name, _, _ := command.Parse(msg.Text)
+	if name == "" {
+		return nil, nil
+	}
+	if name == "help" {
+		return respond(msg, w.help(msg.Channel)), nil
+	}
+	owner, ok := w.owners[name]
+	if !ok || !w.conf.enabled(msg.Channel, owner) {
+		return respond(msg, &command.Response{Text: fmt.Sprintf("I don't know `%s` here, say `help`.", name)}), nil
+	}
+	return w.skillHandlers[owner].ServeRTM(ctx, msg)
+}
+
+// runCommand runs the command in msg.
+func (w *workspace) runCommand(ctx context.Context, msg *rtm.Message) (*rtm.Message, error) {
+	name, args, rest := command.Parse(msg.Text)
+	out, err := w.router.Dispatch(ctx, &command.Request{
+		Name:     name,
+		Args:     args,
+		Text:     rest,
+		Source:   command.RTM,
+		Team:     msg.Team,
+		Channel:  msg.Channel,
+		User:     msg.User,
+		TS:       msg.TS,
+		ThreadTS: msg.ThreadTS,
+	})
+	if err != nil {
+		w.log.With(logging.Event(msg.Team, msg.Channel, msg.User, msg.Type)...).ErrorContext(ctx, "fail to run command", "text", msg.Text, "err", err)
+		out = &command.Response{Text: "Sorry, the command failed."}
+	}
+	return respond(msg, out), nil
+}
+
+// observe reports the commands of a skill slower than a few seconds.
+func (w *workspace) observe(name string) func(time.Duration, error) {
+	return func(d time.Duration, err error) {
+		if d > 2*time.Second {
+			w.log.Warn("slow command", "skill", name, "duration", d)
+		}
+	}
+}
+
+// respond turns the response of a command into a reply to msg.
+func respond(msg *rtm.Message, out *command.Response) *rtm.Message {
+	if out == nil {
+		return nil
+	}
+	resp := &rtm.Message{
+		Type:     "message",
+		Channel:  msg.Channel,
+		Text:     out.Text,
+		Blocks:   out.Blocks,
+		ThreadTS: msg.ThreadTS,
+	}
+	if resp.ThreadTS == "" && out.Thread {
+		resp.ThreadTS = msg.TS
+	}
+	return resp
+}
+
+// help lists the commands of the skills enabled in channel.
+func (w *workspace) help(channel string) *command.Response {
+	var names []string
+	blocks := blockkit.Blocks{&blockkit.Header{Text: blockkit.Plain("Help")}}
+	for _, name := range w.skillNames() {
+		if !w.conf.enabled(channel, name) {
+			continue
+		}
+		names = append(names, name)
+		if help := w.skills[name].Help; help != "" {
+			blocks = append(blocks, &blockkit.Section{Text: blockkit.Markdown(help)})
+		}
+	}
+	if len(names) == 0 {
+		return &command.Response{Text: "Nothing to do in this channel."}
+	}
+	return &command.Response{
+		Text:   "Skills of this channel: " + strings.Join(names, ", ") + ".",
+		Blocks: blocks,
+	}
+}
+
+func (w *workspace) skillNames() []string {
+	var names []string
+	for name := range w.skills {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expireDialogs tells users when the bot stops waiting for their
+// answer, until ctx is done.
+func (w *workspace) expireDialogs(ctx context.Context) {
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		expired, err := w.dialogs.Expire()
+		if err != nil {
+			w.log.Error("fail to save dialogs", "err", err)
+		}
+		for _, st := range expired {
+			err := w.outbox.Send(&rtm.Message{
+				Type:     "message",
+				Channel:  st.Key.Channel,
+				ThreadTS: st.Key.Thread,
+				Text:     "I stopped waiting for an answer, start again when you're ready.",
+			})
+			if err != nil {
+				w.log.With(logging.Event(st.Key.Team, st.Key.Channel, st.Key.User, "")...).Error("fail to send message", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+// Package slackenv tells the bots where Slack is: the Web API, the
+// incoming webhooks and the OAuth and OpenID Connect endpoints.
+//
+// The config starts from a preset, "slack" or "govslack", or from the
+// base URL of a stand-in like slacktest, where every endpoint lives
+// under one host. A JSON file, then environment variables and flags
+// override single URLs:
+//
+//	SLACK_ENV         preset, "slack" by default
+//	SLACK_BASE_URL    base URL of a stand-in, replaces the preset
+//	SLACK_CONFIG      JSON file with the fields of Config
+//	SLACK_API_URL, SLACK_WEBHOOK_URL, SLACK_AUTHORIZE_URL,
+//	SLACK_AUTHORIZE_V1_URL, SLACK_OIDC_AUTHORIZE_URL, SLACK_JWKS_URL,
+//	SLACK_ISSUER      single URLs
+package slackenv
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Config holds the URLs of Slack.
+type Config struct {
+	// APIURL is the base URL of the Web API, like
+	// https://slack.com/api/.
+	APIURL string `json:"api_url"`
+	// WebhookURL is the base URL of incoming webhooks, like
+	// https://hooks.slack.com/services/.
+	WebhookURL string `json:"webhook_url"`
+	// AuthorizeURL is the consent page of OAuth v2, AuthorizeV1URL the
+	// one of the original OAuth flow.
+	AuthorizeURL   string `json:"authorize_url"`
+	AuthorizeV1URL string `json:"authorize_v1_url"`
+	// OIDCAuthorizeURL and JWKSURL are used by "Sign in with Slack",
+	// whose ID tokens are issued by Issuer.
+	OIDCAuthorizeURL string `json:"oidc_authorize_url"`
+	JWKSURL          string `json:"jwks_url"`
+	Issuer           string `json:"issuer"`
+}
+
+// presets are the Slack deployments, by domain.
+var presets = map[string]string{
+	"slack":    "slack.com",
+	"govslack": "slack-gov.com",
+}
+
+// Preset returns the config of a Slack deployment, "slack" or
+// "govslack".
+func Preset(name string) (*Config, error) {
+	domain, ok := presets[name]
+	if !ok {
+		return nil, fmt.Errorf("slackenv: unknown environment %q", name)
+	}
+	base := "https://" + domain
+	return &Config{
+		APIURL:           base + "/api/",
+		WebhookURL:       "https://hooks." + domain + "/services/",
+		AuthorizeURL:     base + "/oauth/v2/authorize",
+		AuthorizeV1URL:   base + "/oauth/authorize",
+		OIDCAuthorizeURL: base + "/openid/connect/authorize",
+		JWKSURL:          base + "/openid/connect/keys",
+		Issuer:           base,
+	}, nil
+}
+
+// ForBaseURL returns the config of a stand-in serving every endpoint
+// under base, like slacktest.
+func ForBaseURL(base string) *Config {
+	base = strings.TrimSuffix(base, "/")
+	return &Config{
+		APIURL:           base + "/api/",
+		WebhookURL:       base + "/services/",
+		AuthorizeURL:     base + "/oauth/v2/authorize",
+		AuthorizeV1URL:   base + "/oauth/authorize",
+		OIDCAuthorizeURL: base + "/openid/connect/authorize",
+		JWKSURL:          base + "/openid/connect/keys",
+		Issuer:           base,
+	}
+}
+
+// Default is the config of slack.com.
+func Default() *Config {
+	c, _ := Preset("slack")
+	return c
+}
+
+// API returns the URL of a Web API method.
+func (c *Config) API(method string) string {
+	return c.APIURL + method
+}
+
+// TokenURL returns the URL exchanging OAuth v2 codes.
+func (c *Config) TokenURL() string {
+	return c.API("oauth.v2.access")
+}
+
+// TokenV1URL returns the URL exchanging codes of the original flow.
+func (c *Config) TokenV1URL() string {
+	return c.API("oauth.access")
+}
+
+// OIDCTokenURL returns the URL exchanging OpenID Connect codes.
+func (c *Config) OIDCTokenURL() string {
+	return c.API("openid.connect.token")
+}
+
+// Webhook returns the URL of an incoming webhook from its path, as
+// found after /services/ in the URLs Slack gives.
+func (c *Config) Webhook(path string) string {
+	return c.WebhookURL + strings.TrimPrefix(path, "/")
+}
+
+// Validate checks every URL is absolute. Plain HTTP is only accepted
+// for stand-ins on the loopback interface.
+func (c *Config) Validate() error {
+	urls := []struct {
+		name, value string
+		dir         bool
+	}{
+		{"api_url", c.APIURL, true},
+		{"webhook_url", c.WebhookURL, true},
+		{"authorize_url", c.AuthorizeURL, false},
+		{"authorize_v1_url", c.AuthorizeV1URL, false},
+		{"oidc_authorize_url", c.OIDCAuthorizeURL, false},
+		{"jwks_url", c.JWKSURL, false},
+		{"issuer", c.Issuer, false},
+	}
+	for _, u := range urls {
+		if err := checkURL(u.value, u.dir); err != nil {
+			return fmt.Errorf("slackenv: %s: %v", u.name, err)
+		}
+	}
+	return nil
+}
+
+// CheckURL checks s is an absolute URL using https, or plain HTTP on
+// the loopback interface, like the URLs of a config.
+func CheckURL(s string) error {
+	if err := checkURL(s, false); err != nil {
+		return fmt.Errorf("slackenv: %v", err)
+	}
+	return nil
+}
+
+func checkURL(s string, dir bool) error {
+	if s == "" {
+		return fmt.Errorf("missing")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q is not an absolute URL", s)
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if !isLoopback(u.Hostname()) {
+			return fmt.Errorf("%q must use https", s)
+		}
+	default:
+		return fmt.Errorf("%q: unexpected scheme %q", s, u.Scheme)
+	}
+	if dir && !strings.HasSuffix(u.Path, "/") {
+		return fmt.Errorf("%q must end with /", s)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Source tells where to read the config from.
+type Source struct {
+	Env     string
+	BaseURL string
+	File    string
+}
+
+// SourceFromEnv reads SLACK_ENV, SLACK_BASE_URL and SLACK_CONFIG.
+func SourceFromEnv() *Source {
+	return &Source{
+		Env:     os.Getenv("SLACK_ENV"),
+		BaseURL: os.Getenv("SLACK_BASE_URL"),
+		File:    os.Getenv("SLACK_CONFIG"),
+	}
+}
+
+// AddFlags lets -slack-env, -slack-url and -slack-config override the
+// source.
+func (s *Source) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.Env, "slack-env", s.Env, "Slack deployment: slack or govslack")
+	fs.StringVar(&s.BaseURL, "slack-url", s.BaseURL, "base URL of a stand-in Slack, like a local fake")
+	fs.StringVar(&s.File, "slack-config", s.File, "JSON file with the URLs of Slack")
+}
+
+// overrides are the environment variables overriding single URLs.
+var overrides = []struct {
+	env   string
+	field func(c *Config) *string
+}{
+	{"SLACK_API_URL", func(c *Config) *string { return &c.APIURL }},
+	{"SLACK_WEBHOOK_URL", func(c *Config) *string { return &c.WebhookURL }},
+	{"SLACK_AUTHORIZE_URL", func(c *Config) *string { return &c.AuthorizeURL }},
+	{"SLACK_AUTHORIZE_V1_URL", func(c *Config) *string { return &c.AuthorizeV1URL }},
+	{"SLACK_OIDC_AUTHORIZE_URL", func(c *Config) *string { return &c.OIDCAuthorizeURL }},
+	{"SLACK_JWKS_URL", func(c *Config) *string { return &c.JWKSURL }},
+	{"SLACK_ISSUER", func(c *Config) *string { return &c.Issuer }},
+}
+
+// Load builds the config and validates it.
+func (s *Source) Load() (*Config, error) {
+	var c *Config
+	if s.BaseURL != "" {
+		c = ForBaseURL(s.BaseURL)
+	} else {
+		env := s.Env
+		if env == "" {
+			env = "slack"
+		}
+		var err error
+		c, err = Preset(env)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if s.File != "" {
+		b, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return nil, err
+		}
+		// Fields missing from the file keep their value.
+		if err := json.Unmarshal(b, c); err != nil {
+			return nil, fmt.Errorf("slackenv: %s: %v", s.File, err)
+		}
+	}
+	for _, o := range overrides {
+		if v := os.Getenv(o.env); v != "" {
+			*o.field(c) = v
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads the config from the environment.
+func Load() (*Config, error) {
+	return SourceFromEnv().Load()
+}
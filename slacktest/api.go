@@ -0,0 +1,392 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// params are the arguments of a Web API call, sent as a form or as
+// JSON.
+type params map[string]interface{}
+
+func (p params) String(key string) string {
+	switch v := p[key].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// Raw returns a parameter holding JSON, sent either as JSON or as an
+// encoded string in a form.
+func (p params) Raw(key string) json.RawMessage {
+	switch v := p[key].(type) {
+	case nil:
+		return nil
+	case string:
+		if v == "" {
+			return nil
+		}
+		return json.RawMessage(v)
+	default:
+		b, _ := json.Marshal(v)
+		return b
+	}
+}
+
+func (p params) Bool(key string) bool {
+	b, _ := strconv.ParseBool(p.String(key))
+	return b
+}
+
+func readParams(r *http.Request) (params, error) {
+	p := make(params)
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if ct == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	for k := range r.Form {
+		if _, ok := p[k]; !ok {
+			p[k] = r.Form.Get(k)
+		}
+	}
+	// OAuth clients may send their credentials with basic auth.
+	if id, secret, ok := r.BasicAuth(); ok {
+		p["client_id"], p["client_secret"] = id, secret
+	}
+	return p, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus answers v with status. Headers must be set before
+// the status is written.
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(err string) map[string]interface{} {
+	return map[string]interface{}{"ok": false, "error": err}
+}
+
+// noAuth lists the methods callable without token.
+var noAuth = map[string]bool{
+	"api.test":        true,
+	"oauth.access":    true,
+	"oauth.v2.access": true,
+	// Clients authenticate with their ID and secret.
+	"openid.connect.token": true,
+}
+
+// handleAPI serves /api/{method}.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	p, err := readParams(r)
+	if err != nil {
+		writeJSON(w, apiError("invalid_arguments"))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[method]++
+	if f := s.failure(method); f != nil {
+		status := http.StatusOK
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(f.retryAfter))
+			status = http.StatusTooManyRequests
+		}
+		writeJSONStatus(w, status, apiError(f.err))
+		return
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = p.String("token")
+	}
+	if !noAuth[method] {
+		if token == "" {
+			writeJSON(w, apiError("not_authed"))
+			return
+		}
+		if token != BotToken && token != UserToken {
+			writeJSON(w, apiError("invalid_auth"))
+			return
+		}
+	}
+
+	h, ok := s.methods()[method]
+	if !ok {
+		writeJSON(w, apiError("unknown_method"))
+		return
+	}
+	writeJSON(w, h(token, p))
+}
+
+type apiMethod func(token string, p params) interface{}
+
+// methods returns the Web API methods. Handlers run with s.mu held.
+func (s *Server) methods() map[string]apiMethod {
+	return map[string]apiMethod{
+		"api.test":                s.apiTest,
+		"auth.test":               s.authTest,
+		"auth.revoke":             s.authRevoke,
+		"rtm.start":               s.rtmStart,
+		"rtm.connect":             s.rtmStart,
+		"chat.postMessage":        s.chatPostMessage,
+		"chat.postEphemeral":      s.chatPostEphemeral,
+		"chat.update":             s.chatUpdate,
+		"conversations.history":   s.conversationsHistory,
+		"conversations.replies":   s.conversationsReplies,
+		"conversations.list":      s.conversationsList,
+		"users.list":              s.usersList,
+		"users.info":              s.usersInfo,
+		"views.open":              s.viewsMethod("views.open"),
+		"views.push":              s.viewsMethod("views.push"),
+		"views.update":            s.viewsMethod("views.update"),
+		"views.publish":           s.viewsMethod("views.publish"),
+		"oauth.access":            s.oauthAccess,
+		"oauth.v2.access":         s.oauthV2Access,
+		"users.profile.get":       s.usersProfileGet,
+		"openid.connect.token":    s.openIDToken,
+		"openid.connect.userInfo": s.openIDUserInfo,
+	}
+}
+
+func (s *Server) apiTest(token string, p params) interface{} {
+	return map[string]interface{}{"ok": true, "args": p}
+}
+
+func (s *Server) authTest(token string, p params) interface{} {
+	user := BotUserID
+	if token == UserToken {
+		user = InstallerID
+	}
+	return map[string]interface{}{
+		"ok":      true,
+		"url":     "https://" + TeamDomain + ".slack.com/",
+		"team":    TeamName,
+		"user":    BotName,
+		"team_id": TeamID,
+		"user_id": user,
+		"bot_id":  BotID,
+	}
+}
+
+func (s *Server) authRevoke(token string, p params) interface{} {
+	return map[string]interface{}{"ok": true, "revoked": true}
+}
+
+func (s *Server) rtmStart(token string, p params) interface{} {
+	var users []*User
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	var channels []*Channel
+	for _, c := range s.channels {
+		channels = append(channels, c)
+	}
+	return map[string]interface{}{
+		"ok":       true,
+		"url":      "ws" + strings.TrimPrefix(s.URL, "http") + "/rtm",
+		"self":     map[string]string{"id": BotUserID, "name": BotName},
+		"team":     map[string]string{"id": TeamID, "name": TeamName, "domain": TeamDomain},
+		"users":    users,
+		"channels": channels,
+	}
+}
+
+// postedMessage reads the message of chat.postMessage and friends.
+// Messages posted with the user token come from the installer.
+func (s *Server) postedMessage(token string, p params) (*Message, string) {
+	channel := p.String("channel")
+	if id, ok := s.channelByName(channel); ok {
+		channel = id
+	}
+	channel, ok := s.knownChannel(channel)
+	if !ok {
+		return nil, "channel_not_found"
+	}
+	m := &Message{
+		Channel:        channel,
+		User:           BotUserID,
+		Text:           p.String("text"),
+		ThreadTS:       p.String("thread_ts"),
+		ReplyBroadcast: p.Bool("reply_broadcast"),
+		Blocks:         p.Raw("blocks"),
+		Attachments:    p.Raw("attachments"),
+		Via:            ViaAPI,
+	}
+	if token == UserToken {
+		m.User = InstallerID
+	}
+	if m.Text == "" && len(m.Blocks) == 0 && len(m.Attachments) == 0 {
+		return nil, "no_text"
+	}
+	return m, ""
+}
+
+func (s *Server) chatPostMessage(token string, p params) interface{} {
+	m, err := s.postedMessage(token, p)
+	if err != "" {
+		return apiError(err)
+	}
+	s.record(m)
+	return map[string]interface{}{"ok": true, "channel": m.Channel, "ts": m.TS, "message": m}
+}
+
+func (s *Server) chatPostEphemeral(token string, p params) interface{} {
+	m, err := s.postedMessage(token, p)
+	if err != "" {
+		return apiError(err)
+	}
+	if _, ok := s.users[p.String("user")]; !ok {
+		return apiError("user_not_in_channel")
+	}
+	m.Ephemeral = true
+	m.User = p.String("user")
+	s.record(m)
+	return map[string]interface{}{"ok": true, "message_ts": m.TS}
+}
+
+func (s *Server) chatUpdate(token string, p params) interface{} {
+	ts := p.String("ts")
+	for _, m := range s.history {
+		if m.Channel == p.String("channel") && m.TS == ts {
+			m.Text = p.String("text")
+			if b := p.Raw("blocks"); b != nil {
+				m.Blocks = b
+			}
+			return map[string]interface{}{"ok": true, "channel": m.Channel, "ts": ts, "text": m.Text}
+		}
+	}
+	return apiError("message_not_found")
+}
+
+func (s *Server) conversationsHistory(token string, p params) interface{} {
+	channel := p.String("channel")
+	if _, ok := s.channels[channel]; !ok {
+		return apiError("channel_not_found")
+	}
+	msgs := []*Message{}
+	for i := len(s.history) - 1; i >= 0; i-- {
+		m := s.history[i]
+		if m.Channel == channel && !m.Ephemeral && (m.ThreadTS == "" || m.ReplyBroadcast) {
+			msgs = append(msgs, m)
+		}
+	}
+	return map[string]interface{}{"ok": true, "messages": msgs, "has_more": false}
+}
+
+func (s *Server) conversationsReplies(token string, p params) interface{} {
+	channel, ts := p.String("channel"), p.String("ts")
+	msgs := []*Message{}
+	for _, m := range s.history {
+		if m.Channel == channel && !m.Ephemeral && (m.TS == ts || m.ThreadTS == ts) {
+			msgs = append(msgs, m)
+		}
+	}
+	if len(msgs) == 0 {
+		return apiError("thread_not_found")
+	}
+	return map[string]interface{}{"ok": true, "messages": msgs, "has_more": false}
+}
+
+func (s *Server) conversationsList(token string, p params) interface{} {
+	channels := []*Channel{}
+	for _, c := range s.channels {
+		if !c.IsIM {
+			channels = append(channels, c)
+		}
+	}
+	return map[string]interface{}{"ok": true, "channels": channels}
+}
+
+func (s *Server) usersList(token string, p params) interface{} {
+	users := []*User{}
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	return map[string]interface{}{"ok": true, "members": users}
+}
+
+func (s *Server) usersInfo(token string, p params) interface{} {
+	u, ok := s.users[p.String("user")]
+	if !ok {
+		return apiError("user_not_found")
+	}
+	return map[string]interface{}{"ok": true, "user": u}
+}
+
+func (s *Server) usersProfileGet(token string, p params) interface{} {
+	return map[string]interface{}{"ok": true, "profile": map[string]string{"real_name": "Installer"}}
+}
+
+func (s *Server) openIDUserInfo(token string, p params) interface{} {
+	return map[string]interface{}{
+		"ok":                        true,
+		"sub":                       InstallerID,
+		"https://slack.com/team_id": TeamID,
+		"name":                      "Installer",
+		"email":                     "installer@example.com",
+	}
+}
+
+// viewsMethod records the views sent with views.*.
+func (s *Server) viewsMethod(method string) apiMethod {
+	return func(token string, p params) interface{} {
+		v := &View{
+			Method:    method,
+			UserID:    p.String("user_id"),
+			TriggerID: p.String("trigger_id"),
+			ID:        p.String("view_id"),
+			View:      p.Raw("view"),
+		}
+		if len(v.View) == 0 {
+			return apiError("invalid_arguments")
+		}
+		if method == "views.publish" {
+			if _, ok := s.users[v.UserID]; !ok {
+				return apiError("user_not_found")
+			}
+		}
+		if (method == "views.open" || method == "views.push") && v.TriggerID == "" {
+			return apiError("invalid_trigger_id")
+		}
+		if v.ID == "" {
+			v.ID = fmt.Sprintf("V%04d", len(s.views)+1)
+		}
+		s.views = append(s.views, v)
+		close(s.changed)
+		s.changed = make(chan struct{})
+
+		var view map[string]interface{}
+		json.Unmarshal(v.View, &view)
+		if view == nil {
+			view = make(map[string]interface{})
+		}
+		view["id"] = v.ID
+		view["hash"] = fmt.Sprintf("hash-%d", len(s.views))
+		view["team_id"] = TeamID
+		return map[string]interface{}{"ok": true, "view": view}
+	}
+}
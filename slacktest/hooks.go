@@ -0,0 +1,207 @@
+package slacktest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aitva/slackbot/signature"
+)
+
+// webhookMessage is a message posted to an incoming webhook.
+type webhookMessage struct {
+	Text        string          `json:"text"`
+	ThreadTS    string          `json:"thread_ts"`
+	Blocks      json.RawMessage `json:"blocks"`
+	Attachments json.RawMessage `json:"attachments"`
+	// Only used by response URLs.
+	ResponseType    string `json:"response_type"`
+	ReplaceOriginal bool   `json:"replace_original"`
+}
+
+// handleWebhook serves the incoming webhooks created by AddWebhook,
+// answering like Slack does, in plain text.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "invalid_method", http.StatusMethodNotAllowed)
+		return
+	}
+	var msg webhookMessage
+	err := json.NewDecoder(r.Body).Decode(&msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests["webhook"]++
+	if f := s.failure("webhook"); f != nil {
+		if f.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(f.retryAfter))
+			http.Error(w, f.err, http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, f.err, http.StatusInternalServerError)
+		return
+	}
+	channel, ok := s.webhooks[r.URL.Path]
+	if !ok {
+		http.Error(w, "no_service", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+	if msg.Text == "" && len(msg.Blocks) == 0 && len(msg.Attachments) == 0 {
+		http.Error(w, "no_text", http.StatusBadRequest)
+		return
+	}
+	s.record(&Message{
+		Channel:     channel,
+		User:        BotUserID,
+		Text:        msg.Text,
+		ThreadTS:    msg.ThreadTS,
+		Blocks:      msg.Blocks,
+		Attachments: msg.Attachments,
+		Via:         ViaWebhook,
+	})
+	w.Write([]byte("ok"))
+}
+
+// responseURL returns a response_url answering in channel to user.
+// The caller must hold s.mu.
+func (s *Server) responseURL(channel, user string) string {
+	return fmt.Sprintf("%s/response/%s/%s/%s", s.URL, channel, user, s.nextTS())
+}
+
+// handleResponse serves the response URLs of slash commands and
+// interactions.
+func (s *Server) handleResponse(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/response/"), "/")
+	if r.Method != "POST" || len(parts) != 3 {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	var msg webhookMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests["response_url"]++
+	s.record(&Message{
+		Channel:     parts[0],
+		User:        parts[1],
+		Text:        msg.Text,
+		Blocks:      msg.Blocks,
+		Attachments: msg.Attachments,
+		Ephemeral:   msg.ResponseType != "in_channel",
+		Via:         ViaResponseURL,
+	})
+	w.Write([]byte("ok"))
+}
+
+// post sends body to a handler of the bot, signed with secret like
+// Slack does.
+func post(handlerURL, secret, contentType string, body []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequest("POST", handlerURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", signature.Sign(secret, ts, body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return resp, b, err
+}
+
+// Command sends a slash command to the bot handler at handlerURL and
+// returns the immediate answer, nil if the bot answered with an empty
+// body. Delayed answers are recorded like the other messages.
+func (s *Server) Command(handlerURL, secret, channel, user, command, text string) (*Message, error) {
+	s.mu.Lock()
+	form := url.Values{
+		"command":      {command},
+		"text":         {text},
+		"team_id":      {TeamID},
+		"channel_id":   {channel},
+		"user_id":      {user},
+		"trigger_id":   {"trigger-" + s.nextTS()},
+		"response_url": {s.responseURL(channel, user)},
+	}
+	s.mu.Unlock()
+
+	resp, body, err := post(handlerURL, secret, "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("slacktest: %s answered %d: %s", command, resp.StatusCode, body)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	var msg webhookMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		// Plain text answers are allowed too.
+		msg.Text = string(body)
+	}
+	m := &Message{
+		Channel:   channel,
+		User:      BotUserID,
+		Text:      msg.Text,
+		Blocks:    msg.Blocks,
+		Ephemeral: msg.ResponseType != "in_channel",
+		Via:       ViaCommand,
+	}
+	s.mu.Lock()
+	s.record(m)
+	s.mu.Unlock()
+	return m, nil
+}
+
+// Interact sends an interaction payload to the bot handler at
+// handlerURL and returns the answer. The response_url of the payload
+// is set when missing.
+func (s *Server) Interact(handlerURL, secret string, payload map[string]interface{}) ([]byte, error) {
+	s.mu.Lock()
+	if _, ok := payload["response_url"]; !ok {
+		user, channel := "", General
+		if u, ok := payload["user"].(map[string]interface{}); ok {
+			user, _ = u["id"].(string)
+		}
+		if c, ok := payload["channel"].(map[string]interface{}); ok {
+			channel, _ = c["id"].(string)
+		}
+		payload["response_url"] = s.responseURL(channel, user)
+	}
+	if _, ok := payload["trigger_id"]; !ok {
+		payload["trigger_id"] = "trigger-" + s.nextTS()
+	}
+	s.mu.Unlock()
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{"payload": {string(b)}}
+	resp, body, err := post(handlerURL, secret, "application/x-www-form-urlencoded", []byte(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("slacktest: interaction answered %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,108 @@
+package slacktest
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// mainEnv tells a test binary started by StartMain to run the bot.
+const mainEnv = "SLACKTEST_MAIN"
+
+// RunMain runs main then exits when the test binary was started by
+// StartMain, and returns otherwise. Bots call it first in TestMain so
+// their tests can run them end-to-end, in a process of their own:
+//
+//	func TestMain(m *testing.M) {
+//		slacktest.RunMain(main)
+//		os.Exit(m.Run())
+//	}
+func RunMain(main func()) {
+	if os.Getenv(mainEnv) == "" {
+		return
+	}
+	main()
+	os.Exit(0)
+}
+
+// Process is a bot started by StartMain.
+type Process struct {
+	cmd  *exec.Cmd
+	out  output
+	done chan struct{}
+}
+
+// output collects what a bot writes to stdout and stderr.
+type output struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *output) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(b)
+}
+
+func (o *output) String() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.String()
+}
+
+// StartMain starts the test binary as the bot, with args and env
+// added to the environment of the test. The bot is killed at the end
+// of the test, whose log gets the output of the bot if it failed.
+func StartMain(t testing.TB, env []string, args ...string) *Process {
+	t.Helper()
+	p := &Process{
+		cmd:  exec.Command(os.Args[0], args...),
+		done: make(chan struct{}),
+	}
+	p.cmd.Env = append(append(os.Environ(), mainEnv+"=1"), env...)
+	p.cmd.Stdout = &p.out
+	p.cmd.Stderr = &p.out
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		p.cmd.Wait()
+		close(p.done)
+	}()
+	t.Cleanup(func() {
+		p.cmd.Process.Kill()
+		<-p.done
+		if t.Failed() {
+			t.Logf("output of the bot:\n%s", p.Output())
+		}
+	})
+	return p
+}
+
+// Wait waits for the bot to exit and returns its exit status.
+func (p *Process) Wait(timeout time.Duration) (int, error) {
+	select {
+	case <-p.done:
+		return p.cmd.ProcessState.ExitCode(), nil
+	case <-time.After(timeout):
+		return -1, ErrTimeout
+	}
+}
+
+// Stop asks the bot to shut down with SIGTERM and waits for it to
+// exit.
+func (p *Process) Stop(timeout time.Duration) (int, error) {
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return -1, err
+	}
+	return p.Wait(timeout)
+}
+
+// Output returns what the bot wrote to stdout and stderr so far.
+func (p *Process) Output() string {
+	return p.out.String()
+}
@@ -0,0 +1,139 @@
+package slacktest
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// grant is what an authorization code was issued for.
+type grant struct {
+	Scope     string
+	UserScope string
+	// Nonce is put in the ID token of "Sign in with Slack".
+	Nonce string
+}
+
+// handleAuthorize grants every OAuth request, redirecting to the
+// redirect_uri with a code for oauth.access, oauth.v2.access or
+// openid.connect.token.
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != ClientID {
+		http.Error(w, "invalid_client_id", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" {
+		http.Error(w, "bad_redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	code := "code-" + s.nextTS()
+	s.codes[code] = &grant{Scope: q.Get("scope"), UserScope: q.Get("user_scope"), Nonce: q.Get("nonce")}
+	s.mu.Unlock()
+
+	v := redirect.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirect.RawQuery = v.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// redeem checks the client credentials and consumes the code. The
+// caller must hold s.mu.
+func (s *Server) redeem(p params) (*grant, string) {
+	if p.String("client_id") != ClientID || p.String("client_secret") != ClientSecret {
+		return nil, "invalid_client_id"
+	}
+	g, ok := s.codes[p.String("code")]
+	if !ok {
+		return nil, "invalid_code"
+	}
+	delete(s.codes, p.String("code"))
+	return g, ""
+}
+
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.FieldsFunc(scopes, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// incomingWebhook creates the webhook granted by the incoming-webhook
+// scope. The caller must hold s.mu.
+func (s *Server) incomingWebhook() map[string]string {
+	path := "/services/" + TeamID + "/BOAUTH/" + s.nextTS()
+	s.webhooks[path] = General
+	return map[string]string{
+		"channel":           "#general",
+		"channel_id":        General,
+		"configuration_url": "https://" + TeamDomain + ".slack.com/services/BOAUTH",
+		"url":               s.URL + path,
+	}
+}
+
+// oauthAccess exchanges a code for the tokens of the original
+// OAuth flow.
+func (s *Server) oauthAccess(token string, p params) interface{} {
+	g, err := s.redeem(p)
+	if err != "" {
+		return apiError(err)
+	}
+	v := map[string]interface{}{
+		"ok":           true,
+		"access_token": UserToken,
+		"scope":        g.Scope,
+		"user_id":      InstallerID,
+		"team_id":      TeamID,
+		"team_name":    TeamName,
+	}
+	if hasScope(g.Scope, "bot") {
+		v["bot"] = map[string]string{"bot_user_id": BotUserID, "bot_access_token": BotToken}
+	}
+	if hasScope(g.Scope, "incoming-webhook") {
+		v["incoming_webhook"] = s.incomingWebhook()
+	}
+	return v
+}
+
+// oauthV2Access exchanges a code for the tokens of OAuth v2.
+func (s *Server) oauthV2Access(token string, p params) interface{} {
+	g, err := s.redeem(p)
+	if err != "" {
+		return apiError(err)
+	}
+	v := map[string]interface{}{
+		"ok":                    true,
+		"app_id":                AppID,
+		"team":                  map[string]string{"id": TeamID, "name": TeamName},
+		"enterprise":            nil,
+		"is_enterprise_install": false,
+		"authed_user":           map[string]string{"id": InstallerID},
+	}
+	if g.Scope != "" {
+		v["access_token"] = BotToken
+		v["token_type"] = "bot"
+		v["scope"] = g.Scope
+		v["bot_user_id"] = BotUserID
+	}
+	if g.UserScope != "" {
+		v["authed_user"] = map[string]string{
+			"id":           InstallerID,
+			"scope":        g.UserScope,
+			"access_token": UserToken,
+			"token_type":   "user",
+		}
+		if g.Scope == "" {
+			v["access_token"] = UserToken
+			v["token_type"] = "user"
+		}
+	}
+	if hasScope(g.Scope, "incoming-webhook") {
+		v["incoming_webhook"] = s.incomingWebhook()
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package slacktest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// KeyID names the key ID tokens are signed with.
+const KeyID = "slacktest"
+
+// signingKey signs the ID tokens of every server, generated once as
+// it takes a while.
+var signingKey struct {
+	once sync.Once
+	key  *rsa.PrivateKey
+}
+
+func idTokenKey() *rsa.PrivateKey {
+	signingKey.once.Do(func() {
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			panic("slacktest: fail to generate signing key: " + err.Error())
+		}
+		signingKey.key = k
+	})
+	return signingKey.key
+}
+
+// handleKeys serves the JWKS document of the key signing ID tokens.
+func (s *Server) handleKeys(w http.ResponseWriter, r *http.Request) {
+	k := idTokenKey()
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": KeyID,
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}},
+	})
+}
+
+// idToken returns an ID token for the installer, signed with RS256.
+// The caller must hold s.mu.
+func (s *Server) idToken(nonce string) (string, error) {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                       s.URL,
+		"sub":                       InstallerID,
+		"aud":                       ClientID,
+		"exp":                       now.Add(time.Hour).Unix(),
+		"iat":                       now.Unix(),
+		"auth_time":                 now.Unix(),
+		"nonce":                     nonce,
+		"name":                      "Installer",
+		"email":                     "installer@example.com",
+		"https://slack.com/user_id": InstallerID,
+		"https://slack.com/team_id": TeamID,
+	}
+	enc := func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b), err
+	}
+	header, err := enc(map[string]string{"alg": "RS256", "kid": KeyID, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := enc(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + payload
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idTokenKey(), crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// openIDToken exchanges a code of "Sign in with Slack" for a user
+// token and an ID token.
+func (s *Server) openIDToken(token string, p params) interface{} {
+	g, err := s.redeem(p)
+	if err != "" {
+		return apiError(err)
+	}
+	raw, e := s.idToken(g.Nonce)
+	if e != nil {
+		return apiError("internal_error")
+	}
+	return map[string]interface{}{
+		"ok":           true,
+		"access_token": UserToken,
+		"token_type":   "Bearer",
+		"id_token":     raw,
+	}
+}
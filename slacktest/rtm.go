@@ -0,0 +1,106 @@
+package slacktest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// rtmConn serializes the writes to a websocket, events are sent by
+// tests while the reader acknowledges messages.
+type rtmConn struct {
+	mu sync.Mutex
+	c  *websocket.Conn
+}
+
+func (c *rtmConn) WriteJSON(v interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.c.WriteJSON(v)
+}
+
+func (c *rtmConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "")
+	c.c.WriteMessage(websocket.CloseMessage, msg)
+	return c.c.Close()
+}
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// rtmFrame is a frame sent by a bot.
+type rtmFrame struct {
+	ID             int             `json:"id"`
+	Type           string          `json:"type"`
+	Channel        string          `json:"channel"`
+	Text           string          `json:"text"`
+	ThreadTS       string          `json:"thread_ts"`
+	ReplyBroadcast bool            `json:"reply_broadcast"`
+	Blocks         json.RawMessage `json:"blocks"`
+}
+
+// handleRTM serves the websocket returned by rtm.start and
+// rtm.connect. It says hello, acknowledges messages and answers
+// pings.
+func (s *Server) handleRTM(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	c := &rtmConn{c: ws}
+	c.WriteJSON(map[string]string{"type": "hello"})
+	s.mu.Lock()
+	s.conns[c] = true
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		close(s.changed)
+		s.changed = make(chan struct{})
+		s.mu.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		var f rtmFrame
+		if err := ws.ReadJSON(&f); err != nil {
+			return
+		}
+		switch f.Type {
+		case "ping":
+			c.WriteJSON(map[string]interface{}{"type": "pong", "reply_to": f.ID})
+		case "message":
+			s.mu.Lock()
+			channel, ok := s.knownChannel(f.Channel)
+			if !ok {
+				s.mu.Unlock()
+				c.WriteJSON(map[string]interface{}{
+					"ok":       false,
+					"reply_to": f.ID,
+					"error":    map[string]interface{}{"code": 2, "msg": "channel_not_found"},
+				})
+				continue
+			}
+			m := &Message{
+				Channel:        channel,
+				User:           BotUserID,
+				Text:           f.Text,
+				ThreadTS:       f.ThreadTS,
+				ReplyBroadcast: f.ReplyBroadcast,
+				Blocks:         f.Blocks,
+				Via:            ViaRTM,
+			}
+			s.requests["rtm.message"]++
+			s.record(m)
+			s.mu.Unlock()
+			c.WriteJSON(map[string]interface{}{"ok": true, "reply_to": f.ID, "ts": m.TS, "text": m.Text})
+		}
+	}
+}
@@ -0,0 +1,436 @@
+// Package slacktest runs a fake Slack in process, so the bots can be
+// tested end-to-end without network access.
+//
+// The server answers the Web API, rtm.start and rtm.connect with a
+// local websocket, incoming webhooks, the OAuth flow and "Sign in
+// with Slack". Tests add
+// users and channels, send messages as users and check what the bots
+// posted:
+//
+//	s := slacktest.NewServer()
+//	defer s.Close()
+//	// Start the bot with s.APIURL() and slacktest.BotToken, then:
+//	s.WaitConnected(time.Second)
+//	s.SendMessage(slacktest.General, "U1", "<@UBOT>: hello")
+//	msg, err := s.WaitMessage(time.Second)
+package slacktest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identity of the fake workspace and its bot.
+const (
+	TeamID     = "T0FAKE"
+	TeamName   = "Fake Team"
+	TeamDomain = "fake"
+	BotUserID  = "UBOT"
+	BotName    = "bot"
+	BotID      = "B0FAKE"
+	AppID      = "A0FAKE"
+	BotToken   = "xoxb-slacktest"
+	UserToken  = "xoxp-slacktest"
+	// InstallerID is the user who installed the app, UserToken is
+	// theirs.
+	InstallerID  = "U0INSTALLER"
+	ClientID     = "1111.2222"
+	ClientSecret = "slacktest-secret"
+	General      = "C0GENERAL"
+)
+
+// Where messages come from.
+const (
+	ViaUser        = "user"
+	ViaRTM         = "rtm"
+	ViaAPI         = "api"
+	ViaWebhook     = "webhook"
+	ViaResponseURL = "response_url"
+	ViaCommand     = "command"
+)
+
+// ErrTimeout is returned when waiting for too long.
+var ErrTimeout = errors.New("slacktest: timeout")
+
+// User is a member of the workspace.
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	IsBot bool   `json:"is_bot"`
+}
+
+// Channel is a conversation of the workspace.
+type Channel struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	IsIM bool   `json:"is_im"`
+}
+
+// Message is a message sent by a user or a bot.
+type Message struct {
+	Channel        string          `json:"channel"`
+	User           string          `json:"user,omitempty"`
+	Text           string          `json:"text"`
+	TS             string          `json:"ts"`
+	ThreadTS       string          `json:"thread_ts,omitempty"`
+	ReplyBroadcast bool            `json:"reply_broadcast,omitempty"`
+	Blocks         json.RawMessage `json:"blocks,omitempty"`
+	Attachments    json.RawMessage `json:"attachments,omitempty"`
+	// Ephemeral messages are only shown to User.
+	Ephemeral bool   `json:"-"`
+	Via       string `json:"-"`
+}
+
+// View is a modal or a Home tab sent by a bot.
+type View struct {
+	Method    string
+	ID        string
+	UserID    string
+	TriggerID string
+	View      json.RawMessage
+}
+
+// failure is a scripted error of a method.
+type failure struct {
+	err        string
+	retryAfter int
+	times      int
+}
+
+// Server is a fake Slack.
+type Server struct {
+	// URL is the base URL of the server.
+	URL string
+
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	changed  chan struct{}
+	seq      int64
+	users    map[string]*User
+	channels map[string]*Channel
+	history  []*Message
+	cursor   int
+	views    []*View
+	webhooks map[string]string
+	codes    map[string]*grant
+	failures map[string]*failure
+	conns    map[*rtmConn]bool
+	requests map[string]int
+}
+
+// NewServer starts a fake Slack with the bot and a #general channel.
+func NewServer() *Server {
+	s := &Server{
+		changed:  make(chan struct{}),
+		seq:      1500000000,
+		users:    make(map[string]*User),
+		channels: make(map[string]*Channel),
+		webhooks: make(map[string]string),
+		codes:    make(map[string]*grant),
+		failures: make(map[string]*failure),
+		conns:    make(map[*rtmConn]bool),
+		requests: make(map[string]int),
+	}
+	s.users[BotUserID] = &User{ID: BotUserID, Name: BotName, IsBot: true}
+	s.channels[General] = &Channel{ID: General, Name: "general"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", s.handleAPI)
+	mux.HandleFunc("/rtm", s.handleRTM)
+	mux.HandleFunc("/services/", s.handleWebhook)
+	mux.HandleFunc("/response/", s.handleResponse)
+	mux.HandleFunc("/oauth/authorize", s.handleAuthorize)
+	mux.HandleFunc("/oauth/v2/authorize", s.handleAuthorize)
+	mux.HandleFunc("/openid/connect/authorize", s.handleAuthorize)
+	mux.HandleFunc("/openid/connect/keys", s.handleKeys)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close closes the RTM connections and stops the server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.srv.Close()
+}
+
+// APIURL returns the base URL of the Web API, with a trailing slash.
+func (s *Server) APIURL() string {
+	return s.URL + "/api/"
+}
+
+// AuthorizeURL returns the URL of the OAuth v2 consent page, which
+// grants every request.
+func (s *Server) AuthorizeURL() string {
+	return s.URL + "/oauth/v2/authorize"
+}
+
+// AddUser adds a member to the workspace and opens a direct message
+// channel with the bot, whose ID is "D" followed by the user ID.
+func (s *Server) AddUser(id, name string) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := &User{ID: id, Name: name}
+	s.users[id] = u
+	s.channels["D"+id] = &Channel{ID: "D" + id, Name: name, IsIM: true}
+	return u
+}
+
+// AddChannel adds a public channel.
+func (s *Server) AddChannel(id, name string) *Channel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &Channel{ID: id, Name: name}
+	s.channels[id] = c
+	return c
+}
+
+// AddWebhook creates an incoming webhook posting to channel.
+func (s *Server) AddWebhook(channel string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := fmt.Sprintf("/services/%s/B%04d/%s", TeamID, len(s.webhooks)+1, s.nextTS())
+	s.webhooks[path] = channel
+	return s.URL + path
+}
+
+// Fail makes the next calls of method fail with err, for times
+// calls. Webhooks are named "webhook", and answer 500.
+func (s *Server) Fail(method, err string, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = &failure{err: err, times: times}
+}
+
+// RateLimit makes the next calls of method answer 429 with a
+// Retry-After of retryAfter seconds, for times calls.
+func (s *Server) RateLimit(method string, retryAfter, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = &failure{err: "ratelimited", retryAfter: retryAfter, times: times}
+}
+
+// failure returns the scripted error of method, if any. The caller
+// must hold s.mu.
+func (s *Server) failure(method string) *failure {
+	f, ok := s.failures[method]
+	if !ok {
+		return nil
+	}
+	f.times--
+	if f.times <= 0 {
+		delete(s.failures, method)
+	}
+	return f
+}
+
+// Requests returns how many times method was called.
+func (s *Server) Requests(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method]
+}
+
+// nextTS returns a new message timestamp. The caller must hold s.mu.
+func (s *Server) nextTS() string {
+	s.seq++
+	return fmt.Sprintf("%d.000100", s.seq)
+}
+
+// knownChannel resolves a channel or a user ID to a channel ID. The
+// caller must hold s.mu.
+func (s *Server) knownChannel(id string) (string, bool) {
+	if _, ok := s.channels[id]; ok {
+		return id, true
+	}
+	if _, ok := s.users[id]; ok {
+		return "D" + id, true
+	}
+	return "", false
+}
+
+// record adds m to the history and wakes up waiters. The caller must
+// hold s.mu.
+func (s *Server) record(m *Message) {
+	if m.TS == "" {
+		m.TS = s.nextTS()
+	}
+	s.history = append(s.history, m)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// wait calls cond until it returns true or timeout expires.
+func (s *Server) wait(timeout time.Duration, cond func() bool) error {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		if cond() {
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline:
+			return ErrTimeout
+		}
+	}
+}
+
+// WaitMessage returns the next message posted by a bot, in order.
+func (s *Server) WaitMessage(timeout time.Duration) (*Message, error) {
+	var msg *Message
+	err := s.wait(timeout, func() bool {
+		for s.cursor < len(s.history) {
+			m := s.history[s.cursor]
+			s.cursor++
+			if m.Via != ViaUser {
+				msg = m
+				return true
+			}
+		}
+		return false
+	})
+	return msg, err
+}
+
+// WaitConnected waits for a bot to open an RTM connection.
+func (s *Server) WaitConnected(timeout time.Duration) error {
+	return s.wait(timeout, func() bool { return len(s.conns) > 0 })
+}
+
+// WaitConnections waits for n RTM connections to be open.
+func (s *Server) WaitConnections(n int, timeout time.Duration) error {
+	return s.wait(timeout, func() bool { return len(s.conns) >= n })
+}
+
+// Disconnect closes one of the RTM connections, as Slack does when it
+// moves a websocket to another server. It reports whether one was
+// open.
+func (s *Server) Disconnect() bool {
+	s.mu.Lock()
+	var c *rtmConn
+	for c = range s.conns {
+		break
+	}
+	s.mu.Unlock()
+	if c == nil {
+		return false
+	}
+	c.Close()
+	return true
+}
+
+// WaitDisconnected waits for the bots to close their RTM connections.
+func (s *Server) WaitDisconnected(timeout time.Duration) error {
+	return s.wait(timeout, func() bool { return len(s.conns) == 0 })
+}
+
+// Messages returns the messages of channel, oldest first.
+func (s *Server) Messages(channel string) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []*Message
+	for _, m := range s.history {
+		if m.Channel == channel {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// Posted returns every message posted by bots, oldest first.
+func (s *Server) Posted() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var msgs []*Message
+	for _, m := range s.history {
+		if m.Via != ViaUser {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
+}
+
+// Views returns the views sent by bots, oldest first.
+func (s *Server) Views() []*View {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*View(nil), s.views...)
+}
+
+// SendMessage sends a message as user to the RTM connections and
+// returns its timestamp.
+func (s *Server) SendMessage(channel, user, text string) string {
+	return s.Send(&Message{Channel: channel, User: user, Text: text})
+}
+
+// Send sends m as a user message to the RTM connections and returns
+// its timestamp.
+func (s *Server) Send(m *Message) string {
+	s.mu.Lock()
+	m.Via = ViaUser
+	s.record(m)
+	conns := s.connList()
+	s.mu.Unlock()
+
+	ev := map[string]interface{}{
+		"type":    "message",
+		"channel": m.Channel,
+		"user":    m.User,
+		"text":    m.Text,
+		"ts":      m.TS,
+		"team":    TeamID,
+	}
+	if m.ThreadTS != "" {
+		ev["thread_ts"] = m.ThreadTS
+	}
+	for _, c := range conns {
+		c.WriteJSON(ev)
+	}
+	return m.TS
+}
+
+// SendEvent sends any event to the RTM connections.
+func (s *Server) SendEvent(ev interface{}) {
+	s.mu.Lock()
+	conns := s.connList()
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.WriteJSON(ev)
+	}
+}
+
+// connList returns the RTM connections. The caller must hold s.mu.
+func (s *Server) connList() []*rtmConn {
+	var conns []*rtmConn
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// channelByName resolves "#name" to a channel ID. The caller must
+// hold s.mu.
+func (s *Server) channelByName(name string) (string, bool) {
+	name = strings.TrimPrefix(name, "#")
+	for _, c := range s.channels {
+		if c.Name == name && !c.IsIM {
+			return c.ID, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,119 @@
+package slacktest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRateLimit(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.RateLimit("chat.postMessage", 3, 1)
+
+	resp, err := http.PostForm(s.APIURL()+"chat.postMessage", url.Values{
+		"token": {BotToken}, "channel": {General}, "text": {"hi"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q, want 3", got)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var v struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.OK || v.Error != "ratelimited" {
+		t.Errorf("answer = %+v", v)
+	}
+}
+
+func TestOpenIDConnect(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	// The consent page redirects to the client with a code.
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	q := url.Values{
+		"client_id":    {ClientID},
+		"redirect_uri": {"http://localhost/callback"},
+		"scope":        {"openid"},
+		"state":        {"st"},
+		"nonce":        {"n0"},
+	}
+	resp, err := client.Get(s.URL + "/openid/connect/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Query().Get("state") != "st" {
+		t.Fatalf("redirected to %q", resp.Header.Get("Location"))
+	}
+
+	resp, err = http.PostForm(s.APIURL()+"openid.connect.token", url.Values{
+		"client_id":     {ClientID},
+		"client_secret": {ClientSecret},
+		"code":          {loc.Query().Get("code")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		OK      bool   `json:"ok"`
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(tok.IDToken, ".")
+	if !tok.OK || len(parts) != 3 {
+		t.Fatalf("token = %+v", tok)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != s.URL || claims["aud"] != ClientID || claims["nonce"] != "n0" {
+		t.Errorf("claims = %v", claims)
+	}
+
+	// Codes are used once.
+	resp, err = http.PostForm(s.APIURL()+"openid.connect.token", url.Values{
+		"client_id":     {ClientID},
+		"client_secret": {ClientSecret},
+		"code":          {loc.Query().Get("code")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var again struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&again)
+	if again.Error != "invalid_code" {
+		t.Errorf("code reused: %+v", again)
+	}
+}
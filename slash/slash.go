@@ -0,0 +1,166 @@
+// Package slash serves Slack slash commands with a command.Router.
+//
+// Slack expects an answer within three seconds. Handlers finishing
+// in time answer directly; the others get an acknowledgment and
+// their answer is posted later to the response_url of the command.
+package slash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/signature"
+)
+
+// Deadline is how long the handler waits for a command before
+// answering Slack and switching to a delayed response.
+const Deadline = 2500 * time.Millisecond
+
+// message is the answer to a slash command, immediate or delayed.
+type message struct {
+	ResponseType    string          `json:"response_type"`
+	Text            string          `json:"text"`
+	Blocks          blockkit.Blocks `json:"blocks,omitempty"`
+	ReplaceOriginal bool            `json:"replace_original,omitempty"`
+}
+
+func newMessage(resp *command.Response, err error) *message {
+	msg := &message{ResponseType: "ephemeral"}
+	switch {
+	case err == command.ErrUnknownCommand:
+		msg.Text = "Sorry, I don't know this command."
+	case err == context.DeadlineExceeded:
+		msg.Text = "Sorry, the command took too long."
+	case err != nil:
+		msg.Text = "Sorry, the command failed: " + err.Error()
+	case resp == nil:
+		return nil
+	default:
+		msg.Text = resp.Text
+		msg.Blocks = resp.Blocks
+		if resp.InChannel {
+			msg.ResponseType = "in_channel"
+		}
+	}
+	return msg
+}
+
+// Handler serves slash commands. It verifies the signature of the
+// requests and routes the commands to Router.
+type Handler struct {
+	Router *command.Router
+	// SigningSecret is the signing secret of the Slack app.
+	SigningSecret string
+	// Ack is the text shown while a slow command runs.
+	Ack string
+
+	client   *http.Client
+	deadline time.Duration
+}
+
+// NewHandler creates a slash command handler for router. It panics if
+// secret is empty, which would let anyone send commands.
+func NewHandler(router *command.Router, secret string) *Handler {
+	if secret == "" {
+		panic("slash: empty signing secret")
+	}
+	return &Handler{
+		Router:        router,
+		SigningSecret: secret,
+		Ack:           "Working on it…",
+		client:        metrics.NewClient(10 * time.Second),
+		deadline:      Deadline,
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := signature.Verify(r, h.SigningSecret); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form := r.PostForm
+	name, args, _ := command.Parse(form.Get("command"))
+	req := &command.Request{
+		Name:        name,
+		Args:        args,
+		Text:        form.Get("text"),
+		Source:      command.Slash,
+		Team:        form.Get("team_id"),
+		Channel:     form.Get("channel_id"),
+		User:        form.Get("user_id"),
+		TriggerID:   form.Get("trigger_id"),
+		ResponseURL: form.Get("response_url"),
+	}
+	_, req.Args, _ = command.Parse("/" + name + " " + req.Text)
+
+	type result struct {
+		resp *command.Response
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// The command outlives the HTTP request when it is slow.
+		resp, err := h.Router.Dispatch(context.Background(), req)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case res := <-done:
+		msg := newMessage(res.resp, res.err)
+		if msg == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(msg)
+	case <-time.After(h.deadline):
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&message{ResponseType: "ephemeral", Text: h.Ack})
+		go func() {
+			res := <-done
+			msg := newMessage(res.resp, res.err)
+			if msg == nil {
+				return
+			}
+			msg.ReplaceOriginal = res.resp != nil && res.resp.ReplaceOriginal
+			if err := h.Respond(req.ResponseURL, msg); err != nil {
+				slog.With(logging.Event(req.Team, req.Channel, req.User, "slash_command")...).Error("fail to answer command", "command", "/"+req.Name, "err", err)
+			}
+		}()
+	}
+}
+
+// Respond posts a delayed answer to the response_url of a command.
+func (h *Handler) Respond(url string, msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slash: unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
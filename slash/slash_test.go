@@ -0,0 +1,120 @@
+package slash
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/signature"
+)
+
+const testSecret = "slash-secret"
+
+// send posts the command /name to h, signed like Slack does, and
+// returns the immediate answer.
+func send(t *testing.T, h http.Handler, name, responseURL string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := url.Values{
+		"command":      {"/" + name},
+		"channel_id":   {"C1"},
+		"user_id":      {"U1"},
+		"response_url": {responseURL},
+	}.Encode()
+	r := httptest.NewRequest("POST", "/slack/commands", strings.NewReader(body))
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", ts)
+	r.Header.Set("X-Slack-Signature", signature.Sign(testSecret, ts, []byte(body)))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewHandlerEmptySecret(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewHandler with an empty secret did not panic")
+		}
+	}()
+	NewHandler(command.NewRouter(time.Second), "")
+}
+
+func TestImmediate(t *testing.T) {
+	router := command.NewRouter(time.Second)
+	router.Handle("hello", command.HandlerFunc(func(ctx context.Context, req *command.Request) (*command.Response, error) {
+		return &command.Response{Text: "Hello!", InChannel: true}, nil
+	}))
+	h := NewHandler(router, testSecret)
+
+	w := send(t, h, "hello", "")
+	var msg message
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err, w.Body)
+	}
+	if msg.ResponseType != "in_channel" || msg.Text != "Hello!" || msg.ReplaceOriginal {
+		t.Errorf("answer %+v", msg)
+	}
+
+	w = send(t, h, "nope", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatal(err, w.Body)
+	}
+	if msg.ResponseType != "ephemeral" || !strings.Contains(msg.Text, "don't know") {
+		t.Errorf("unknown command answered %+v", msg)
+	}
+}
+
+func TestDelayed(t *testing.T) {
+	delayed := make(chan message, 1)
+	responses := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		json.NewDecoder(r.Body).Decode(&msg)
+		delayed <- msg
+	}))
+	defer responses.Close()
+
+	tests := []struct {
+		name string
+		resp command.Response
+		want message
+	}{
+		{"in channel", command.Response{Text: "done", InChannel: true}, message{ResponseType: "in_channel", Text: "done"}},
+		{"ephemeral", command.Response{Text: "done"}, message{ResponseType: "ephemeral", Text: "done"}},
+		{"replace", command.Response{Text: "done", ReplaceOriginal: true}, message{ResponseType: "ephemeral", Text: "done", ReplaceOriginal: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			router := command.NewRouter(time.Second)
+			router.Handle("slow", command.HandlerFunc(func(ctx context.Context, req *command.Request) (*command.Response, error) {
+				<-release
+				resp := tt.resp
+				return &resp, nil
+			}))
+			h := NewHandler(router, testSecret)
+			h.deadline = 10 * time.Millisecond
+
+			w := send(t, h, "slow", responses.URL)
+			close(release)
+			var ack message
+			if err := json.Unmarshal(w.Body.Bytes(), &ack); err != nil || ack.Text != h.Ack {
+				t.Fatalf("acknowledgment %s, %v", w.Body, err)
+			}
+			select {
+			case msg := <-delayed:
+				if msg.ResponseType != tt.want.ResponseType || msg.Text != tt.want.Text || msg.ReplaceOriginal != tt.want.ReplaceOriginal {
+					t.Errorf("delayed answer %+v, want %+v", msg, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no delayed answer")
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package timer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/dialog"
+)
+
+// Usage lists the subcommands of the timer command.
+const Usage = "Usage: timer start [project] | stop | status | projects | project add [name]"
+
+// ProjectAddFlow is the conversation asking the name of a new project.
+const ProjectAddFlow = "project_add"
+
+// FormatDuration prints d rounded to the minute.
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+}
+
+// ProjectName names the project of a timer.
+func ProjectName(p string) string {
+	if p == "" {
+		return "no project"
+	}
+	return p
+}
+
+// Handler answers the timer command and its subcommands.
+type Handler struct {
+	Store *Store
+	// OpenStart opens a modal to pick the project of a timer started
+	// without one, from a slash command. It returns ErrNoProject when
+	// there's nothing to pick. Timers start without project if nil.
+	OpenStart func(ctx context.Context, req *command.Request) error
+	// Dialogs asks the name of projects added over RTM without one,
+	// with ProjectAddFlow. The name is required if nil.
+	Dialogs *dialog.Manager
+}
+
+// DialogKey returns the conversation started by req. Conversations
+// happen in the thread of the command.
+func DialogKey(req *command.Request) dialog.Key {
+	thread := req.ThreadTS
+	if thread == "" {
+		thread = req.TS
+	}
+	return dialog.Key{Team: req.Team, Channel: req.Channel, User: req.User, Thread: thread}
+}
+
+// ServeCommand implements command.Handler.
+func (h *Handler) ServeCommand(ctx context.Context, req *command.Request) (*command.Response, error) {
+	timers := h.Store
+	if len(req.Args) == 0 {
+		return &command.Response{Text: Usage}, nil
+	}
+	args := req.Args[1:]
+	switch req.Args[0] {
+	case "start":
+		project := strings.Join(args, " ")
+		if project == "" && req.TriggerID != "" && h.OpenStart != nil {
+			// Slash commands can open a modal to pick the project.
+			err := h.OpenStart(ctx, req)
+			if err == ErrNoProject {
+				return &command.Response{Text: "No project yet, add one with `timer project add <name>`."}, nil
+			}
+			return nil, err
+		}
+		e, err := timers.Start(req.User, project)
+		if err == ErrNoProject {
+			return &command.Response{Text: fmt.Sprintf("I don't know project %q, add it with `timer project add %s`.", project, project)}, nil
+		}
+		if err == ErrRunning {
+			return &command.Response{Text: "A timer is already running, stop it first."}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &command.Response{Text: fmt.Sprintf("Timer started on %s.", ProjectName(e.Project))}, nil
+	case "stop":
+		e, err := timers.Stop(req.User)
+		if err == ErrNotRunning {
+			return &command.Response{Text: "No timer is running."}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &command.Response{Text: fmt.Sprintf("Timer stopped, %s on %s.", FormatDuration(e.Duration(e.Stop)), ProjectName(e.Project))}, nil
+	case "status":
+		text := "No timer is running."
+		if e := timers.Running(req.User); e != nil {
+			text = fmt.Sprintf("Timer running on %s for %s.", ProjectName(e.Project), FormatDuration(e.Duration(time.Now())))
+		}
+		totals := timers.Totals(req.User, time.Now().AddDate(0, 0, -7))
+		var names []string
+		for p := range totals {
+			names = append(names, p)
+		}
+		sort.Strings(names)
+		for _, p := range names {
+			text += fmt.Sprintf("\n• %s: %s", ProjectName(p), FormatDuration(totals[p]))
+		}
+		return &command.Response{Text: text}, nil
+	case "projects":
+		projects := timers.ListProjects()
+		if len(projects) == 0 {
+			return &command.Response{Text: "No project yet, add one with `timer project add <name>`."}, nil
+		}
+		return &command.Response{Text: "Projects: " + strings.Join(projects, ", ")}, nil
+	case "project":
+		if len(args) == 1 && args[0] == "add" && req.Source == command.RTM && h.Dialogs != nil {
+			// Ask the name in a thread.
+			text, err := h.Dialogs.Start(ctx, DialogKey(req), ProjectAddFlow, nil)
+			if err != nil {
+				return nil, err
+			}
+			return &command.Response{Text: text, Thread: true}, nil
+		}
+		if len(args) < 2 || args[0] != "add" {
+			return &command.Response{Text: Usage}, nil
+		}
+		name := strings.Join(args[1:], " ")
+		err := timers.AddProject(name)
+		if err != nil {
+			return nil, err
+		}
+		return &command.Response{Text: fmt.Sprintf("Project %s added.", name), InChannel: true}, nil
+	}
+	return &command.Response{Text: Usage}, nil
+}
+
+// NewProjectAddFlow returns the conversation adding a project to s.
+func NewProjectAddFlow(s *Store) *dialog.Flow {
+	return &dialog.Flow{
+		Name: ProjectAddFlow,
+		Steps: []dialog.Step{
+			dialog.Ask("name", "What's the name of the new project?", func(name string, st *dialog.State) error {
+				if name == "" {
+					return errors.New("The name can't be empty.")
+				}
+				if utf8.RuneCountInString(name) > blockkit.MaxLabelLength {
+					return fmt.Errorf("The name is too long, %d characters max.", blockkit.MaxLabelLength)
+				}
+				for _, p := range s.ListProjects() {
+					if p == name {
+						return fmt.Errorf("Project %s already exists.", name)
+					}
+				}
+				return nil
+			}),
+		},
+		Done: func(ctx context.Context, st *dialog.State) (string, error) {
+			name := st.Answers["name"]
+			if err := s.AddProject(name); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("Project %s added.", name), nil
+		},
+	}
+}
@@ -0,0 +1,287 @@
+// Package timer tracks the time users spend on projects, and answers
+// the timer command of the bots.
+package timer
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRunning    = errors.New("timer: a timer is already running")
+	ErrNotRunning = errors.New("timer: no timer is running")
+	ErrNoProject  = errors.New("timer: unknown project")
+)
+
+// Entry is the time spent on a project.
+type Entry struct {
+	Project string    `json:"project"`
+	Start   time.Time `json:"start"`
+	Stop    time.Time `json:"stop,omitempty"`
+}
+
+// Duration returns the time spent so far.
+func (e *Entry) Duration(now time.Time) time.Duration {
+	if e.Stop.IsZero() {
+		return now.Sub(e.Start)
+	}
+	return e.Stop.Sub(e.Start)
+}
+
+// userTimers are the timers of a user.
+type userTimers struct {
+	Running *Entry  `json:"running,omitempty"`
+	Entries []Entry `json:"entries"`
+}
+
+// Store keeps the timers of every user in a JSON file.
+type Store struct {
+	mu       sync.Mutex
+	filename string
+	now      func() time.Time
+	// OnChange is called when the timer of a user starts or stops.
+	OnChange func(user string) `json:"-"`
+	// notifying holds the users OnChange runs for, true when it must
+	// run again.
+	notifyMu  sync.Mutex
+	notifying map[string]bool
+
+	Projects []string               `json:"projects"`
+	Users    map[string]*userTimers `json:"users"`
+}
+
+// Load reads the timers from filename, it starts empty if the
+// file doesn't exist.
+func Load(filename string) (*Store, error) {
+	s := &Store{
+		filename: filename,
+		now:      time.Now,
+		Users:    make(map[string]*userTimers),
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return nil, err
+	}
+	if s.Users == nil {
+		s.Users = make(map[string]*userTimers)
+	}
+	return s, nil
+}
+
+// save writes the store, the caller must hold s.mu.
+func (s *Store) save() error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.filename), ".timers")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename)
+}
+
+// Flush writes the store, once the changes in progress are done.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.save()
+}
+
+func (s *Store) user(id string) *userTimers {
+	u, ok := s.Users[id]
+	if !ok {
+		u = &userTimers{}
+		s.Users[id] = u
+	}
+	return u
+}
+
+func (s *Store) hasProject(name string) bool {
+	for _, p := range s.Projects {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Start starts a timer on project for user. Nothing changes if the
+// store can't be saved.
+func (s *Store) Start(user, project string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if project != "" && !s.hasProject(project) {
+		return nil, ErrNoProject
+	}
+	u := s.user(user)
+	if u.Running != nil {
+		return nil, ErrRunning
+	}
+	u.Running = &Entry{Project: project, Start: s.now()}
+	e := *u.Running
+	if err := s.save(); err != nil {
+		u.Running = nil
+		return nil, err
+	}
+	s.changed(user)
+	return &e, nil
+}
+
+// Stop stops the running timer of user. Nothing changes if the store
+// can't be saved.
+func (s *Store) Stop(user string) (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.user(user)
+	if u.Running == nil {
+		return nil, ErrNotRunning
+	}
+	running, entries := u.Running, u.Entries
+	e := *u.Running
+	e.Stop = s.now()
+	u.Entries = append(u.Entries, e)
+	u.Running = nil
+	if err := s.save(); err != nil {
+		u.Running, u.Entries = running, entries
+		return nil, err
+	}
+	s.changed(user)
+	return &e, nil
+}
+
+// changed calls OnChange without blocking the caller, which holds
+// s.mu. Calls for a user run one at a time: changes made during a call
+// lead to a single call after it, which sees the latest state.
+func (s *Store) changed(user string) {
+	if s.OnChange == nil {
+		return
+	}
+	s.notifyMu.Lock()
+	defer s.notifyMu.Unlock()
+	if s.notifying == nil {
+		s.notifying = make(map[string]bool)
+	}
+	if _, busy := s.notifying[user]; busy {
+		s.notifying[user] = true
+		return
+	}
+	s.notifying[user] = false
+	go s.notify(user)
+}
+
+// notify calls OnChange for user until no change is left.
+func (s *Store) notify(user string) {
+	for {
+		s.OnChange(user)
+		s.notifyMu.Lock()
+		if !s.notifying[user] {
+			delete(s.notifying, user)
+			s.notifyMu.Unlock()
+			return
+		}
+		s.notifying[user] = false
+		s.notifyMu.Unlock()
+	}
+}
+
+// Running returns the running timer of user, or nil.
+func (s *Store) Running(user string) *Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[user]
+	if !ok || u.Running == nil {
+		return nil
+	}
+	e := *u.Running
+	return &e
+}
+
+// Entries returns the stopped timers of user.
+func (s *Store) Entries(user string) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[user]
+	if !ok {
+		return nil
+	}
+	return append([]Entry(nil), u.Entries...)
+}
+
+// AddProject adds a project timers can be started on.
+func (s *Store) AddProject(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.hasProject(name) {
+		return nil
+	}
+	projects := s.Projects
+	s.Projects = append(s.Projects[:len(projects):len(projects)], name)
+	sort.Strings(s.Projects)
+	if err := s.save(); err != nil {
+		s.Projects = projects
+		return err
+	}
+	return nil
+}
+
+// ListProjects returns the projects, sorted.
+func (s *Store) ListProjects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.Projects...)
+}
+
+// Totals returns the time user spent on each project since since,
+// running timer included.
+func (s *Store) Totals(user string, since time.Time) map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totals := make(map[string]time.Duration)
+	u, ok := s.Users[user]
+	if !ok {
+		return totals
+	}
+	now := s.now()
+	entries := u.Entries
+	if u.Running != nil {
+		entries = append(entries[:len(entries):len(entries)], *u.Running)
+	}
+	for i := range entries {
+		e := &entries[i]
+		if e.Duration(now) <= 0 || (!e.Stop.IsZero() && e.Stop.Before(since)) {
+			continue
+		}
+		start := e.Start
+		if start.Before(since) {
+			start = since
+		}
+		stop := e.Stop
+		if stop.IsZero() {
+			stop = now
+		}
+		totals[e.Project] += stop.Sub(start)
+	}
+	return totals
+}
@@ -0,0 +1,111 @@
+package timer
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreRollback(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Load(filepath.Join(dir, "timers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddProject("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Start("U1", "acme"); err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	s.OnChange = func(string) { changes++ }
+
+	// The directory is gone, every save fails.
+	s.filename = filepath.Join(dir, "missing", "timers.json")
+	if _, err := s.Stop("U1"); err == nil {
+		t.Fatal("Stop saved to a missing directory")
+	}
+	if s.Running("U1") == nil || len(s.Entries("U1")) != 0 {
+		t.Errorf("Stop not rolled back: running %v, entries %v", s.Running("U1"), s.Entries("U1"))
+	}
+	if _, err := s.Start("U2", "acme"); err == nil {
+		t.Fatal("Start saved to a missing directory")
+	}
+	if s.Running("U2") != nil {
+		t.Error("Start not rolled back")
+	}
+	if err := s.AddProject("globex"); err == nil {
+		t.Fatal("AddProject saved to a missing directory")
+	}
+	if got := s.ListProjects(); len(got) != 1 || got[0] != "acme" {
+		t.Errorf("AddProject not rolled back: %q", got)
+	}
+	if changes != 0 {
+		t.Errorf("OnChange called %d times for changes not saved", changes)
+	}
+
+	// Saved again once the directory is back.
+	s.filename = filepath.Join(dir, "timers.json")
+	if _, err := s.Stop("U1"); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Load(filepath.Join(dir, "timers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Running("U1") != nil || len(s.Entries("U1")) != 1 {
+		t.Errorf("reloaded: running %v, entries %v", s.Running("U1"), s.Entries("U1"))
+	}
+}
+
+func TestStoreOnChange(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "timers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := make(chan string)
+	release := make(chan bool)
+	var mu sync.Mutex
+	running := 0
+	s.OnChange = func(user string) {
+		mu.Lock()
+		running++
+		if running > 1 {
+			t.Error("OnChange called concurrently")
+		}
+		mu.Unlock()
+		calls <- user
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+
+	if _, err := s.Start("U1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if user := <-calls; user != "U1" {
+		t.Fatalf("OnChange(%q), want U1", user)
+	}
+	// Changes made while the first call runs make a single call.
+	for i := 0; i < 3; i++ {
+		if _, err := s.Stop("U1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Start("U1", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	release <- true
+	if user := <-calls; user != "U1" {
+		t.Fatalf("OnChange(%q), want U1", user)
+	}
+	release <- true
+	select {
+	case user := <-calls:
+		t.Errorf("extra OnChange(%q)", user)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/timer"
+)
+
+// newRouter registers the commands of timerbot. The same router
+// answers mentions over RTM and slash commands.
+func newRouter() *command.Router {
+	r := command.NewRouter(10 * time.Second)
+	r.HandleFunc("hello", func(ctx context.Context, req *command.Request) (*command.Response, error) {
+		return &command.Response{Text: "Hello!", InChannel: true}, nil
+	})
+	r.HandleFunc("bye", func(ctx context.Context, req *command.Request) (*command.Response, error) {
+		return &command.Response{Text: "Bye!", InChannel: true}, nil
+	})
+	r.HandleFunc("help", func(ctx context.Context, req *command.Request) (*command.Response, error) {
+		return &command.Response{Text: "I understand: hello, bye, help, timer.", Blocks: helpBlocks()}, nil
+	})
+	r.Handle("timer", &timer.Handler{
+		Store: global.Timers,
+		OpenStart: func(ctx context.Context, req *command.Request) error {
+			return openStartModal(ctx, req.TriggerID, req.ResponseURL)
+		},
+		Dialogs: global.Dialogs,
+	})
+	return r
+}
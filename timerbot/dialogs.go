@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aitva/slackbot/dialog"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/timer"
+)
+
+// newDialogs resumes the conversations saved in TIMERBOT_DIALOGS.
+func newDialogs() (*dialog.Manager, error) {
+	filename := os.Getenv("TIMERBOT_DIALOGS")
+	if filename == "" {
+		filename = "timerbot-dialogs.json"
+	}
+	m, err := dialog.NewManager(dialog.NewFileStore(filename))
+	if err != nil {
+		return nil, err
+	}
+	m.Register(timer.NewProjectAddFlow(global.Timers))
+	return m, nil
+}
+
+// expireDialogs tells users when timerbot stops waiting for their
+// answer, through the outbox like the replies. It returns when ctx is
+// done.
+func expireDialogs(ctx context.Context) {
+	tick := time.NewTicker(30 * time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+		expired, err := global.Dialogs.Expire()
+		if err != nil {
+			slog.Error("fail to save dialogs", "err", err)
+		}
+		for _, st := range expired {
+			err := global.Outbox.Send(&rtm.Message{
+				Type:     "message",
+				Channel:  st.Key.Channel,
+				ThreadTS: st.Key.Thread,
+				Text:     "I stopped waiting for an answer, start again when you're ready.",
+			})
+			if err != nil {
+				slog.With(logging.Event(st.Key.Team, st.Key.Channel, st.Key.User, "")...).Error("fail to send message", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/events"
+	"github.com/aitva/slackbot/interact"
+	"github.com/aitva/slackbot/timer"
+)
+
+// stopID is the action of the stop button of the Home tab.
+const stopID = "timer_stop"
+
+// exportTTL is how long export links stay valid.
+const exportTTL = 7 * 24 * time.Hour
+
+// exportKey returns the key signing export links. An empty key is
+// replaced by a random one, which invalidates the links on restart.
+// The links don't use the Slack signing secret, which must not sign
+// anything else than Slack's requests.
+func exportKey(key string) ([]byte, error) {
+	if key != "" {
+		return []byte(key), nil
+	}
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+// signExport computes the signature of the export link of user.
+func signExport(user, expires string) string {
+	mac := hmac.New(sha256.New, global.ExportKey)
+	mac.Write([]byte("export:" + user + ":" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// exportURL returns a signed link to the entries of user, or "" if
+// timerbot doesn't know its public URL.
+func exportURL(user string) string {
+	if global.PublicURL == "" {
+		return ""
+	}
+	expires := strconv.FormatInt(time.Now().Add(exportTTL).Unix(), 10)
+	v := url.Values{"user": {user}, "expires": {expires}, "sig": {signExport(user, expires)}}
+	return global.PublicURL + "/export?" + v.Encode()
+}
+
+// handleExport sends the entries of a user as CSV.
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	user, expires := q.Get("user"), q.Get("expires")
+	secs, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > secs ||
+		!hmac.Equal([]byte(q.Get("sig")), []byte(signExport(user, expires))) {
+		http.Error(w, "invalid or expired link", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="timers.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "start", "stop", "minutes"})
+	for _, e := range global.Timers.Entries(user) {
+		cw.Write([]string{
+			csvCell(e.Project),
+			e.Start.Format(time.RFC3339),
+			e.Stop.Format(time.RFC3339),
+			strconv.Itoa(int(e.Duration(e.Stop).Minutes())),
+		})
+	}
+	cw.Flush()
+}
+
+// csvCell quotes a cell a spreadsheet would run as a formula: project
+// names are typed by any member of the workspace.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// homeView shows the timer of user and the totals of the last seven
+// days.
+func homeView(user string) *blockkit.View {
+	now := time.Now()
+	blocks := blockkit.Blocks{&blockkit.Header{Text: blockkit.Plain("Timer")}}
+	if e := global.Timers.Running(user); e != nil {
+		blocks = append(blocks,
+			&blockkit.Section{Text: blockkit.Markdown(fmt.Sprintf("Running on *%s* since %s (%s).",
+				timer.ProjectName(e.Project), e.Start.Format("Mon 15:04"), timer.FormatDuration(e.Duration(now))))},
+			&blockkit.Actions{Elements: blockkit.Elements{
+				&blockkit.Button{Text: blockkit.Plain("Stop"), ActionID: stopID, Style: blockkit.Danger},
+			}},
+		)
+	} else {
+		blocks = append(blocks,
+			&blockkit.Section{Text: blockkit.Markdown("No timer is running.")},
+			&blockkit.Actions{Elements: blockkit.Elements{
+				&blockkit.Button{Text: blockkit.Plain("Start"), ActionID: openStartID, Style: blockkit.Primary},
+			}},
+		)
+	}
+
+	blocks = append(blocks, &blockkit.Divider{}, &blockkit.Header{Text: blockkit.Plain("Last seven days")})
+	totals := global.Timers.Totals(user, now.AddDate(0, 0, -7))
+	var names []string
+	for p := range totals {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	var fields []*blockkit.Text
+	for _, p := range names {
+		fields = append(fields, blockkit.Markdown(fmt.Sprintf("*%s*\n%s", timer.ProjectName(p), timer.FormatDuration(totals[p]))))
+	}
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > blockkit.MaxFields {
+			n = blockkit.MaxFields
+		}
+		blocks = append(blocks, &blockkit.Section{Fields: fields[:n]})
+		fields = fields[n:]
+	}
+	if len(names) == 0 {
+		blocks = append(blocks, &blockkit.Section{Text: blockkit.Markdown("Nothing tracked yet.")})
+	}
+	if link := exportURL(user); link != "" {
+		blocks = append(blocks, &blockkit.Context{Elements: blockkit.Elements{
+			blockkit.Markdown(fmt.Sprintf("<%s|Export my timers as CSV>", link)),
+		}})
+	}
+	return blockkit.Home(blocks...)
+}
+
+// publishHome refreshes the Home tab of user.
+func publishHome(user string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := global.Views.PublishView(ctx, user, "", homeView(user))
+	if err != nil {
+		slog.Error("fail to publish Home tab", "user", user, "err", err)
+	}
+}
+
+// newEvents handles the Events API callbacks.
+func newEvents(secret string) *events.Router {
+	r := events.NewRouter(secret)
+	r.HandleFunc("app_home_opened", func(ctx context.Context, env *events.Envelope, ev *events.Event) error {
+		if ev.Tab != "home" {
+			return nil
+		}
+		_, err := global.Views.PublishView(ctx, ev.User, "", homeView(ev.User))
+		return err
+	})
+	return r
+}
+
+// handleStop stops the timer from the Home tab.
+func handleStop(ctx context.Context, p *interact.Payload) (*interact.Response, error) {
+	_, err := global.Timers.Stop(p.User.ID)
+	if err == timer.ErrNotRunning {
+		// Already stopped elsewhere, the Home tab is out of date.
+		publishHome(p.User.ID)
+		return nil, nil
+	}
+	return nil, err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/timer"
+)
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"", ""},
+		{"website", "website"},
+		{"a=b", "a=b"},
+		{"=HYPERLINK(\"https://evil.example.com\")", "'=HYPERLINK(\"https://evil.example.com\")"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+	}
+	for _, tt := range tests {
+		if got := csvCell(tt.s); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	timers, err := timer.Load(filepath.Join(t.TempDir(), "timers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := timers.AddProject("=1+1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := timers.Start("U1", "=1+1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := timers.Stop("U1"); err != nil {
+		t.Fatal(err)
+	}
+	global.Timers, global.ExportKey = timers, []byte("key")
+	defer func() { global.Timers, global.ExportKey = nil, nil }()
+
+	expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	v := url.Values{"user": {"U1"}, "expires": {expires}, "sig": {signExport("U1", expires)}}
+	w := httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/export?"+v.Encode(), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	lines := strings.Split(w.Body.String(), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[1], "'=1+1,") {
+		t.Errorf("export:\n%s", w.Body)
+	}
+
+	v.Set("user", "U2")
+	w = httptest.NewRecorder()
+	handleExport(w, httptest.NewRequest("GET", "/export?"+v.Encode(), nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("export of another user: status %d", w.Code)
+	}
+}
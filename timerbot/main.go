@@ -1,136 +1,306 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/command"
+	"github.com/aitva/slackbot/dialog"
+	"github.com/aitva/slackbot/interact"
+	"github.com/aitva/slackbot/logging"
+	"github.com/aitva/slackbot/metrics"
+	"github.com/aitva/slackbot/middleware"
+	"github.com/aitva/slackbot/outbox"
+	"github.com/aitva/slackbot/pool"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/slackenv"
+	"github.com/aitva/slackbot/slash"
+	"github.com/aitva/slackbot/timer"
 )
 
 var global struct {
-	StartMsg rtmResponse
-}
-
-type rtmResponse struct {
-	Ok    bool   `json:"ok"`
-	Error string `json:"error"`
-	URL   string `json:"url"`
-	Self  struct {
-		ID string `json:"id"`
-	} `json:"self"`
+	RTM      *rtm.Client
+	StartMsg *rtm.Start
+	Router   *command.Router
+	Threads  *threadConfig
+	Dialogs  *dialog.Manager
+	Timers   *timer.Store
+	Views    *interact.Client
+	Outbox   *outbox.Queue
+	// ExportKey signs the export links, PublicURL is where timerbot
+	// is reachable.
+	ExportKey []byte
+	PublicURL string
+	// Background tracks the calls to Slack the handlers leave running
+	// after their answer, shutdown waits for them.
+	Background sync.WaitGroup
 }
 
-type rtmMsg struct {
-	ID      int    `json:"id"`
-	Type    string `json:"type"`
-	Channel string `json:"channel"`
-	Text    string `json:"text"`
-}
-
-func fatal(isOK bool, a ...interface{}) {
-	if !isOK {
-		return
+func helpBlocks() blockkit.Blocks {
+	return blockkit.Blocks{
+		&blockkit.Header{Text: blockkit.Plain("Timerbot")},
+		&blockkit.Section{Fields: []*blockkit.Text{
+			blockkit.Markdown("*hello*\nsay hello"),
+			blockkit.Markdown("*bye*\nsay goodbye"),
+			blockkit.Markdown("*help*\nshow this message"),
+			blockkit.Markdown("*timer start [project]*\nstart a timer"),
+			blockkit.Markdown("*timer stop*\nstop the running timer"),
+			blockkit.Markdown("*timer status*\nshow the timer and weekly totals"),
+			blockkit.Markdown("*timer project add <name>*\nadd a project"),
+		}},
+		&blockkit.Actions{Elements: blockkit.Elements{
+			&blockkit.Button{Text: blockkit.Plain("Start a timer"), ActionID: openStartID, Style: blockkit.Primary},
+		}},
+		&blockkit.Context{Elements: blockkit.Elements{
+			blockkit.Markdown("Mention me with `@timerbot: command`, or use `/timer`."),
+		}},
 	}
-	fmt.Println(a...)
-	os.Exit(1)
 }
 
-func readRTM(c *websocket.Conn, channels chan<- rtmMsg) {
-	for {
-		_, message, err := c.ReadMessage()
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "fail to read message:", err)
-			return
-		}
-		fmt.Println(string(message))
+// answer returns the reply to req, nil if the bot stays silent.
+func answer(ctx context.Context, req *rtm.Message) (*rtm.Message, error) {
+	log := slog.With(logging.Event(req.Team, req.Channel, req.User, req.Type)...)
+	botname := "<@" + global.StartMsg.Self.ID + ">"
+	// Accept "@bot: cmd" as well as "@bot cmd", which is how
+	// mentions are usually typed in threads.
+	trimed := strings.TrimPrefix(req.Text, botname)
+	trimed = strings.Trim(strings.TrimPrefix(trimed, ":"), " ")
 
-		msg := rtmMsg{}
-		err = json.Unmarshal(message, &msg)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "fail to parse message:", err)
-			return
+	var out *command.Response
+	var name string
+	key := dialog.Key{Team: req.Team, Channel: req.Channel, User: req.User, Thread: req.ThreadTS}
+	text, ok, err := global.Dialogs.Handle(ctx, key, trimed)
+	switch {
+	case err != nil:
+		log.ErrorContext(ctx, "fail to continue dialog", "err", err)
+		out = &command.Response{Text: "Sorry, something went wrong.", Thread: true}
+	case ok:
+		// Answers of a conversation don't need a mention.
+		out = &command.Response{Text: text, Thread: true}
+	case !strings.HasPrefix(req.Text, botname):
+		return nil, nil
+	default:
+		var args []string
+		name, args, text = command.Parse(trimed)
+		if name == "" {
+			log.WarnContext(ctx, "fail to parse command", "text", req.Text)
+			return nil, nil
 		}
-		if msg.Type != "message" {
-			continue
+		out, err = global.Router.Dispatch(ctx, &command.Request{
+			Name:     name,
+			Args:     args,
+			Text:     text,
+			Source:   command.RTM,
+			Team:     req.Team,
+			Channel:  req.Channel,
+			User:     req.User,
+			TS:       req.TS,
+			ThreadTS: req.ThreadTS,
+		})
+		if err == command.ErrUnknownCommand {
+			log.WarnContext(ctx, "unexpected command", "text", trimed)
+			return nil, nil
 		}
-		channels <- msg
+		if err != nil {
+			log.ErrorContext(ctx, "fail to run command", "text", trimed, "err", err)
+			out = &command.Response{Text: "Sorry, the command failed."}
+		}
+	}
+	if out == nil {
+		return nil, nil
 	}
+	resp := &rtm.Message{
+		Type:    "message",
+		Channel: req.Channel,
+		Text:    out.Text,
+		Blocks:  out.Blocks,
+	}
+	global.Threads.thread(req, name, out.Thread, resp)
+	return resp, nil
 }
 
-func writeRTM(c *websocket.Conn, channels <-chan rtmMsg) {
-	id := 0
-	botname := "<@" + global.StartMsg.Self.ID + ">"
-	for {
-		req := <-channels
-		if !strings.HasPrefix(req.Text, botname) {
-			continue
-		}
-		i := strings.Index(req.Text, ":")
-		trimed := strings.Trim(req.Text[i+1:], " ")
-		fmt.Fprintln(os.Stderr, "i:", i, "trimed:", trimed)
-		all := strings.Split(trimed, " ")
-		if len(all) == 0 || len(all) > 2 {
-			fmt.Fprintln(os.Stderr, "fail to parse command:", req.Text)
-			continue
-		}
-
-		resp := rtmMsg{
-			ID:      id,
-			Type:    "message",
-			Channel: req.Channel,
+// writeRTM returns the handler of the workers: it answers a message,
+// the reply going through global.Outbox.
+func writeRTM() func(ctx context.Context, req *rtm.Message) {
+	h := middleware.Chain(rtm.HandlerFunc(answer),
+		middleware.RequestID(),
+		middleware.Log(slog.Default()),
+		middleware.Recover(),
+		middleware.IgnoreBots(),
+	)
+	return func(ctx context.Context, req *rtm.Message) {
+		resp, err := h.ServeRTM(ctx, req)
+		if err != nil {
+			slog.Error("fail to handle message", "err", err)
+			return
 		}
-		switch all[0] {
-		case "hello":
-			resp.Text = "Hello!"
-		case "bye":
-			resp.Text = "Bye!"
-		default:
-			fmt.Fprintln(os.Stderr, "unexpected command:", all)
-			continue
+		if resp == nil {
+			return
 		}
-
-		err := c.WriteJSON(&resp)
+		err = global.Outbox.Send(resp)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "fail to send message:", err)
-			return
+			slog.Warn("reply not sent", "channel", resp.Channel, "err", err)
 		}
-		id++
 	}
 }
 
 func main() {
+	err := logging.Setup("timerbot")
+	logging.Fatal(err != nil, "fail to set up logs:", err)
 	token := os.Getenv("TOKEN")
-	fatal(token == "", "Variable TOKEN must be defined.")
+	logging.Fatal(token == "", "Variable TOKEN must be defined.")
+	slack, err := slackenv.Load()
+	logging.Fatal(err != nil, "fail to load Slack config:", err)
+	global.RTM = rtm.NewClient(token, slack)
+	drainTimeout := 10 * time.Second
+	if s := os.Getenv("DRAIN_TIMEOUT"); s != "" {
+		drainTimeout, err = time.ParseDuration(s)
+		logging.Fatal(err != nil, "invalid DRAIN_TIMEOUT:", err)
+	}
+	policy, err := outbox.ParsePolicy(os.Getenv("OUTBOX_POLICY"))
+	logging.Fatal(err != nil, "invalid OUTBOX_POLICY:", err)
+	workers, err := pool.FromEnv(writeRTM())
+	logging.Fatal(err != nil, "fail to configure workers:", err)
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = ":9102"
+	}
+	go func() {
+		err := metrics.ListenAndServe(adminAddr, global.RTM.Ready)
+		logging.Fatal(err != nil, "fail to serve admin endpoints:", err)
+	}()
+	global.Views = interact.NewClient(token, slack)
 
-	fmt.Println("Starting RTM service...")
-	url := fmt.Sprintf("https://slack.com/api/rtm.start?token=%s", token)
+	filename := os.Getenv("TIMERBOT_DATA")
+	if filename == "" {
+		filename = "timerbot.json"
+	}
+	timers, err := timer.Load(filename)
+	logging.Fatal(err != nil, "fail to load timers:", err)
+	global.Timers = timers
+	global.Dialogs, err = newDialogs()
+	logging.Fatal(err != nil, "fail to load dialogs:", err)
+	global.Router = newRouter()
+	global.Threads = threadConfigFromEnv()
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
 
-	resp, err := http.Get(url)
-	fatal(err != nil, "fail to reach server:", err)
-	fatal(resp.StatusCode != http.StatusOK, "unexpected status code:", resp.StatusCode)
+	// Slash commands and interactions need an HTTP endpoint, served
+	// when the app signing secret is known.
+	var srv *http.Server
+	if secret := os.Getenv("SLACK_SIGNING_SECRET"); secret != "" {
+		global.ExportKey, err = exportKey(os.Getenv("EXPORT_KEY"))
+		logging.Fatal(err != nil, "fail to create export key:", err)
+		global.PublicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+		// The Home tab follows the timer, whatever started or stopped it.
+		global.Timers.OnChange = publishHome
+		addr := os.Getenv("ADDR")
+		if addr == "" {
+			addr = ":3002"
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/slack/commands", slash.NewHandler(global.Router, secret))
+		mux.Handle("/slack/interactive", newInteractions(secret))
+		mux.Handle("/slack/events", newEvents(secret))
+		mux.HandleFunc("/export", handleExport)
+		srv = &http.Server{Addr: addr, Handler: mux}
+		go func() {
+			slog.Info("serving Slack requests", "addr", addr)
+			err := srv.ListenAndServe()
+			logging.Fatal(err != nil && err != http.ErrServerClosed, "fail to serve Slack requests:", err)
+		}()
+	}
 
-	err = json.NewDecoder(resp.Body).Decode(&global.StartMsg)
-	fatal(err != nil, "fail to parse response:", err)
+	// Signals are caught before connecting: one arriving meanwhile
+	// shuts the bot down once connected instead of killing it.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	slog.Info("connecting to RTM service")
+	start, c, err := global.RTM.Connect()
+	logging.Fatal(err != nil, "connection fail:", err)
+	global.StartMsg = start
 
-	fmt.Println("Connecting to RTM service...")
-	c, _, err := websocket.DefaultDialer.Dial(global.StartMsg.URL, nil)
-	fatal(err != nil, "connection fail:", err)
-	defer c.Close()
+	conn, rec, err := rtm.RecordFromEnv("TIMERBOT_RECORD", c, global.StartMsg)
+	logging.Fatal(err != nil, "fail to record session:", err)
+	// Replies wait in the outbox for the rate limits of Slack; message
+	// IDs follow the order they are sent in.
+	id := 0
+	global.Outbox = outbox.New(func(msg *rtm.Message) error {
+		msg.ID = id
+		id++
+		return global.RTM.Send(conn, msg)
+	})
+	global.Outbox.Policy = policy
+	go global.Outbox.Run()
+	go expireDialogs(ctx)
 
-	channels := make(chan rtmMsg)
-	go readRTM(c, channels)
-	go writeRTM(c, channels)
+	// Signals stop the intake, then the messages submitted to the
+	// workers, the replies waiting and the requests in flight get
+	// drainTimeout to finish before the state is saved and the socket
+	// closed. A lost connection does the same.
+	hctx, abort := context.WithCancel(context.Background())
+	defer abort()
+	workers.Start(hctx)
+	go func() {
+		workers.Read(ctx, conn, slog.Default())
+		stop()
+	}()
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	<-interrupt
-	fmt.Println("Closing RTM connection...")
-	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-	err = c.WriteMessage(websocket.CloseMessage, msg)
-	fatal(err != nil, "fail to close socker:", err)
+	select {
+	case sig := <-interrupt:
+		slog.Info("shutting down", "signal", sig)
+	case <-ctx.Done():
+		slog.Warn("RTM connection lost, shutting down")
+	}
+	stop()
+	// The Slack requests in flight, and the calls their handlers left
+	// running, drain alongside the workers under the same deadline.
+	dctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	var pending sync.WaitGroup
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		workers.Close()
+	}()
+	if srv != nil {
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			if err := srv.Shutdown(dctx); err != nil {
+				slog.Error("fail to drain Slack requests", "err", err)
+				return
+			}
+			global.Background.Wait()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	pool.Drain(dctx, done, abort)
+	if err := global.Outbox.Close(dctx); err != nil {
+		slog.Error("fail to send replies", "err", err)
+	}
+	if err := global.Timers.Flush(); err != nil {
+		slog.Error("fail to save timers", "err", err)
+	}
+	if err := global.Dialogs.Flush(); err != nil {
+		slog.Error("fail to save dialogs", "err", err)
+	}
+	if err := rec.Close(); err != nil {
+		slog.Error("fail to record session", "err", err)
+	}
+	slog.Info("closing RTM connection")
+	err = c.Close()
+	logging.Fatal(err != nil, "fail to close socket:", err)
 }
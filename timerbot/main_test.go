@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aitva/slackbot/slacktest"
+)
+
+func TestMain(m *testing.M) {
+	slacktest.RunMain(main)
+	os.Exit(m.Run())
+}
+
+const testSecret = "timerbot-secret"
+
+// freeAddr returns a local address nobody listens on.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startBot runs timerbot against s, with its data in dir. It returns
+// the base URL of the Slack endpoints.
+func startBot(t *testing.T, s *slacktest.Server, dir string) (*slacktest.Process, string) {
+	t.Helper()
+	addr := freeAddr(t)
+	p := slacktest.StartMain(t, []string{
+		"TOKEN=" + slacktest.BotToken,
+		"SLACK_BASE_URL=" + s.URL,
+		"SLACK_SIGNING_SECRET=" + testSecret,
+		"ADDR=" + addr,
+		"ADMIN_ADDR=127.0.0.1:0",
+		"TIMERBOT_DATA=" + filepath.Join(dir, "timerbot.json"),
+		"TIMERBOT_DIALOGS=" + filepath.Join(dir, "dialogs.json"),
+	})
+	if err := s.WaitConnected(5 * time.Second); err != nil {
+		t.Fatal("timerbot did not connect:", err)
+	}
+	return p, "http://" + addr
+}
+
+func TestEndToEnd(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser("U1", "alice")
+	dir := t.TempDir()
+	p, url := startBot(t, s, dir)
+
+	// Mentions over RTM.
+	s.SendMessage(slacktest.General, "U1", "<@"+slacktest.BotUserID+">: hello")
+	msg, err := s.WaitMessage(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Text != "Hello!" || msg.Via != slacktest.ViaRTM {
+		t.Errorf("answer = %+v", msg)
+	}
+
+	// Slash commands, answered in the HTTP response.
+	command := func(text, want string) {
+		t.Helper()
+		msg, err := s.Command(url+"/slack/commands", testSecret, slacktest.General, "U1", "/timer", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil || msg.Text != want {
+			t.Errorf("/timer %s = %+v, want %q", text, msg, want)
+		}
+	}
+	command("project add acme", "Project acme added.")
+	command("start acme", "Timer started on acme.")
+	command("start acme", "A timer is already running, stop it first.")
+
+	// The Home tab follows the timer.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		views := s.Views()
+		if len(views) > 0 && views[len(views)-1].Method == "views.publish" && views[len(views)-1].UserID == "U1" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Home tab not published, views = %+v", views)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code, err := p.Stop(5 * time.Second); err != nil || code != 0 {
+		t.Fatalf("exit status %d, %v", code, err)
+	}
+	if err := s.WaitDisconnected(5 * time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The timer is saved on shutdown and still running after a restart.
+	p, url = startBot(t, s, dir)
+	msg, err = s.Command(url+"/slack/commands", testSecret, slacktest.General, "U1", "/timer", "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg == nil || !strings.HasPrefix(msg.Text, "Timer running on acme") {
+		t.Errorf("/timer status = %+v", msg)
+	}
+	if code, err := p.Stop(5 * time.Second); err != nil || code != 0 {
+		t.Errorf("exit status %d, %v", code, err)
+	}
+}
+
+func TestEndToEndShutdownWaitsForConfirmation(t *testing.T) {
+	s := slacktest.NewServer()
+	defer s.Close()
+	s.AddUser("U1", "alice")
+	p, url := startBot(t, s, t.TempDir())
+	if msg, err := s.Command(url+"/slack/commands", testSecret, slacktest.General, "U1", "/timer", "project add acme"); err != nil || msg == nil {
+		t.Fatalf("project add = %+v, %v", msg, err)
+	}
+
+	// The confirmation of the modal is sent after the answer to Slack,
+	// slower than the bot is stopped.
+	confirmed := make(chan string, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		b, _ := io.ReadAll(r.Body)
+		confirmed <- string(b)
+	}))
+	defer slow.Close()
+	_, err := s.Interact(url+"/slack/interactive", testSecret, map[string]interface{}{
+		"type": "view_submission",
+		"user": map[string]interface{}{"id": "U1"},
+		"view": map[string]interface{}{
+			"callback_id":      startModalID,
+			"private_metadata": `{"response_url":"` + slow.URL + `"}`,
+			"state": map[string]interface{}{"values": map[string]interface{}{
+				projectBlock: map[string]interface{}{projectAction: map[string]interface{}{
+					"type":            "static_select",
+					"selected_option": map[string]interface{}{"value": "acme"},
+				}},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, err := p.Stop(5 * time.Second); err != nil || code != 0 {
+		t.Fatalf("exit status %d, %v", code, err)
+	}
+	select {
+	case body := <-confirmed:
+		if !strings.Contains(body, "Timer started on acme.") {
+			t.Errorf("confirmation = %s", body)
+		}
+	default:
+		t.Error("timerbot exited before confirming the timer")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/aitva/slackbot/blockkit"
+	"github.com/aitva/slackbot/interact"
+	"github.com/aitva/slackbot/timer"
+)
+
+// Interaction IDs.
+const (
+	startModalID  = "timer_start"
+	openStartID   = "timer_open_start"
+	projectBlock  = "project"
+	projectAction = "project"
+)
+
+// startMeta is passed through the private metadata of the start
+// modal, to answer where the modal was opened.
+type startMeta struct {
+	ResponseURL string `json:"response_url,omitempty"`
+}
+
+// startModal asks the project to start a timer on.
+func startModal(projects []string, meta string) *blockkit.View {
+	var opts []*blockkit.Option
+	for _, p := range projects {
+		opts = append(opts, blockkit.Opt(p, p))
+	}
+	v := blockkit.Modal(startModalID, "Start timer",
+		&blockkit.Input{
+			BlockID: projectBlock,
+			Label:   blockkit.Plain("Project"),
+			Element: &blockkit.StaticSelect{
+				ActionID:    projectAction,
+				Placeholder: blockkit.Plain("Pick a project"),
+				Options:     opts,
+			},
+		},
+	)
+	v.Submit = blockkit.Plain("Start")
+	v.Close = blockkit.Plain("Cancel")
+	v.PrivateMetadata = meta
+	return v
+}
+
+// openStartModal opens the start modal in answer to triggerID.
+func openStartModal(ctx context.Context, triggerID, responseURL string) error {
+	projects := global.Timers.ListProjects()
+	if len(projects) == 0 {
+		return timer.ErrNoProject
+	}
+	meta, err := interact.Metadata(startMeta{ResponseURL: responseURL})
+	if err != nil {
+		return err
+	}
+	_, err = global.Views.OpenView(ctx, triggerID, startModal(projects, meta))
+	return err
+}
+
+// newInteractions registers the handlers of buttons and modals.
+func newInteractions(secret string) *interact.Router {
+	r := interact.NewRouter(secret)
+	r.HandleFunc(interact.BlockActions, openStartID, func(ctx context.Context, p *interact.Payload) (*interact.Response, error) {
+		err := openStartModal(ctx, p.TriggerID, p.ResponseURL)
+		if err == timer.ErrNoProject && p.ResponseURL != "" {
+			return nil, global.Views.Respond(ctx, p.ResponseURL, map[string]interface{}{
+				"response_type":    "ephemeral",
+				"replace_original": false,
+				"text":             "No project yet, add one with `timer project add <name>`.",
+			})
+		}
+		return nil, err
+	})
+	r.HandleFunc(interact.BlockActions, stopID, handleStop)
+	r.HandleFunc(interact.ViewSubmission, startModalID, func(ctx context.Context, p *interact.Payload) (*interact.Response, error) {
+		project := p.View.State.Get(projectBlock, projectAction).String()
+		e, err := global.Timers.Start(p.User.ID, project)
+		switch err {
+		case nil:
+		case timer.ErrRunning:
+			return interact.Errors(map[string]string{projectBlock: "A timer is already running, stop it first."}), nil
+		case timer.ErrNoProject:
+			return interact.Errors(map[string]string{projectBlock: "This project no longer exists."}), nil
+		default:
+			return nil, err
+		}
+
+		var meta startMeta
+		if err := p.View.Metadata(&meta); err != nil {
+			slog.Error("fail to read modal metadata", "user", p.User.ID, "err", err)
+		}
+		if meta.ResponseURL != "" {
+			global.Background.Add(1)
+			go func() {
+				defer global.Background.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), interact.Deadline)
+				defer cancel()
+				err := global.Views.Respond(ctx, meta.ResponseURL, map[string]interface{}{
+					"response_type":    "ephemeral",
+					"replace_original": false,
+					"text":             fmt.Sprintf("Timer started on %s.", timer.ProjectName(e.Project)),
+				})
+				if err != nil {
+					slog.Error("fail to confirm timer", "user", p.User.ID, "err", err)
+				}
+			}()
+		}
+		return nil, nil
+	})
+	return r
+}
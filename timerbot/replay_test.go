@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/aitva/slackbot/cassette"
+	"github.com/aitva/slackbot/outbox"
+	"github.com/aitva/slackbot/pool"
+	"github.com/aitva/slackbot/rtm"
+	"github.com/aitva/slackbot/slackenv"
+	"github.com/aitva/slackbot/timer"
+)
+
+// replay plays the cassette in filename to the workers, and checks
+// timerbot answers like during the recording.
+func replay(t *testing.T, filename string) {
+	p, err := cassette.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.StartMsg = &rtm.Start{}
+	if err := p.Start(global.StartMsg); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	t.Setenv("TIMERBOT_DIALOGS", filepath.Join(dir, "dialogs.json"))
+	global.RTM = rtm.NewClient("", slackenv.Default())
+	global.Timers, err = timer.Load(filepath.Join(dir, "timerbot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.Dialogs, err = newDialogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	global.Router = newRouter()
+	global.Threads = &threadConfig{}
+	// Replies leave at once, in order.
+	id := 0
+	global.Outbox = outbox.New(func(msg *rtm.Message) error {
+		msg.ID = id
+		id++
+		return global.RTM.Send(p, msg)
+	})
+	global.Outbox.PerChannel, global.Outbox.Global = 0, 0
+	go global.Outbox.Run()
+
+	// One worker answers the messages in the order they came.
+	workers := pool.New(writeRTM())
+	workers.Size = 1
+	ctx, stop := context.WithCancel(context.Background())
+	workers.Start(ctx)
+	go workers.Read(ctx, p, slog.Default())
+	if err := p.Wait(); err != nil {
+		t.Error(err)
+	}
+	stop()
+	workers.Close()
+	if err := global.Outbox.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no cassette in testdata")
+	}
+	for _, filename := range files {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			replay(t, filename)
+		})
+	}
+}
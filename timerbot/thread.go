@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aitva/slackbot/rtm"
+)
+
+// threadConfig decides which replies go in a thread. Commands sent
+// in a thread are always answered in it.
+type threadConfig struct {
+	// Commands are answered in a thread wherever they are sent.
+	Commands map[string]bool
+	// Channels have every command answered in a thread.
+	Channels map[string]bool
+	// Broadcast commands also show their threaded replies in the
+	// channel.
+	Broadcast map[string]bool
+}
+
+// envSet reads a comma separated list from the environment.
+func envSet(name string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// threadConfigFromEnv reads TIMERBOT_THREAD_COMMANDS,
+// TIMERBOT_THREAD_CHANNELS and TIMERBOT_BROADCAST_COMMANDS.
+func threadConfigFromEnv() *threadConfig {
+	return &threadConfig{
+		Commands:  envSet("TIMERBOT_THREAD_COMMANDS"),
+		Channels:  envSet("TIMERBOT_THREAD_CHANNELS"),
+		Broadcast: envSet("TIMERBOT_BROADCAST_COMMANDS"),
+	}
+}
+
+// thread sets the thread of resp, the reply to the command name sent
+// in req. A handler can ask for a thread with wantThread.
+func (c *threadConfig) thread(req *rtm.Message, name string, wantThread bool, resp *rtm.Message) {
+	switch {
+	case req.ThreadTS != "":
+		resp.ThreadTS = req.ThreadTS
+	case wantThread || c.Commands[name] || c.Channels[req.Channel]:
+		resp.ThreadTS = req.TS
+	default:
+		return
+	}
+	resp.ReplyBroadcast = c.Broadcast[name]
+}